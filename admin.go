@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// RedactedValue replaces the tokens in the logs and in the admin API
+	RedactedValue = "REDACTED"
+)
+
+// WatcherInfo is the JSON representation of a watcher in the admin API
+type WatcherInfo struct {
+	Name string `json:"name"`
+	// Running is false on the replicas which are not the leader, and until the watchers have been started
+	Running bool                `json:"running"`
+	Paused  bool                `json:"paused"`
+	Config  BuildsWatcherConfig `json:"config"`
+	Health  *WatcherHealth      `json:"health,omitempty"`
+}
+
+// NotifierInfo is the JSON representation of a notifier in the admin API
+type NotifierInfo struct {
+	Name string `json:"name"`
+	// Config is the effective config of the notifier - without its token
+	Config NotifierConfig `json:"config"`
+	Health NotifierHealth `json:"health"`
+	// Deliveries are the most recent deliveries, the latest first
+	Deliveries []DeliveryRecord `json:"deliveries"`
+}
+
+// Watchers returns the watchers of the current configuration
+func (runtime *Runtime) Watchers() []WatcherInfo {
+	runtime.mutex.Lock()
+	defer runtime.mutex.Unlock()
+
+	watchers := []WatcherInfo{}
+	if runtime.config == nil {
+		return watchers
+	}
+	for _, name := range sortedKeys(runtime.config.BuildsWatchers) {
+		info := WatcherInfo{
+			Name:   name,
+			Paused: runtime.paused[name],
+			Config: *runtime.config.BuildsWatchers[name],
+		}
+		if task, running := runtime.watchers[name]; running {
+			health := task.watcher.Status.Health()
+			info.Running = true
+			info.Health = &health
+		}
+		watchers = append(watchers, info)
+	}
+	return watchers
+}
+
+// Notifiers returns the running notifiers - including the notifiers of the flows of the annotations
+func (runtime *Runtime) Notifiers() []NotifierInfo {
+	health := runtime.Health()
+	buffers := runtime.Dispatcher.Buffers()
+
+	notifiers := []NotifierInfo{}
+	for _, name := range sortedKeys(buffers) {
+		info := NotifierInfo{
+			Name:       name,
+			Health:     health.Notifiers[name],
+			Deliveries: []DeliveryRecord{},
+		}
+		switch notifier := buffers[name].Notifier.(type) {
+		case *FlowdockNotifier:
+			info.Config = notifier.Config.Redacted()
+			info.Deliveries = notifier.Status.History()
+		case *JsonNotifier:
+			info.Config = notifier.Config.Redacted()
+			info.Deliveries = notifier.Status.History()
+		}
+		notifiers = append(notifiers, info)
+	}
+	return notifiers
+}
+
+// PauseWatcher pauses or resumes the given watcher - it stays paused when it is restarted
+// It returns false if the watcher is not in the current configuration.
+func (runtime *Runtime) PauseWatcher(name string, paused bool) bool {
+	runtime.mutex.Lock()
+	defer runtime.mutex.Unlock()
+
+	if runtime.config == nil || runtime.config.BuildsWatchers[name] == nil {
+		return false
+	}
+	if paused {
+		runtime.paused[name] = true
+	} else {
+		delete(runtime.paused, name)
+	}
+	if task, running := runtime.watchers[name]; running {
+		task.watcher.Status.SetPaused(paused)
+	}
+	return true
+}
+
+// SendTestNotification sends a notification for a sample build in the given namespace and phase,
+// through the given running notifier - immediately and without retries
+func (runtime *Runtime) SendTestNotification(name string, namespace string, phase string) (*buildapi.Build, error) {
+	buffer, found := runtime.Dispatcher.Buffers()[name]
+	if !found {
+		return nil, fmt.Errorf("unknown notifier %s", name)
+	}
+	sender, isSender := buffer.Notifier.(Sender)
+	if !isSender {
+		return nil, fmt.Errorf("notifier %s can't send a test notification", name)
+	}
+
+	build := NewSampleBuild()
+	if len(namespace) > 0 {
+		build.Namespace = namespace
+	}
+	build.Status.Phase = buildapi.BuildPhase(phase)
+	if err := sender.Send(NewOfflineBuildEvent(build, transitionForPhase(build.Status.Phase))); err != nil {
+		return nil, fmt.Errorf("failed to send the test notification: %v", err)
+	}
+	return build, nil
+}
+
+// adminHandler serves the admin API, for the requests authenticated with the given token:
+//
+//	GET  /admin/watchers
+//	POST /admin/watchers/NAME/pause
+//	POST /admin/watchers/NAME/resume
+//	GET  /admin/notifiers
+//	GET  /admin/notifiers/NAME/deliveries
+//	POST /admin/notifiers/NAME/mute?duration=1h
+//	POST /admin/notifiers/NAME/unmute
+//	POST /admin/notifiers/NAME/test?phase=Failed&namespace=NAMESPACE
+//
+// The names of the notifiers of the flows of the annotations contain slashes: only the last
+// segment of the path is the action.
+func adminHandler(runtime *Runtime, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeAdminError(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/admin/")
+		switch {
+		case path == "watchers":
+			if !requireMethod(w, r, "GET") {
+				return
+			}
+			writeAdminResponse(w, runtime.Watchers())
+			return
+		case path == "notifiers":
+			if !requireMethod(w, r, "GET") {
+				return
+			}
+			writeAdminResponse(w, runtime.Notifiers())
+			return
+		}
+
+		index := strings.LastIndex(path, "/")
+		if index < 0 {
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown path %s", r.URL.Path))
+			return
+		}
+		resource, action := path[:index], path[index+1:]
+		switch {
+		case strings.HasPrefix(resource, "watchers/"):
+			name := strings.TrimPrefix(resource, "watchers/")
+			if action != "pause" && action != "resume" {
+				writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown action %s for watcher %s", action, name))
+				return
+			}
+			if !requireMethod(w, r, "POST") {
+				return
+			}
+			if !runtime.PauseWatcher(name, action == "pause") {
+				writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown watcher %s", name))
+				return
+			}
+			glog.Infof("Watcher %s has been %sd through the admin API", name, action)
+			writeAdminMessage(w, fmt.Sprintf("watcher %s has been %sd", name, action))
+
+		case strings.HasPrefix(resource, "notifiers/"):
+			name := strings.TrimPrefix(resource, "notifiers/")
+			if !runtime.Dispatcher.HasNotifier(name) {
+				writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown notifier %s", name))
+				return
+			}
+			handleNotifierAction(w, r, runtime, name, action)
+
+		default:
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown path %s", r.URL.Path))
+		}
+	}
+}
+
+func handleNotifierAction(w http.ResponseWriter, r *http.Request, runtime *Runtime, name string, action string) {
+	switch action {
+	case "deliveries":
+		if !requireMethod(w, r, "GET") {
+			return
+		}
+		for _, notifier := range runtime.Notifiers() {
+			if notifier.Name == name {
+				writeAdminResponse(w, notifier.Deliveries)
+				return
+			}
+		}
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown notifier %s", name))
+
+	case "mute":
+		if !requireMethod(w, r, "POST") {
+			return
+		}
+		duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || duration <= 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("invalid duration %q: a positive duration such as 30m is required", r.URL.Query().Get("duration")))
+			return
+		}
+		until := time.Now().Add(duration)
+		runtime.Dispatcher.Mute(name, until)
+		glog.Infof("Notifier %s has been muted until %v through the admin API", name, until.Format(time.RFC3339))
+		writeAdminMessage(w, fmt.Sprintf("notifier %s has been muted until %v", name, until.Format(time.RFC3339)))
+
+	case "unmute":
+		if !requireMethod(w, r, "POST") {
+			return
+		}
+		runtime.Dispatcher.Mute(name, time.Time{})
+		glog.Infof("Notifier %s has been unmuted through the admin API", name)
+		writeAdminMessage(w, fmt.Sprintf("notifier %s has been unmuted", name))
+
+	case "test":
+		if !requireMethod(w, r, "POST") {
+			return
+		}
+		phase := r.URL.Query().Get("phase")
+		if len(phase) == 0 {
+			phase = string(buildapi.BuildPhaseFailed)
+		}
+		if !containsString(buildPhases, phase) {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("unknown build phase %s%s", phase, didYouMean(phase, buildPhases)))
+			return
+		}
+		build, err := runtime.SendTestNotification(name, r.URL.Query().Get("namespace"), phase)
+		if err != nil {
+			writeAdminError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeAdminMessage(w, fmt.Sprintf("sent a test notification for build %s/%s (%s) to notifier %s", build.Namespace, build.Name, phase, name))
+
+	default:
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("unknown action %s for notifier %s", action, name))
+	}
+}
+
+// authorized returns true if the request has the given bearer token - compared in constant time
+func authorized(r *http.Request, token string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) == 1
+}
+
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAdminError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed, use %s", r.Method, method))
+	return false
+}
+
+func writeAdminMessage(w http.ResponseWriter, message string) {
+	writeAdminResponse(w, map[string]string{"message": message})
+}
+
+func writeAdminError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": message}); err != nil {
+		glog.Warningf("Failed to write the response of the admin API: %v", err)
+	}
+}
+
+func writeAdminResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		glog.Warningf("Failed to write the response of the admin API: %v", err)
+	}
+}
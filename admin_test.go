@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+)
+
+func TestAdminHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := &AppConfig{
+		BuildsWatchers: map[string]*BuildsWatcherConfig{
+			"all": {AllNamespaces: true, Notifiers: []string{"default", "ops"}},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"default": {Type: "json", Output: filepath.Join(dir, "notifications.jsonl")},
+			"ops":     {Token: "secret-token"},
+		},
+	}
+	config.SetDefaults()
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error, 1))
+	runtime.DryRun = true
+	if err := runtime.Apply(config); err != nil {
+		t.Fatalf("Failed to apply the configuration: %v", err)
+	}
+	defer runtime.Drain()
+	server, err := NewHttpServer(HttpConfig{WatchTimeout: "5m", NotifierFailureTimeout: "15m", AdminToken: "admin-token"}, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method             string
+		path               string
+		token              string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"GET", "/admin/watchers", "", http.StatusUnauthorized, "a valid bearer token is required"},
+		{"GET", "/admin/watchers", "wrong-token", http.StatusUnauthorized, "a valid bearer token is required"},
+		{"GET", "/admin/watchers", "admin-token", http.StatusOK, `"paused":false`},
+		{"GET", "/admin/watchers/all/pause", "admin-token", http.StatusMethodNotAllowed, "method GET is not allowed"},
+		{"POST", "/admin/watchers/unknown/pause", "admin-token", http.StatusNotFound, "unknown watcher unknown"},
+		{"POST", "/admin/watchers/all/pause", "admin-token", http.StatusOK, "watcher all has been paused"},
+		{"GET", "/admin/watchers", "admin-token", http.StatusOK, `"paused":true`},
+		{"POST", "/admin/watchers/all/resume", "admin-token", http.StatusOK, "watcher all has been resumed"},
+		{"POST", "/admin/notifiers/ops/mute", "admin-token", http.StatusBadRequest, "a positive duration such as 30m is required"},
+		{"POST", "/admin/notifiers/ops/mute?duration=1h", "admin-token", http.StatusOK, "notifier ops has been muted until"},
+		{"POST", "/admin/notifiers/default/test?phase=Unknown", "admin-token", http.StatusBadRequest, "unknown build phase Unknown"},
+		{"POST", "/admin/notifiers/default/test?phase=Complete&namespace=team", "admin-token", http.StatusOK, "sent a test notification for build team/"},
+		{"GET", "/admin/notifiers/default/deliveries", "admin-token", http.StatusOK, `"project":"team"`},
+		{"GET", "/admin/notifiers/unknown/deliveries", "admin-token", http.StatusNotFound, "unknown notifier unknown"},
+		{"GET", "/admin/notifiers", "admin-token", http.StatusOK, `"Token":"REDACTED"`},
+		{"GET", "/admin/notifiers", "admin-token", http.StatusOK, `"mutedUntil":`},
+		{"GET", "/admin/unknown", "admin-token", http.StatusNotFound, "unknown path /admin/unknown"},
+	}
+
+	for count, test := range tests {
+		request, err := http.NewRequest(test.method, test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(test.token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		recorder := httptest.NewRecorder()
+		server.Mux.ServeHTTP(recorder, request)
+		if recorder.Code != test.expectedStatusCode {
+			t.Errorf("Test[%d] Failed: Expected status code '%v' but got '%v'", count, test.expectedStatusCode, recorder.Code)
+		}
+		if !strings.Contains(recorder.Body.String(), test.expectedBody) {
+			t.Errorf("Test[%d] Failed: Expected a body containing '%v' but got '%v'", count, test.expectedBody, recorder.Body.String())
+		}
+		if strings.Contains(recorder.Body.String(), "secret-token") {
+			t.Errorf("Test[%d] Failed: Expected the token to be redacted but got '%v'", count, recorder.Body.String())
+		}
+		var response interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Errorf("Test[%d] Failed: invalid JSON response %s: %v", count, recorder.Body.String(), err)
+		}
+	}
+}
+
+func TestAdminHandlerRedactsFlowdockErrors(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message":"invalid flow token"}`))
+	}))
+	defer api.Close()
+
+	config := NotifierConfig{Token: "secret-token"}
+	config.SetDefaults()
+	notifier, err := NewFlowdockNotifier("ops", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.FlowdockClient.RestURL, _ = url.Parse(api.URL + "/")
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error))
+	if err := runtime.Dispatcher.AddNotifier("ops", notifier, config); err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Dispatcher.RemoveNotifier("ops")
+	if err := notifier.Send(NewSampleBuildEvent()); err == nil {
+		t.Fatalf("Expected the notification to fail with a 401")
+	}
+	server, err := NewHttpServer(HttpConfig{WatchTimeout: "5m", NotifierFailureTimeout: "15m", AdminToken: "admin-token"}, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for count, path := range []string{"/admin/notifiers", "/admin/notifiers/ops/deliveries"} {
+		request, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("Authorization", "Bearer admin-token")
+		recorder := httptest.NewRecorder()
+		server.Mux.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Test[%d] Failed: Expected status code '%v' but got '%v'", count, http.StatusOK, recorder.Code)
+		}
+		if !strings.Contains(recorder.Body.String(), "team_inbox/"+RedactedValue) {
+			t.Errorf("Test[%d] Failed: Expected a delivery with the redacted error but got '%v'", count, recorder.Body.String())
+		}
+		if strings.Contains(recorder.Body.String(), "secret-token") {
+			t.Errorf("Test[%d] Failed: Expected the token to be redacted but got '%v'", count, recorder.Body.String())
+		}
+	}
+}
+
+func TestDispatcherMute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NotifierConfig{Type: "json", Output: filepath.Join(dir, "notifications.jsonl")}
+	config.SetDefaults()
+	notifier, err := NewJsonNotifier("muted", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := NewDispatcher()
+	if err := dispatcher.AddNotifier("muted", notifier, config); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.Mute("muted", time.Now().Add(time.Hour))
+	dispatcher.Dispatch([]string{"muted"}, NewSampleBuildEvent())
+	dispatcher.Mute("muted", time.Time{})
+	dispatcher.Dispatch([]string{"muted"}, NewSampleBuildEvent())
+	dispatcher.RemoveNotifier("muted")
+
+	deliveries := notifier.Status.History()
+	if len(deliveries) != 1 {
+		t.Errorf("Expected 1 delivery once the notifier has been unmuted but got '%+v'", deliveries)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	buildutil "github.com/openshift/origin/pkg/build/util"
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// FlowsAnnotation is a comma-separated list of flows, on a namespace or a BuildConfig
+	FlowsAnnotation = "flowdock.notifier/flows"
+	// MuteAnnotation silences the notifications of a namespace or a BuildConfig, if "true"
+	MuteAnnotation = "flowdock.notifier/mute"
+	// SecretAnnotation is the name of the Secret holding the tokens of the flows - 1 key per flow
+	SecretAnnotation = "flowdock.notifier/secret"
+
+	DefaultFlowsSecret = "flowdock-notifier"
+
+	// the max time to wait for the first list of the namespaces and the BuildConfigs
+	annotationsSyncTimeout  = time.Minute
+	annotationsSyncInterval = 100 * time.Millisecond
+)
+
+// AnnotationSettings are the notification settings defined by the annotations of a namespace and a BuildConfig
+type AnnotationSettings struct {
+	Muted bool
+	// Flows are the flows to notify, in the Secret of the namespace
+	Flows  []string
+	Secret string
+}
+
+// AnnotationsCache is a watch-backed cache of the namespaces and BuildConfigs,
+// so that their annotations can be read without calling the API for every event
+type AnnotationsCache struct {
+	namespaces   cache.Store
+	buildConfigs cache.Store
+}
+
+// NewAnnotationsCache starts to watch the given namespace (or all the namespaces) and its BuildConfigs,
+// until the stop channel is closed
+// It returns once both have been listed, so that the settings of the first events are known.
+func NewAnnotationsCache(factory clientcmd.Factory, namespace string, allNamespaces bool, stop <-chan struct{}) (*AnnotationsCache, error) {
+	oclient, kclient, err := factory.Clients()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceSelector := fields.Everything()
+	if allNamespaces {
+		namespace = kapi.NamespaceAll
+	} else {
+		if len(namespace) == 0 {
+			namespace, _, err = factory.OpenShiftClientConfig.Namespace()
+			if err != nil {
+				return nil, err
+			}
+		}
+		namespaceSelector = fields.OneTermEqualSelector("metadata.name", namespace)
+	}
+
+	annotationsCache := &AnnotationsCache{
+		namespaces:   cache.NewStore(cache.MetaNamespaceKeyFunc),
+		buildConfigs: cache.NewStore(cache.MetaNamespaceKeyFunc),
+	}
+
+	namespacesReflector := cache.NewReflector(&cache.ListWatch{
+		ListFunc: func() (runtime.Object, error) {
+			return kclient.Namespaces().List(labels.Everything(), namespaceSelector)
+		},
+		WatchFunc: func(resourceVersion string) (watch.Interface, error) {
+			return kclient.Namespaces().Watch(labels.Everything(), namespaceSelector, resourceVersion)
+		},
+	}, &kapi.Namespace{}, annotationsCache.namespaces, 0)
+	namespacesReflector.RunUntil(stop)
+
+	buildConfigsReflector := cache.NewReflector(&cache.ListWatch{
+		ListFunc: func() (runtime.Object, error) {
+			return oclient.BuildConfigs(namespace).List(labels.Everything(), fields.Everything())
+		},
+		WatchFunc: func(resourceVersion string) (watch.Interface, error) {
+			return oclient.BuildConfigs(namespace).Watch(labels.Everything(), fields.Everything(), resourceVersion)
+		},
+	}, &buildapi.BuildConfig{}, annotationsCache.buildConfigs, 0)
+	buildConfigsReflector.RunUntil(stop)
+
+	if err := waitForSync(annotationsSyncTimeout, stop, namespacesReflector, buildConfigsReflector); err != nil {
+		return nil, err
+	}
+	return annotationsCache, nil
+}
+
+// waitForSync waits until the given reflectors have listed their objects
+func waitForSync(timeout time.Duration, stop <-chan struct{}, reflectors ...*cache.Reflector) error {
+	ticker := time.NewTicker(annotationsSyncInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		synced := true
+		for _, reflector := range reflectors {
+			if len(reflector.LastSyncResourceVersion()) == 0 {
+				synced = false
+			}
+		}
+		if synced {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return fmt.Errorf("the namespaces and the BuildConfigs have not been listed after %v", timeout)
+		case <-stop:
+			return fmt.Errorf("stopped before the namespaces and the BuildConfigs have been listed")
+		}
+	}
+}
+
+// Settings returns the settings of the given build
+// The annotations of the BuildConfig win over the annotations of the namespace
+func (annotationsCache *AnnotationsCache) Settings(build *buildapi.Build) AnnotationSettings {
+	annotations := []map[string]string{}
+	if obj, exists, _ := annotationsCache.namespaces.GetByKey(build.Namespace); exists {
+		annotations = append(annotations, obj.(*kapi.Namespace).Annotations)
+	}
+	if configName := buildutil.ConfigNameForBuild(build); len(configName) > 0 {
+		if obj, exists, _ := annotationsCache.buildConfigs.GetByKey(build.Namespace + "/" + configName); exists {
+			annotations = append(annotations, obj.(*buildapi.BuildConfig).Annotations)
+		}
+	}
+	return NewAnnotationSettings(annotations...)
+}
+
+// NewAnnotationSettings merges the given annotations - the last ones win
+func NewAnnotationSettings(annotations ...map[string]string) AnnotationSettings {
+	settings := AnnotationSettings{
+		Secret: DefaultFlowsSecret,
+	}
+	for _, annotation := range annotations {
+		if value, found := annotation[MuteAnnotation]; found {
+			muted, err := strconv.ParseBool(value)
+			if err != nil {
+				glog.Warningf("Ignoring invalid %s annotation %s: %v", MuteAnnotation, value, err)
+			} else {
+				settings.Muted = muted
+			}
+		}
+		if value, found := annotation[FlowsAnnotation]; found {
+			settings.Flows = []string{}
+			for _, flow := range strings.Split(value, ",") {
+				if flow = strings.TrimSpace(flow); len(flow) > 0 {
+					settings.Flows = append(settings.Flows, flow)
+				}
+			}
+		}
+		if value, found := annotation[SecretAnnotation]; found && len(value) > 0 {
+			settings.Secret = value
+		}
+	}
+	return settings
+}
+
+// FlowNotifiers creates - on demand - the Flowdock notifiers for the flows defined by annotations
+// They are based on the config of an existing notifier, with the token of the flow
+// read from the Secret of the namespace.
+type FlowNotifiers struct {
+	tokens     *SecretTokens
+	dispatcher *Dispatcher
+	configs    map[string]*NotifierConfig
+	flows      map[string]flowNotifier
+	mutex      sync.Mutex
+}
+
+// flowNotifier identifies the notifier of a flow
+type flowNotifier struct {
+	baseNotifier string
+	namespace    string
+	secret       string
+	flow         string
+}
+
+func NewFlowNotifiers(tokens *SecretTokens, dispatcher *Dispatcher, configs map[string]*NotifierConfig) *FlowNotifiers {
+	return &FlowNotifiers{
+		tokens:     tokens,
+		dispatcher: dispatcher,
+		configs:    configs,
+		flows:      make(map[string]flowNotifier),
+	}
+}
+
+// Notifier returns the name of the notifier for the given flow - creating and registering it if needed
+func (flowNotifiers *FlowNotifiers) Notifier(baseNotifier string, namespace string, secret string, flow string) (string, error) {
+	name := fmt.Sprintf("%s:%s/%s/%s", baseNotifier, namespace, secret, flow)
+
+	flowNotifiers.mutex.Lock()
+	defer flowNotifiers.mutex.Unlock()
+
+	if flowNotifiers.dispatcher.HasNotifier(name) {
+		return name, nil
+	}
+
+	key := flowNotifier{
+		baseNotifier: baseNotifier,
+		namespace:    namespace,
+		secret:       secret,
+		flow:         flow,
+	}
+	notifier, config, err := flowNotifiers.newNotifier(name, key)
+	if err != nil {
+		return "", err
+	}
+	if err := flowNotifiers.dispatcher.AddNotifier(name, notifier, config); err != nil {
+		return "", err
+	}
+	flowNotifiers.flows[name] = key
+	glog.Infof("Created notifier %s for flow %s of namespace %s", name, flow, namespace)
+	return name, nil
+}
+
+// Reload replaces the configs of the base notifiers, and restarts the notifiers of the flows
+// whose base notifier has changed - or stops them if it has been removed
+func (flowNotifiers *FlowNotifiers) Reload(configs map[string]*NotifierConfig) {
+	flowNotifiers.mutex.Lock()
+	defer flowNotifiers.mutex.Unlock()
+
+	previousConfigs := flowNotifiers.configs
+	flowNotifiers.configs = configs
+	for name, key := range flowNotifiers.flows {
+		if reflect.DeepEqual(previousConfigs[key.baseNotifier], configs[key.baseNotifier]) {
+			continue
+		}
+		notifier, config, err := flowNotifiers.newNotifier(name, key)
+		if err != nil {
+			glog.Warningf("Stopping notifier %s for flow %s of namespace %s: %v", name, key.flow, key.namespace, err)
+			if previous := flowNotifiers.dispatcher.RemoveNotifier(name); previous != nil {
+				stopNotifier(name, previous)
+			}
+			delete(flowNotifiers.flows, name)
+			continue
+		}
+		if err := flowNotifiers.dispatcher.ReplaceNotifier(name, notifier, config, handOverNotifier(name, notifier)); err != nil {
+			glog.Errorf("Failed to restart notifier %s for flow %s of namespace %s: %v", name, key.flow, key.namespace, err)
+			continue
+		}
+		glog.Infof("Restarted notifier %s for flow %s of namespace %s", name, key.flow, key.namespace)
+	}
+}
+
+// newNotifier creates the notifier of the given flow, from the current config of its base notifier
+func (flowNotifiers *FlowNotifiers) newNotifier(name string, key flowNotifier) (*FlowdockNotifier, NotifierConfig, error) {
+	baseConfig, found := flowNotifiers.configs[key.baseNotifier]
+	if !found {
+		return nil, NotifierConfig{}, fmt.Errorf("unknown notifier %s", key.baseNotifier)
+	}
+	if baseConfig.Type != FlowdockNotifierType {
+		return nil, NotifierConfig{}, fmt.Errorf("notifier %s is a %s notifier, not a %s notifier", key.baseNotifier, baseConfig.Type, FlowdockNotifierType)
+	}
+
+	config := *baseConfig
+	config.Token = ""
+	config.TokenSecret = SecretKeyRef{}
+	// the pending deliveries of the flows are not persisted: their queue would be shared with the base notifier
+	config.QueueDir = ""
+	notifier, err := NewFlowdockNotifier(name, config)
+	if err != nil {
+		return nil, NotifierConfig{}, err
+	}
+	notifier.TokenSource = func(string) (string, error) {
+		return flowNotifiers.tokens.Token(key.namespace, key.secret, key.flow)
+	}
+	return notifier, config, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+func TestNewAnnotationSettings(t *testing.T) {
+	tests := []struct {
+		annotations      []map[string]string
+		expectedSettings AnnotationSettings
+	}{
+		{
+			annotations:      []map[string]string{},
+			expectedSettings: AnnotationSettings{Secret: DefaultFlowsSecret},
+		},
+		{
+			annotations: []map[string]string{
+				{FlowsAnnotation: "team, ops,", SecretAnnotation: "tokens"},
+			},
+			expectedSettings: AnnotationSettings{Flows: []string{"team", "ops"}, Secret: "tokens"},
+		},
+		// the BuildConfig annotations should win over the namespace annotations
+		{
+			annotations: []map[string]string{
+				{FlowsAnnotation: "team", MuteAnnotation: "true"},
+				{FlowsAnnotation: "app", MuteAnnotation: "false"},
+			},
+			expectedSettings: AnnotationSettings{Flows: []string{"app"}, Secret: DefaultFlowsSecret},
+		},
+		{
+			annotations: []map[string]string{
+				{FlowsAnnotation: "team"},
+				{MuteAnnotation: "true"},
+			},
+			expectedSettings: AnnotationSettings{Muted: true, Flows: []string{"team"}, Secret: DefaultFlowsSecret},
+		},
+		// should ignore invalid mute annotations
+		{
+			annotations: []map[string]string{
+				{MuteAnnotation: "true"},
+				{MuteAnnotation: "maybe"},
+			},
+			expectedSettings: AnnotationSettings{Muted: true, Secret: DefaultFlowsSecret},
+		},
+	}
+
+	for count, test := range tests {
+		result := NewAnnotationSettings(test.annotations...)
+		if !reflect.DeepEqual(result, test.expectedSettings) {
+			t.Errorf("Test[%d] Failed: Expected '%+v' but got '%+v'", count, test.expectedSettings, result)
+		}
+	}
+}
+
+func TestWaitForSync(t *testing.T) {
+	newReflector := func(listErr error, stop <-chan struct{}) *cache.Reflector {
+		reflector := cache.NewReflector(&cache.ListWatch{
+			ListFunc: func() (runtime.Object, error) {
+				if listErr != nil {
+					return nil, listErr
+				}
+				return &kapi.NamespaceList{ListMeta: unversioned.ListMeta{ResourceVersion: "42"}}, nil
+			},
+			WatchFunc: func(resourceVersion string) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		}, &kapi.Namespace{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+		reflector.RunUntil(stop)
+		return reflector
+	}
+
+	tests := []struct {
+		listErrors    []error
+		stopped       bool
+		expectedError bool
+	}{
+		// should wait for all the reflectors to be synced
+		{listErrors: []error{nil, nil}, expectedError: false},
+		// should time out if a reflector can't list its objects
+		{listErrors: []error{nil, fmt.Errorf("forbidden")}, expectedError: true},
+		// should not wait once stopped
+		{listErrors: []error{fmt.Errorf("forbidden")}, stopped: true, expectedError: true},
+	}
+
+	for count, test := range tests {
+		stop := make(chan struct{})
+		reflectors := []*cache.Reflector{}
+		for _, listErr := range test.listErrors {
+			reflectors = append(reflectors, newReflector(listErr, stop))
+		}
+		timeout := time.Second
+		if test.stopped {
+			close(stop)
+			timeout = time.Hour
+		}
+		err := waitForSync(timeout, stop, reflectors...)
+		if (err != nil) != test.expectedError {
+			t.Errorf("Test[%d] Failed: Expected error '%v' but got '%v'", count, test.expectedError, err)
+		}
+		if !test.stopped {
+			close(stop)
+		}
+	}
+}
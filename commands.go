@@ -0,0 +1,315 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+// commandOptions are the options shared by all the commands
+type commandOptions struct {
+	factory *clientcmd.Factory
+	// configFile is optional: by default, the config file is looked for in the CONFIG_PATH directory, or in the current directory
+	configFile string
+	// recordFile is optional: if set, the events received by the watchers are recorded in this file
+	recordFile string
+	// dryRun enables the dry-run mode of all the notifiers
+	dryRun bool
+}
+
+// NewRootCommand returns the command line of the application:
+// without subcommand, it runs the notifier - as the run command does
+func NewRootCommand() *cobra.Command {
+	options := &commandOptions{}
+	root := &cobra.Command{
+		Use:          "openshift-flowdock-notifier",
+		Short:        "Sends notifications to Flowdock for the OpenShift builds",
+		SilenceUsage: true,
+	}
+	root.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+	root.PersistentFlags().StringVar(&options.configFile, "config-file", "", "Path of the config file - by default, config.yml in the CONFIG_PATH directory, or in the current directory")
+	root.PersistentFlags().BoolVar(&options.dryRun, "dry-run", false, "Log the messages that the Flowdock notifiers would send, instead of sending them")
+	options.factory = getFactory(root.PersistentFlags())
+
+	runCommand := newRunCommand(options)
+	root.Run = runCommand.Run
+	root.Flags().AddFlagSet(runCommand.Flags())
+	root.AddCommand(
+		runCommand,
+		newValidateCommand(options),
+		newRenderCommand(options),
+		newSendTestCommand(options),
+		newReplayCommand(options),
+	)
+	return root
+}
+
+func newRunCommand(options *commandOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Watch the builds and send the notifications (the default command)",
+		Run: func(cmd *cobra.Command, args []string) {
+			runNotifier(options)
+		},
+	}
+	cmd.Flags().StringVar(&options.recordFile, "record", "", "Path of a file in which the events received by the watchers are recorded - to be replayed with the replay command")
+	return cmd
+}
+
+func newValidateCommand(options *commandOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate [CONFIG_FILE]",
+		Short: "Check the configuration, without calling the API",
+		Long: `Check the configuration - read from the given config file, or from the default config file - completed by the environment variables.
+All the problems are reported, with their path in the configuration. The templates are checked with a sample build.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			configFile := options.configFile
+			if len(args) > 0 {
+				configFile = args[0]
+			}
+			appConfig, err := LoadAppConfig(configFile)
+			if err != nil {
+				return err
+			}
+			if err := ValidateAppConfig(appConfig); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "The configuration is valid: %d builds watchers, %d notifiers and %d reports\n",
+				len(appConfig.BuildsWatchers), len(appConfig.Notifiers), len(appConfig.Reports))
+			return nil
+		},
+	}
+}
+
+func newRenderCommand(options *commandOptions) *cobra.Command {
+	var notifierName, file string
+	var digest, report bool
+	cmd := &cobra.Command{
+		Use:   "render [[NAMESPACE/]BUILD]",
+		Short: "Print the notification of a build, rendered with the templates of a notifier",
+		Long: `Print the subject, the tags and the content of the notification of a build, rendered with the templates of a notifier.
+The build is either fetched by name, or read from a YAML/JSON file (with --file) - or a sample build is used.
+The builds read from a file or the sample build are rendered without calling the API: their logs, events and node name are placeholders.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appConfig, err := LoadAppConfig(options.configFile)
+			if err != nil {
+				return err
+			}
+			notifierConfig, found := appConfig.Notifiers[notifierName]
+			if !found {
+				return fmt.Errorf("unknown notifier %s%s", notifierName, didYouMean(notifierName, sortedKeys(appConfig.Notifiers)))
+			}
+			templates, err := NewMessageTemplates(*notifierConfig)
+			if err != nil {
+				return err
+			}
+
+			var event Event
+			switch {
+			case len(file) > 0:
+				build, err := readBuild(file)
+				if err != nil {
+					return err
+				}
+				event = NewOfflineBuildEvent(build, transitionForPhase(build.Status.Phase))
+			case len(args) > 0:
+				build, err := getBuild(*options.factory, args[0])
+				if err != nil {
+					return err
+				}
+				event = NewBuildEvent(*options.factory, watch.Event{Type: watch.Modified, Object: build})
+			case digest:
+				event = NewSampleDigestEvent()
+			case report:
+				event = NewSampleBuildsReport()
+			default:
+				event = NewSampleBuildEvent()
+			}
+
+			message, err := templates.Render(event)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "Subject: %s\nTags: %s\n\n%s\n", message.Subject, strings.Join(message.Tags, " "), message.Content)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&notifierName, "notifier", DefaultNotifierName, "Name of the notifier whose templates are used")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Path of a YAML or JSON file holding the build")
+	cmd.Flags().BoolVar(&digest, "digest", false, "Render a sample digest, with the digest templates")
+	cmd.Flags().BoolVar(&report, "report", false, "Render a sample builds report, with the report templates")
+	return cmd
+}
+
+func newSendTestCommand(options *commandOptions) *cobra.Command {
+	var buildNamespace, phase string
+	cmd := &cobra.Command{
+		Use:   "send-test NOTIFIER",
+		Short: "Send a notification for a sample build to a notifier",
+		Long: `Send a notification for a sample build to a notifier, immediately and without retries.
+The pending deliveries of the notifier - in its queueDir - are left untouched.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("the name of the notifier is required")
+			}
+			notifierName := args[0]
+			appConfig, err := LoadAppConfig(options.configFile)
+			if err != nil {
+				return err
+			}
+			notifierConfig, found := appConfig.Notifiers[notifierName]
+			if !found {
+				return fmt.Errorf("unknown notifier %s%s", notifierName, didYouMean(notifierName, sortedKeys(appConfig.Notifiers)))
+			}
+			if !containsString(buildPhases, phase) {
+				return fmt.Errorf("unknown build phase %s%s", phase, didYouMean(phase, buildPhases))
+			}
+
+			config := *notifierConfig
+			config.QueueDir = ""
+			config.DryRun = config.DryRun || options.dryRun
+			runtime := NewRuntime(options.factory, nil, nil)
+			notifier, err := runtime.newNotifier(notifierName, config)
+			if err != nil {
+				return err
+			}
+			sender, isSender := notifier.(Sender)
+			if !isSender {
+				return fmt.Errorf("notifier %s can't send a test notification", notifierName)
+			}
+
+			build := NewSampleBuild()
+			build.Namespace = buildNamespace
+			build.Status.Phase = buildapi.BuildPhase(phase)
+			if err := sender.Send(NewOfflineBuildEvent(build, transitionForPhase(build.Status.Phase))); err != nil {
+				return fmt.Errorf("failed to send the test notification: %v", err)
+			}
+			if _, isFlowdock := notifier.(*FlowdockNotifier); isFlowdock && config.DryRun {
+				fmt.Fprintf(os.Stdout, "Logged the test notification for build %s/%s (%s) of notifier %s, which is in dry-run mode\n", build.Namespace, build.Name, phase, notifierName)
+				return nil
+			}
+			fmt.Fprintf(os.Stdout, "Sent a test notification for build %s/%s (%s) to notifier %s\n", build.Namespace, build.Name, phase, notifierName)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&buildNamespace, "build-namespace", NewSampleBuild().Namespace, "Namespace of the sample build - used to find the token of the notifiers reading it from the namespace of the events")
+	cmd.Flags().StringVar(&phase, "phase", string(buildapi.BuildPhaseFailed), "Phase of the sample build")
+	return cmd
+}
+
+func newReplayCommand(options *commandOptions) *cobra.Command {
+	var speed float64
+	cmd := &cobra.Command{
+		Use:   "replay FILE",
+		Short: "Replay the events recorded with --record through the watchers and the notifiers, without a cluster",
+		Long: `Replay the events recorded with --record through the watchers, the filters and the notifiers of the configuration, without calling the API.
+Each watcher replays the events recorded by the watcher with the same name, and the command returns once all the events have been notified.
+The notifications are rendered with placeholders for the logs, the events and the node name of the builds.
+The annotations, the reports and the stored states (statePath and dedupStatePath) are ignored.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("the path of the recording is required")
+			}
+			replay, err := NewReplay(args[0], speed)
+			if err != nil {
+				return err
+			}
+			appConfig, err := LoadAppConfig(options.configFile)
+			if err != nil {
+				return err
+			}
+			if len(appConfig.Reports) > 0 {
+				glog.Warningf("Ignoring the %d reports of the configuration: they can't be replayed", len(appConfig.Reports))
+				appConfig.Reports = nil
+			}
+			for _, watcherName := range replay.Watchers() {
+				if _, found := appConfig.BuildsWatchers[watcherName]; !found {
+					glog.Warningf("The events recorded by watcher %s won't be replayed: there is no such watcher in the configuration", watcherName)
+				}
+			}
+
+			errors := make(chan error, len(appConfig.BuildsWatchers))
+			runtime := NewRuntime(options.factory, nil, errors)
+			runtime.Replay = replay
+			runtime.DryRun = options.dryRun
+			if err := runtime.Apply(appConfig); err != nil {
+				return err
+			}
+			runtime.Start()
+			runtime.Drain()
+
+			select {
+			case err := <-errors:
+				return err
+			default:
+			}
+			fmt.Fprintf(os.Stdout, "Replayed %d events\n", len(replay.Events))
+			return nil
+		},
+	}
+	cmd.Flags().Float64Var(&speed, "speed", 0, "Replay speed: 1 replays the events at the pace they were recorded, 10 replays them 10 times faster, and 0 as fast as possible")
+	return cmd
+}
+
+// readBuild reads a build from a YAML or JSON file
+func readBuild(file string) (*buildapi.Build, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	obj, err := kapi.Scheme.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", file, err)
+	}
+	build, isBuild := obj.(*buildapi.Build)
+	if !isBuild {
+		return nil, fmt.Errorf("%s holds a %T, not a Build", file, obj)
+	}
+	return build, nil
+}
+
+// getBuild fetches the build [NAMESPACE/]NAME - in the current namespace by default
+func getBuild(factory clientcmd.Factory, name string) (*buildapi.Build, error) {
+	namespace := ""
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		namespace, name = parts[0], parts[1]
+	}
+	if len(namespace) == 0 {
+		var err error
+		if namespace, _, err = factory.OpenShiftClientConfig.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+	oclient, _, err := factory.Clients()
+	if err != nil {
+		return nil, err
+	}
+	return oclient.Builds(namespace).Get(name)
+}
+
+// transitionForPhase returns the transition of a build without history: a success or a failure
+func transitionForPhase(phase buildapi.BuildPhase) string {
+	switch phase {
+	case buildapi.BuildPhaseComplete:
+		return TransitionSuccess
+	case buildapi.BuildPhaseCancelled, buildapi.BuildPhaseError, buildapi.BuildPhaseFailed:
+		return TransitionFailure
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+)
+
+func TestReadBuild(t *testing.T) {
+	tests := []struct {
+		content       string
+		expectedError bool
+		expectedName  string
+		expectedPhase buildapi.BuildPhase
+	}{
+		{
+			content: `
+apiVersion: v1
+kind: Build
+metadata:
+  name: app-3
+  namespace: prod
+status:
+  phase: Complete
+`,
+			expectedName:  "app-3",
+			expectedPhase: buildapi.BuildPhaseComplete,
+		},
+		{
+			content:       `{"apiVersion": "v1", "kind": "Build", "metadata": {"name": "app-4"}, "status": {"phase": "Failed"}}`,
+			expectedName:  "app-4",
+			expectedPhase: buildapi.BuildPhaseFailed,
+		},
+		{
+			content:       `{"apiVersion": "v1", "kind": "BuildConfig", "metadata": {"name": "app"}}`,
+			expectedError: true,
+		},
+		{
+			content:       `not: [a build`,
+			expectedError: true,
+		},
+	}
+
+	for count, test := range tests {
+		file, err := ioutil.TempFile("", "build")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(file.Name())
+		file.WriteString(test.content)
+		file.Close()
+
+		build, err := readBuild(file.Name())
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("Test[%d] Failed: Expected an error", count)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		if build.Name != test.expectedName || build.Status.Phase != test.expectedPhase {
+			t.Errorf("Test[%d] Failed: Expected build '%v' in phase '%v' but got '%v' in phase '%v'", count, test.expectedName, test.expectedPhase, build.Name, build.Status.Phase)
+		}
+	}
+}
@@ -14,27 +14,242 @@ import (
 
 type AppConfig struct {
 	BuildsWatchers map[string]*BuildsWatcherConfig
-	Notifiers      map[string]*FlowdockNotifierConfig
+	Notifiers      map[string]*NotifierConfig
+	Reports        map[string]*ReportConfig
+	LeaderElection LeaderElectionConfig
+	Sharding       ShardingConfig
+	// QuietWindows apply to all the watchers
+	QuietWindows []QuietWindowConfig
+	// Routing routes the events of the watchers which don't have a static list of notifiers
+	Routing RoutingConfig
+	// ConfigMaps contribute more watchers and notifiers, scoped to their namespaces
+	ConfigMaps ConfigMapsConfig
+	// Http configures the HTTP server of the health endpoints
+	Http HttpConfig
+}
+
+type HttpConfig struct {
+	Enabled bool
+	// Address to listen on, such as ":8080"
+	Address string
+	// WatchTimeout is how long a running watcher can stay disconnected before /healthz fails, such as "5m"
+	WatchTimeout string
+	// NotifierFailureTimeout is how long a notifier can keep failing before /readyz fails, such as "15m"
+	NotifierFailureTimeout string
+	// AdminToken enables the admin API: its requests must have an "Authorization: Bearer <AdminToken>" header
+	AdminToken string
+}
+
+type ConfigMapsConfig struct {
+	Enabled bool
+	// LabelSelector selects the ConfigMaps holding a configuration
+	LabelSelector string
+	// Namespace to read the ConfigMaps from - defaults to the current namespace - or all the namespaces
+	Namespace     string
+	AllNamespaces bool
+	// Key of the ConfigMaps data holding the configuration, in YAML
+	Key string
+	// ResyncPeriod is the interval between 2 reads of the ConfigMaps, such as "30s"
+	ResyncPeriod string
+}
+
+type RoutingConfig struct {
+	Rules []RoutingRuleConfig
+	// DefaultNotifiers receive the events which don't match any rule
+	DefaultNotifiers []string
+}
+
+type RoutingRuleConfig struct {
+	Name string
+	// Expression such as `namespace matches "prod-.*" && isFailure && labels.team == "payments"`
+	Expression string
+	Notifiers  []string
+}
+
+// QuietWindowConfig is a time window during which the non-failure events are dropped or held
+// either recurring - with a cron Schedule for its start and a Duration - or one-off with a Start and an End
+type QuietWindowConfig struct {
+	Name     string
+	Schedule string
+	Duration string
+	Timezone string
+	// Start and End are RFC3339 times
+	Start string
+	End   string
+	// Mode is either "hold" (the default) to send the held events as a digest at the end of the window, or "drop"
+	Mode string
+}
+
+type ShardingConfig struct {
+	Enabled bool
+	// Identity of this replica - defaults to the hostname (the pod name)
+	Identity string
+	// Namespace and Name of the Endpoints object used for the coordination
+	// the namespace defaults to the current namespace
+	Namespace string
+	Name      string
+	// durations such as "5s"
+	HeartbeatInterval string
+	MemberTTL         string
+	HandoverDelay     string
+	// VirtualNodes is the number of virtual nodes per replica in the consistent hash ring
+	VirtualNodes int
+}
+
+type LeaderElectionConfig struct {
+	Enabled bool
+	// Identity of this replica - defaults to the hostname (the pod name)
+	Identity string
+	// Namespace and Name of the Endpoints object used as a lock
+	// the namespace defaults to the current namespace
+	Namespace string
+	Name      string
+	// durations such as "15s"
+	LeaseDuration string
+	RenewDeadline string
+	RetryPeriod   string
 }
 
 type BuildsWatcherConfig struct {
-	Namespace          string
-	AllNamespaces      bool
+	Namespace     string
+	AllNamespaces bool
+	// Notifiers is a static list of notifiers - if empty, the events are routed with the routing rules
 	Notifiers          []string
 	WatchForBuildPhase map[buildapi.BuildPhase]bool
+	// LabelSelector is passed to the watch, such as "team=payments,env!=dev"
+	LabelSelector string
+	// FieldSelector is evaluated client-side, such as "status!=Cancelled"
+	FieldSelector string
+	// IncludeBuildConfigs and ExcludeBuildConfigs are glob patterns on the name of the BuildConfig of the builds
+	IncludeBuildConfigs []string
+	ExcludeBuildConfigs []string
+	// IncludeNamespaces and ExcludeNamespaces are regexes on the namespace of the builds
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
+	// StatePath is where the last processed resourceVersion is persisted, to resume after a restart
+	// either a local file path, or "configmap:NAMESPACE/NAME"
+	StatePath string
+	// DedupMaxEntries and DedupTTL bound the memory of the last notified phase of each build
+	// which is used to notify only the real phase transitions
+	DedupMaxEntries int
+	DedupTTL        string
+	// DedupStatePath is where the last notified phases are persisted (same format as StatePath)
+	DedupStatePath string
+	// NotifyMode is either "all" (the default) or "state-change"
+	// to notify only the "broken", "still-failing" and "fixed" builds
+	NotifyMode string
+	// StillFailingEvery is the number of consecutive failures after which a "still-failing" build is notified
+	// defaults to DefaultStillFailingEvery - a negative value disables the "still-failing" notifications
+	StillFailingEvery int
+	// BatchWindow and BatchBypassFailures enable the batching of the events of this watcher
+	// the events are routed first, and each notifier receives a digest of its own events
+	BatchWindow         string
+	BatchBypassFailures bool
+	// QuietWindows apply to the events of this watcher (in addition to the global ones)
+	QuietWindows []QuietWindowConfig
+	// QuietNamespaceAnnotations enables the ad-hoc quiet windows defined by an annotation on the namespaces
+	QuietNamespaceAnnotations bool
+	// Annotations enables the routing and muting of the notifications with annotations on the namespaces and BuildConfigs
+	Annotations bool
+	// AnnotationsOptIn notifies only the namespaces and BuildConfigs which have a flows annotation
+	AnnotationsOptIn bool
+	// AnnotationsNotifier is the name of the notifier whose config is used for the flows defined by annotations
+	AnnotationsNotifier string
+}
+
+// SecretKeyRef references a key of a Secret
+type SecretKeyRef struct {
+	Name string
+	Key  string
+	// Namespace defaults to the namespace of the notifier
+	Namespace string
+	// EventNamespace reads the Secret in the namespace of each event, instead of a fixed namespace
+	EventNamespace bool
+}
+
+type ReportConfig struct {
+	// Namespace or AllNamespaces are the namespace(s) to report on - with 1 report per namespace
+	Namespace     string
+	AllNamespaces bool
+	Notifiers     []string
+	// Schedule is a cron spec such as "0 9 * * 1", in the Timezone (such as "Europe/Paris")
+	Schedule string
+	Timezone string
+	// Period is the duration covered by the report, such as "24h" or "168h"
+	Period string
+	// TopEntries is the max number of builds / BuildConfigs in the "longest pending" and "most failing" lists
+	TopEntries int
 }
 
-type FlowdockNotifierConfig struct {
-	Token           string
+type NotifierConfig struct {
+	// Type is the kind of notifier: "flowdock" (the default) or "json"
+	Type string
+
 	SubjectTemplate string
 	ContentTemplate string
-	FromAddress     string
-	FromName        string
-	Source          string
 	Tags            []string
+
+	// BatchWindow enables the batching of the events: they are collected during this window
+	// (a duration such as "5m"), and sent as a single digest
+	BatchWindow string
+	// BatchBypassFailures sends the failures immediately, instead of batching them
+	BatchBypassFailures   bool
+	DigestSubjectTemplate string
+	DigestContentTemplate string
+
+	ReportSubjectTemplate string
+	ReportContentTemplate string
+
+	// QuietWindows apply to the events sent to this notifier
+	QuietWindows []QuietWindowConfig
+
+	// BufferSize is the max number of events waiting to be handled by the notifier
+	BufferSize int
+	// OverflowPolicy is what to do when the buffer is full: "block" (the default), "drop-oldest" or "drop-newest"
+	OverflowPolicy string
+	// DryRun logs the messages that a flowdock notifier would send, instead of sending them
+	DryRun bool
+
+	// flowdock notifier
+	Token string
+	// TokenSecret references a Secret holding the token, instead of Token
+	TokenSecret SecretKeyRef
+	FromAddress string
+	FromName    string
+	Source      string
+	// retry of the failed deliveries, with an exponential backoff (durations such as "30s" or "5m")
+	RetryInitialBackoff string
+	RetryMaxBackoff     string
+	RetryMaxAge         string
+	// QueueDir is an optional directory in which the pending deliveries are stored
+	// (in a sub-directory named after the notifier) so that they survive a restart
+	QueueDir string
+	// DeadLetterPath is the file in which the undeliverable messages are written
+	// defaults to a file in the sub-directory of the QueueDir (if any), or to the logs
+	DeadLetterPath string
+
+	// json notifier
+	// Output is either "stdout" (the default) or the path of a file
+	Output string
+	// MaxFileSizeMB is the size (in MB) at which the output file is rotated
+	MaxFileSizeMB int
+	// MaxBackups is the number of rotated files to keep
+	// defaults to DefaultJsonMaxBackups - a negative value keeps no backup
+	MaxBackups int
 }
 
-func LoadAppConfig() (*AppConfig, error) {
+// LoadAppConfig reads the given config file - or looks for a config file in the CONFIG_PATH directory, or in the current directory
+// and completes it with the environment variables. A given config file must exist.
+func LoadAppConfig(configFile string) (*AppConfig, error) {
+	if len(configFile) > 0 {
+		glog.Infof("Loading configuration from file %s", configFile)
+		viper.SetConfigFile(configFile)
+		if err := viper.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %v", configFile, err)
+		}
+		return ReadAppConfig()
+	}
+
 	if path := os.Getenv("CONFIG_PATH"); len(path) > 0 {
 		glog.Infof("Loading configuration from path %s", path)
 		viper.AddConfigPath(path)
@@ -45,10 +260,18 @@ func LoadAppConfig() (*AppConfig, error) {
 		glog.Warningf("Failed to read config file (%s), falling back to environment variable defined configuration...", err)
 	}
 
+	return ReadAppConfig()
+}
+
+// ReadAppConfig returns the configuration read by viper, completed by the environment variables and the defaults
+func ReadAppConfig() (*AppConfig, error) {
 	appConfig := &AppConfig{}
 	if err := viper.Unmarshal(appConfig); err != nil {
 		return nil, err
 	}
+	if err := CheckConfigKeys(viper.AllSettings()); err != nil {
+		return nil, err
+	}
 
 	glog.V(1).Infof("Loaded configuration is %s", appConfig.String())
 
@@ -79,22 +302,48 @@ func (appConfig *AppConfig) HasNotifiers() bool {
 
 func (appConfig *AppConfig) SetFromEnvVar() error {
 	if appConfig.Notifiers == nil {
-		appConfig.Notifiers = make(map[string]*FlowdockNotifierConfig)
+		appConfig.Notifiers = make(map[string]*NotifierConfig)
 	}
 	if _, found := appConfig.Notifiers[DefaultNotifierName]; !found {
-		appConfig.Notifiers[DefaultNotifierName] = &FlowdockNotifierConfig{}
+		appConfig.Notifiers[DefaultNotifierName] = &NotifierConfig{}
 	}
-	if defaultToken := os.Getenv("NOTIFIERS_DEFAULT_TOKEN"); len(defaultToken) > 0 {
-		appConfig.Notifiers[DefaultNotifierName].Token = defaultToken
+	if err := appConfig.setNotifiersFromEnvVar(os.Environ()); err != nil {
+		return err
 	}
-	if defaultSource := os.Getenv("NOTIFIERS_DEFAULT_SOURCE"); len(defaultSource) > 0 {
-		appConfig.Notifiers[DefaultNotifierName].Source = defaultSource
+
+	if len(os.Getenv("ENABLE_LEADER_ELECTION")) > 0 {
+		enableLeaderElection, err := strconv.ParseBool(os.Getenv("ENABLE_LEADER_ELECTION"))
+		if err != nil {
+			return err
+		}
+		appConfig.LeaderElection.Enabled = enableLeaderElection
 	}
-	if defaultFromName := os.Getenv("NOTIFIERS_DEFAULT_FROM_NAME"); len(defaultFromName) > 0 {
-		appConfig.Notifiers[DefaultNotifierName].FromName = defaultFromName
+
+	if len(os.Getenv("ENABLE_SHARDING")) > 0 {
+		enableSharding, err := strconv.ParseBool(os.Getenv("ENABLE_SHARDING"))
+		if err != nil {
+			return err
+		}
+		appConfig.Sharding.Enabled = enableSharding
+	}
+
+	if len(os.Getenv("ENABLE_HTTP")) > 0 {
+		enableHttp, err := strconv.ParseBool(os.Getenv("ENABLE_HTTP"))
+		if err != nil {
+			return err
+		}
+		appConfig.Http.Enabled = enableHttp
 	}
-	if defaultFromAddress := os.Getenv("NOTIFIERS_DEFAULT_FROM_ADDRESS"); len(defaultFromAddress) > 0 {
-		appConfig.Notifiers[DefaultNotifierName].FromAddress = defaultFromAddress
+	if len(os.Getenv("HTTP_ADMIN_TOKEN")) > 0 {
+		appConfig.Http.AdminToken = os.Getenv("HTTP_ADMIN_TOKEN")
+	}
+
+	if len(os.Getenv("ENABLE_CONFIGMAPS")) > 0 {
+		enableConfigMaps, err := strconv.ParseBool(os.Getenv("ENABLE_CONFIGMAPS"))
+		if err != nil {
+			return err
+		}
+		appConfig.ConfigMaps.Enabled = enableConfigMaps
 	}
 
 	if appConfig.BuildsWatchers == nil {
@@ -126,6 +375,9 @@ func (appConfig *AppConfig) SetFromEnvVar() error {
 			}
 		}
 	}
+	if err := appConfig.setBuildsWatchersFromEnvVar(os.Environ()); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -137,23 +389,135 @@ func (appConfig *AppConfig) SetDefaults() {
 	for _, watcherConfig := range appConfig.BuildsWatchers {
 		watcherConfig.SetDefaults()
 	}
+	for _, reportConfig := range appConfig.Reports {
+		reportConfig.SetDefaults()
+	}
+	for i := range appConfig.QuietWindows {
+		appConfig.QuietWindows[i].SetDefaults()
+	}
+	appConfig.Routing.SetDefaults()
+	appConfig.ConfigMaps.SetDefaults()
+	appConfig.LeaderElection.SetDefaults()
+	appConfig.Sharding.SetDefaults()
+	appConfig.Http.SetDefaults()
 }
 
 func (appConfig *AppConfig) String() string {
 	buffer := &bytes.Buffer{}
-	fmt.Fprintf(buffer, "AppConfig with %d Builds Watchers and %d Notifiers", len(appConfig.BuildsWatchers), len(appConfig.Notifiers))
+	fmt.Fprintf(buffer, "AppConfig with %d Builds Watchers, %d Reports and %d Notifiers", len(appConfig.BuildsWatchers), len(appConfig.Reports), len(appConfig.Notifiers))
+	fmt.Fprintf(buffer, "\n  - Leader Election: %+v", appConfig.LeaderElection)
+	fmt.Fprintf(buffer, "\n  - Sharding: %+v", appConfig.Sharding)
+	fmt.Fprintf(buffer, "\n  - Quiet Windows: %+v", appConfig.QuietWindows)
+	fmt.Fprintf(buffer, "\n  - Routing: %+v", appConfig.Routing)
+	fmt.Fprintf(buffer, "\n  - ConfigMaps: %+v", appConfig.ConfigMaps)
+	fmt.Fprintf(buffer, "\n  - Http: %+v", appConfig.Http.Redacted())
 	for watcherName, watcherConfig := range appConfig.BuildsWatchers {
 		fmt.Fprintf(buffer, "\n  - Build Watcher %s: %s", watcherName, watcherConfig.String())
 	}
+	for reportName, reportConfig := range appConfig.Reports {
+		fmt.Fprintf(buffer, "\n  - Report %s: %s", reportName, reportConfig.String())
+	}
 	for notifierName, notifierConfig := range appConfig.Notifiers {
 		fmt.Fprintf(buffer, "\n  - Notifier %s: %s", notifierName, notifierConfig.String())
 	}
 	return buffer.String()
 }
 
+func (shardingConfig *ShardingConfig) SetDefaults() {
+	if len(shardingConfig.Name) == 0 {
+		shardingConfig.Name = DefaultShardingName
+	}
+	if len(shardingConfig.HeartbeatInterval) == 0 {
+		shardingConfig.HeartbeatInterval = DefaultShardingHeartbeatInterval
+	}
+	if len(shardingConfig.MemberTTL) == 0 {
+		shardingConfig.MemberTTL = DefaultShardingMemberTTL
+	}
+	if len(shardingConfig.HandoverDelay) == 0 {
+		shardingConfig.HandoverDelay = DefaultShardingHandoverDelay
+	}
+	if shardingConfig.VirtualNodes <= 0 {
+		shardingConfig.VirtualNodes = DefaultShardingVirtualNodes
+	}
+}
+
+func (httpConfig *HttpConfig) SetDefaults() {
+	if len(httpConfig.Address) == 0 {
+		httpConfig.Address = DefaultHttpAddress
+	}
+	if len(httpConfig.WatchTimeout) == 0 {
+		httpConfig.WatchTimeout = DefaultHttpWatchTimeout
+	}
+	if len(httpConfig.NotifierFailureTimeout) == 0 {
+		httpConfig.NotifierFailureTimeout = DefaultHttpNotifierFailureTimeout
+	}
+}
+
+// Redacted returns a copy of the config without the admin token
+func (httpConfig HttpConfig) Redacted() HttpConfig {
+	if len(httpConfig.AdminToken) > 0 {
+		httpConfig.AdminToken = RedactedValue
+	}
+	return httpConfig
+}
+
+func (configMapsConfig *ConfigMapsConfig) SetDefaults() {
+	if len(configMapsConfig.LabelSelector) == 0 {
+		configMapsConfig.LabelSelector = DefaultConfigMapsLabelSelector
+	}
+	if len(configMapsConfig.Key) == 0 {
+		configMapsConfig.Key = DefaultConfigMapsKey
+	}
+	if len(configMapsConfig.ResyncPeriod) == 0 {
+		configMapsConfig.ResyncPeriod = DefaultConfigMapsResyncPeriod
+	}
+}
+
+func (leaderElectionConfig *LeaderElectionConfig) SetDefaults() {
+	if len(leaderElectionConfig.Name) == 0 {
+		leaderElectionConfig.Name = DefaultLeaderElectionName
+	}
+	if len(leaderElectionConfig.LeaseDuration) == 0 {
+		leaderElectionConfig.LeaseDuration = DefaultLeaderElectionLeaseDuration
+	}
+	if len(leaderElectionConfig.RenewDeadline) == 0 {
+		leaderElectionConfig.RenewDeadline = DefaultLeaderElectionRenewDeadline
+	}
+	if len(leaderElectionConfig.RetryPeriod) == 0 {
+		leaderElectionConfig.RetryPeriod = DefaultLeaderElectionRetryPeriod
+	}
+}
+
+func (quietWindowConfig *QuietWindowConfig) SetDefaults() {
+	if len(quietWindowConfig.Mode) == 0 {
+		quietWindowConfig.Mode = QuietModeHold
+	}
+}
+
+func (routingConfig *RoutingConfig) SetDefaults() {
+	if len(routingConfig.DefaultNotifiers) == 0 {
+		routingConfig.DefaultNotifiers = []string{DefaultNotifierName}
+	}
+}
+
 func (watcherConfig *BuildsWatcherConfig) SetDefaults() {
-	if len(watcherConfig.Notifiers) == 0 {
-		watcherConfig.Notifiers = []string{DefaultNotifierName}
+	for i := range watcherConfig.QuietWindows {
+		watcherConfig.QuietWindows[i].SetDefaults()
+	}
+	if watcherConfig.DedupMaxEntries <= 0 {
+		watcherConfig.DedupMaxEntries = DefaultDedupMaxEntries
+	}
+	if len(watcherConfig.DedupTTL) == 0 {
+		watcherConfig.DedupTTL = DefaultDedupTTL
+	}
+	if len(watcherConfig.AnnotationsNotifier) == 0 {
+		watcherConfig.AnnotationsNotifier = DefaultNotifierName
+	}
+	if len(watcherConfig.NotifyMode) == 0 {
+		watcherConfig.NotifyMode = NotifyModeAll
+	}
+	if watcherConfig.StillFailingEvery == 0 {
+		watcherConfig.StillFailingEvery = DefaultStillFailingEvery
 	}
 
 	if watcherConfig.WatchForBuildPhase == nil {
@@ -186,13 +550,59 @@ func (watcherConfig *BuildsWatcherConfig) String() string {
 	return fmt.Sprintf("%+v", *watcherConfig)
 }
 
-func (notifierConfig *FlowdockNotifierConfig) SetDefaults() {
+func (reportConfig *ReportConfig) SetDefaults() {
+	if len(reportConfig.Notifiers) == 0 {
+		reportConfig.Notifiers = []string{DefaultNotifierName}
+	}
+	if len(reportConfig.Schedule) == 0 {
+		reportConfig.Schedule = DefaultReportSchedule
+	}
+	if len(reportConfig.Period) == 0 {
+		reportConfig.Period = DefaultReportPeriod
+	}
+	if reportConfig.TopEntries <= 0 {
+		reportConfig.TopEntries = DefaultReportTopEntries
+	}
+}
+
+func (reportConfig *ReportConfig) String() string {
+	return fmt.Sprintf("%+v", *reportConfig)
+}
+
+func (notifierConfig *NotifierConfig) SetDefaults() {
+	for i := range notifierConfig.QuietWindows {
+		notifierConfig.QuietWindows[i].SetDefaults()
+	}
+	if len(notifierConfig.Type) == 0 {
+		notifierConfig.Type = FlowdockNotifierType
+	}
+	if notifierConfig.BufferSize <= 0 {
+		notifierConfig.BufferSize = DefaultBufferSize
+	}
+	if len(notifierConfig.OverflowPolicy) == 0 {
+		notifierConfig.OverflowPolicy = string(OverflowBlock)
+	}
 	if len(notifierConfig.SubjectTemplate) == 0 {
 		notifierConfig.SubjectTemplate = DefaultSubjectTemplate
 	}
 	if len(notifierConfig.ContentTemplate) == 0 {
 		notifierConfig.ContentTemplate = DefaultContentTemplate
 	}
+	if len(notifierConfig.DigestSubjectTemplate) == 0 {
+		notifierConfig.DigestSubjectTemplate = DefaultDigestSubjectTemplate
+	}
+	if len(notifierConfig.DigestContentTemplate) == 0 {
+		notifierConfig.DigestContentTemplate = DefaultDigestContentTemplate
+	}
+	if len(notifierConfig.ReportSubjectTemplate) == 0 {
+		notifierConfig.ReportSubjectTemplate = DefaultReportSubjectTemplate
+	}
+	if len(notifierConfig.ReportContentTemplate) == 0 {
+		notifierConfig.ReportContentTemplate = DefaultReportContentTemplate
+	}
+	if len(notifierConfig.TokenSecret.Name) > 0 && len(notifierConfig.TokenSecret.Key) == 0 {
+		notifierConfig.TokenSecret.Key = DefaultTokenSecretKey
+	}
 	if len(notifierConfig.FromAddress) == 0 {
 		notifierConfig.FromAddress = DefaultFromAddress
 	}
@@ -202,8 +612,35 @@ func (notifierConfig *FlowdockNotifierConfig) SetDefaults() {
 	if len(notifierConfig.Source) == 0 {
 		notifierConfig.Source = DefaultSource
 	}
+	if len(notifierConfig.RetryInitialBackoff) == 0 {
+		notifierConfig.RetryInitialBackoff = DefaultRetryInitialBackoff
+	}
+	if len(notifierConfig.RetryMaxBackoff) == 0 {
+		notifierConfig.RetryMaxBackoff = DefaultRetryMaxBackoff
+	}
+	if len(notifierConfig.RetryMaxAge) == 0 {
+		notifierConfig.RetryMaxAge = DefaultRetryMaxAge
+	}
+	if len(notifierConfig.Output) == 0 {
+		notifierConfig.Output = DefaultJsonOutput
+	}
+	if notifierConfig.MaxFileSizeMB <= 0 {
+		notifierConfig.MaxFileSizeMB = DefaultJsonMaxFileSizeMB
+	}
+	// a negative value keeps no backup
+	if notifierConfig.MaxBackups == 0 {
+		notifierConfig.MaxBackups = DefaultJsonMaxBackups
+	}
 }
 
-func (notifierConfig *FlowdockNotifierConfig) String() string {
-	return fmt.Sprintf("%+v", *notifierConfig)
+func (notifierConfig *NotifierConfig) String() string {
+	return fmt.Sprintf("%+v", notifierConfig.Redacted())
+}
+
+// Redacted returns a copy of the config without the token
+func (notifierConfig NotifierConfig) Redacted() NotifierConfig {
+	if len(notifierConfig.Token) > 0 {
+		notifierConfig.Token = RedactedValue
+	}
+	return notifierConfig
 }
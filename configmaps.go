@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+
+	"k8s.io/kubernetes/pkg/api/v1"
+	k8client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+// ConfigMap is a minimal representation of a Kubernetes ConfigMap.
+// The vendored kubernetes client predates ConfigMaps,
+// so we talk to the API with raw requests, and (un)marshal this type ourselves.
+// Note that ConfigMaps require OpenShift 1.2+ (Kubernetes 1.2+) on the server side.
+type ConfigMap struct {
+	Kind       string            `json:"kind"`
+	APIVersion string            `json:"apiVersion"`
+	Metadata   v1.ObjectMeta     `json:"metadata"`
+	Data       map[string]string `json:"data,omitempty"`
+}
+
+// ConfigMapList is a minimal representation of a Kubernetes ConfigMapList
+type ConfigMapList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []ConfigMap `json:"items"`
+}
+
+func NewConfigMap(namespace, name string) *ConfigMap {
+	return &ConfigMap{
+		Kind:       "ConfigMap",
+		APIVersion: "v1",
+		Metadata: v1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Data: make(map[string]string),
+	}
+}
+
+// getConfigMap returns the ConfigMap with the given name
+func getConfigMap(kclient *k8client.Client, namespace, name string) (*ConfigMap, error) {
+	data, err := kclient.Get().AbsPath("/api/v1/namespaces", namespace, "configmaps", name).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	configMap := &ConfigMap{}
+	if err := json.Unmarshal(data, configMap); err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+// listConfigMaps returns the ConfigMaps matching the given label selector,
+// either in the given namespace, or in all namespaces if the namespace is empty
+func listConfigMaps(kclient *k8client.Client, namespace, labelSelector string) (*ConfigMapList, error) {
+	path := "/api/v1/configmaps"
+	if len(namespace) > 0 {
+		path = "/api/v1/namespaces/" + namespace + "/configmaps"
+	}
+	req := kclient.Get().AbsPath(path)
+	if len(labelSelector) > 0 {
+		req = req.Param("labelSelector", labelSelector)
+	}
+	data, err := req.Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	list := &ConfigMapList{}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// createConfigMap creates the given ConfigMap
+func createConfigMap(kclient *k8client.Client, configMap *ConfigMap) error {
+	body, err := json.Marshal(configMap)
+	if err != nil {
+		return err
+	}
+	_, err = kclient.Post().AbsPath("/api/v1/namespaces", configMap.Metadata.Namespace, "configmaps").Body(body).Do().Raw()
+	return err
+}
+
+// updateConfigMap updates the given ConfigMap
+// It will fail with a conflict if the ConfigMap has been updated since it was retrieved
+func updateConfigMap(kclient *k8client.Client, configMap *ConfigMap) error {
+	body, err := json.Marshal(configMap)
+	if err != nil {
+		return err
+	}
+	_, err = kclient.Put().AbsPath("/api/v1/namespaces", configMap.Metadata.Namespace, "configmaps", configMap.Metadata.Name).Body(body).Do().Raw()
+	return err
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultConfigMapsLabelSelector = "flowdock-notifier/config=true"
+	DefaultConfigMapsKey           = "config.yml"
+	DefaultConfigMapsResyncPeriod  = "30s"
+
+	// ConfigOrderAnnotation is the merge order of a ConfigMap: the lowest first - 0 by default
+	ConfigOrderAnnotation = "flowdock-notifier/config-order"
+	// ConfigStatusAnnotation is written on the ConfigMaps, with the result of the merge of their configuration
+	ConfigStatusAnnotation = "flowdock-notifier/config-status"
+)
+
+const (
+	// ConfigStateApplied means that the whole configuration of the ConfigMap has been applied
+	ConfigStateApplied = "Applied"
+	// ConfigStateConflict means that some watchers or notifiers were already defined, and have been skipped
+	ConfigStateConflict = "Conflict"
+	// ConfigStateInvalid means that the configuration of the ConfigMap has been rejected
+	ConfigStateInvalid = "Invalid"
+)
+
+// ConfigContribution is the configuration contributed by a ConfigMap:
+// watchers and notifiers, scoped to the namespace of the ConfigMap.
+// Their names are qualified by the namespace - such as "my-project/my-notifier".
+type ConfigContribution struct {
+	Namespace      string
+	Name           string
+	Order          int
+	BuildsWatchers map[string]*BuildsWatcherConfig
+	Notifiers      map[string]*NotifierConfig
+	// Err is set if the ConfigMap doesn't hold a valid configuration
+	Err error
+
+	// status is the value of the status annotation of the ConfigMap
+	status string
+}
+
+// ConfigStatus is the result of the merge of a contribution, written on its ConfigMap
+type ConfigStatus struct {
+	State     string   `json:"state"`
+	Message   string   `json:"message,omitempty"`
+	Watchers  []string `json:"watchers,omitempty"`
+	Notifiers []string `json:"notifiers,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// NewConfigContribution parses the configuration held in the given key of the ConfigMap,
+// and restricts it to the namespace of the ConfigMap
+func NewConfigContribution(configMap *ConfigMap, key string) *ConfigContribution {
+	contribution := &ConfigContribution{
+		Namespace: configMap.Metadata.Namespace,
+		Name:      configMap.Metadata.Name,
+		status:    configMap.Metadata.Annotations[ConfigStatusAnnotation],
+	}
+
+	if value, found := configMap.Metadata.Annotations[ConfigOrderAnnotation]; found {
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			contribution.Err = fmt.Errorf("invalid %s annotation %s: %v", ConfigOrderAnnotation, value, err)
+			return contribution
+		}
+		contribution.Order = order
+	}
+
+	data, found := configMap.Data[key]
+	if !found {
+		contribution.Err = fmt.Errorf("no %s key in the data", key)
+		return contribution
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewBufferString(data)); err != nil {
+		contribution.Err = fmt.Errorf("invalid %s: %v", key, err)
+		return contribution
+	}
+	for _, k := range v.AllKeys() {
+		section := strings.SplitN(k, ".", 2)[0]
+		if section != "buildswatchers" && section != "notifiers" {
+			contribution.Err = fmt.Errorf("invalid %s: only buildsWatchers and notifiers can be defined in a ConfigMap, not %s", key, section)
+			return contribution
+		}
+	}
+	config := &AppConfig{}
+	if err := v.Unmarshal(config); err != nil {
+		contribution.Err = fmt.Errorf("invalid %s: %v", key, err)
+		return contribution
+	}
+	if err := CheckConfigKeys(v.AllSettings()); err != nil {
+		contribution.Err = fmt.Errorf("invalid %s: %v", key, err)
+		return contribution
+	}
+
+	contribution.BuildsWatchers = make(map[string]*BuildsWatcherConfig)
+	for name, watcherConfig := range config.BuildsWatchers {
+		if watcherConfig == nil {
+			watcherConfig = &BuildsWatcherConfig{}
+		}
+		watcherConfig.SetDefaults()
+		contribution.BuildsWatchers[name] = watcherConfig
+	}
+	contribution.Notifiers = make(map[string]*NotifierConfig)
+	for name, notifierConfig := range config.Notifiers {
+		if notifierConfig == nil {
+			notifierConfig = &NotifierConfig{}
+		}
+		notifierConfig.SetDefaults()
+		contribution.Notifiers[name] = notifierConfig
+	}
+
+	contribution.Err = contribution.scope()
+	return contribution
+}
+
+// Key returns the namespace/name of the ConfigMap
+func (contribution *ConfigContribution) Key() string {
+	return contribution.Namespace + "/" + contribution.Name
+}
+
+// scope restricts the watchers and the notifiers to the namespace of the ConfigMap:
+// they can't watch other namespaces, read the secrets of other namespaces, nor use the local files
+func (contribution *ConfigContribution) scope() error {
+	namespace := contribution.Namespace
+	for name, watcherConfig := range contribution.BuildsWatchers {
+		if watcherConfig.AllNamespaces || (len(watcherConfig.Namespace) > 0 && watcherConfig.Namespace != namespace) {
+			return fmt.Errorf("watcher %s can only watch namespace %s", name, namespace)
+		}
+		watcherConfig.Namespace = namespace
+		for _, path := range []string{watcherConfig.StatePath, watcherConfig.DedupStatePath} {
+			if len(path) > 0 && !strings.HasPrefix(path, "configmap:"+namespace+"/") {
+				return fmt.Errorf("watcher %s can only store its state in a ConfigMap of namespace %s, not in %s", name, namespace, path)
+			}
+		}
+	}
+	for name, notifierConfig := range contribution.Notifiers {
+		if len(notifierConfig.QueueDir) > 0 || len(notifierConfig.DeadLetterPath) > 0 {
+			return fmt.Errorf("notifier %s can't use a queueDir nor a deadLetterPath", name)
+		}
+		if notifierConfig.Output != DefaultJsonOutput {
+			return fmt.Errorf("notifier %s can only write to %s", name, DefaultJsonOutput)
+		}
+		if len(notifierConfig.TokenSecret.Name) > 0 {
+			if len(notifierConfig.TokenSecret.Namespace) > 0 && notifierConfig.TokenSecret.Namespace != namespace {
+				return fmt.Errorf("notifier %s can only read the secrets of namespace %s", name, namespace)
+			}
+			notifierConfig.TokenSecret.Namespace = namespace
+			notifierConfig.TokenSecret.EventNamespace = false
+		}
+	}
+	return nil
+}
+
+// resolveNotifier returns the name of the notifier a contributed watcher refers to:
+// a notifier of the same namespace - contributed by this ConfigMap or a previous one - or a notifier of the base configuration
+func (contribution *ConfigContribution) resolveNotifier(config *AppConfig, base *AppConfig, name string) (string, error) {
+	if qualifiedName := contribution.Namespace + "/" + name; config.Notifiers[qualifiedName] != nil {
+		return qualifiedName, nil
+	}
+	if base.Notifiers[name] != nil {
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown notifier %s", name)
+}
+
+type contributionsByOrder []*ConfigContribution
+
+func (c contributionsByOrder) Len() int      { return len(c) }
+func (c contributionsByOrder) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c contributionsByOrder) Less(i, j int) bool {
+	if c[i].Order != c[j].Order {
+		return c[i].Order < c[j].Order
+	}
+	return c[i].Key() < c[j].Key()
+}
+
+// MergeConfigs merges the contributions into a copy of the base configuration, in merge order:
+// by order annotation, and then by namespace and name.
+// A contribution is merged only if the result is valid - according to the validate func - and its watchers
+// and notifiers which are already defined - by the base configuration or a previous contribution - are skipped, as conflicts.
+// It returns the merged configuration, and the status of each contribution.
+func MergeConfigs(base *AppConfig, contributions []*ConfigContribution, validate func(*AppConfig) error) (*AppConfig, map[string]*ConfigStatus) {
+	sorted := append([]*ConfigContribution{}, contributions...)
+	sort.Sort(contributionsByOrder(sorted))
+
+	merged := base.copy()
+	owners := make(map[string]string)
+	statuses := make(map[string]*ConfigStatus)
+	for _, contribution := range sorted {
+		status := &ConfigStatus{}
+		statuses[contribution.Key()] = status
+		if contribution.Err != nil {
+			status.State = ConfigStateInvalid
+			status.Message = contribution.Err.Error()
+			continue
+		}
+
+		candidate := merged.copy()
+		conflict := func(kind string, name string) {
+			owner := "the configuration file"
+			if key, found := owners[kind+" "+name]; found {
+				owner = "ConfigMap " + key
+			}
+			status.Conflicts = append(status.Conflicts, fmt.Sprintf("%s %s is already defined by %s", kind, name, owner))
+		}
+
+		for _, name := range sortedKeys(contribution.Notifiers) {
+			qualifiedName := contribution.Namespace + "/" + name
+			if _, found := candidate.Notifiers[qualifiedName]; found {
+				conflict("notifier", qualifiedName)
+				continue
+			}
+			candidate.Notifiers[qualifiedName] = contribution.Notifiers[name]
+			status.Notifiers = append(status.Notifiers, qualifiedName)
+		}
+
+		var err error
+		for _, name := range sortedKeys(contribution.BuildsWatchers) {
+			qualifiedName := contribution.Namespace + "/" + name
+			if _, found := candidate.BuildsWatchers[qualifiedName]; found {
+				conflict("watcher", qualifiedName)
+				continue
+			}
+			watcherConfig := *contribution.BuildsWatchers[name]
+			if len(watcherConfig.Notifiers) == 0 {
+				// the routing rules of the configuration file are not scoped to the namespace of the ConfigMap
+				err = fmt.Errorf("watcher %s: it needs a list of notifiers", name)
+				break
+			}
+			watcherConfig.Notifiers = []string{}
+			for _, notifierName := range contribution.BuildsWatchers[name].Notifiers {
+				var resolvedName string
+				if resolvedName, err = contribution.resolveNotifier(candidate, base, notifierName); err != nil {
+					err = fmt.Errorf("watcher %s: %v", name, err)
+					break
+				}
+				watcherConfig.Notifiers = append(watcherConfig.Notifiers, resolvedName)
+			}
+			if err != nil {
+				break
+			}
+			if watcherConfig.Annotations {
+				if watcherConfig.AnnotationsNotifier, err = contribution.resolveNotifier(candidate, base, watcherConfig.AnnotationsNotifier); err != nil {
+					err = fmt.Errorf("watcher %s: %v", name, err)
+					break
+				}
+			}
+			candidate.BuildsWatchers[qualifiedName] = &watcherConfig
+			status.Watchers = append(status.Watchers, qualifiedName)
+		}
+		if err == nil {
+			err = validate(candidate)
+		}
+		if err != nil {
+			*status = ConfigStatus{
+				State:   ConfigStateInvalid,
+				Message: err.Error(),
+			}
+			continue
+		}
+
+		for _, name := range status.Notifiers {
+			owners["notifier "+name] = contribution.Key()
+		}
+		for _, name := range status.Watchers {
+			owners["watcher "+name] = contribution.Key()
+		}
+		merged = candidate
+		status.State = ConfigStateApplied
+		if len(status.Conflicts) > 0 {
+			status.State = ConfigStateConflict
+		}
+	}
+	return merged, statuses
+}
+
+// copy returns a copy of the configuration, whose watchers and notifiers can be changed without changing the original
+func (appConfig *AppConfig) copy() *AppConfig {
+	config := *appConfig
+	config.BuildsWatchers = make(map[string]*BuildsWatcherConfig, len(appConfig.BuildsWatchers))
+	for name, watcherConfig := range appConfig.BuildsWatchers {
+		config.BuildsWatchers[name] = watcherConfig
+	}
+	config.Notifiers = make(map[string]*NotifierConfig, len(appConfig.Notifiers))
+	for name, notifierConfig := range appConfig.Notifiers {
+		config.Notifiers[name] = notifierConfig
+	}
+	return &config
+}
+
+// ConfigMapsSource reads the configurations contributed by the labeled ConfigMaps, merges them with the base configuration
+// - from the config file - and applies the result to the runtime. The status of each contribution is written back on its ConfigMap.
+// The vendored client doesn't support watching the ConfigMaps, so they are read periodically.
+type ConfigMapsSource struct {
+	Config       ConfigMapsConfig
+	ResyncPeriod time.Duration
+	Runtime      *Runtime
+
+	factory       clientcmd.Factory
+	mutex         sync.Mutex
+	base          *AppConfig
+	contributions []*ConfigContribution
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewConfigMapsSource(factory clientcmd.Factory, config ConfigMapsConfig, runtime *Runtime) (*ConfigMapsSource, error) {
+	resyncPeriod, err := time.ParseDuration(config.ResyncPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resyncPeriod %s: %v", config.ResyncPeriod, err)
+	}
+	if config.AllNamespaces {
+		config.Namespace = ""
+	} else if len(config.Namespace) == 0 {
+		config.Namespace, _, err = factory.OpenShiftClientConfig.Namespace()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &ConfigMapsSource{
+		Config:       config,
+		ResyncPeriod: resyncPeriod,
+		Runtime:      runtime,
+		factory:      factory,
+		stop:         make(chan struct{}),
+	}, nil
+}
+
+// SetBase replaces the base configuration, and applies it - merged with the current contributions
+// If the result is invalid, the base configuration is not replaced.
+func (source *ConfigMapsSource) SetBase(base *AppConfig) error {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	previous := source.base
+	source.base = base
+	if err := source.apply(); err != nil {
+		source.base = previous
+		return err
+	}
+	return nil
+}
+
+// Sync reads the ConfigMaps, and applies their contributions
+func (source *ConfigMapsSource) Sync() error {
+	_, kclient, err := source.factory.Clients()
+	if err != nil {
+		return err
+	}
+	list, err := listConfigMaps(kclient, source.Config.Namespace, source.Config.LabelSelector)
+	if err != nil {
+		return fmt.Errorf("failed to list the ConfigMaps matching %s: %v", source.Config.LabelSelector, err)
+	}
+
+	contributions := []*ConfigContribution{}
+	for i := range list.Items {
+		contributions = append(contributions, NewConfigContribution(&list.Items[i], source.Config.Key))
+	}
+
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	source.contributions = contributions
+	if source.base == nil {
+		return nil
+	}
+	return source.apply()
+}
+
+// Run syncs the ConfigMaps periodically, until the source is stopped
+func (source *ConfigMapsSource) Run() {
+	ticker := time.NewTicker(source.ResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := source.Sync(); err != nil {
+				glog.Errorf("Failed to apply the configuration of the ConfigMaps: %v", err)
+			}
+		case <-source.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the periodic syncs: Run returns once the current sync is over
+func (source *ConfigMapsSource) Stop() {
+	source.stopOnce.Do(func() {
+		close(source.stop)
+	})
+}
+
+func (source *ConfigMapsSource) apply() error {
+	// checking the base alone first, so that its errors are not blamed on the ConfigMaps
+	if err := ValidateAppConfig(source.base); err != nil {
+		return err
+	}
+	merged, statuses := MergeConfigs(source.base, source.contributions, ValidateAppConfig)
+	if err := source.Runtime.Apply(merged); err != nil {
+		return err
+	}
+
+	for _, contribution := range source.contributions {
+		source.writeStatus(contribution, statuses[contribution.Key()])
+	}
+	return nil
+}
+
+// writeStatus writes the status annotation of the ConfigMap of the given contribution, if it has changed
+func (source *ConfigMapsSource) writeStatus(contribution *ConfigContribution, status *ConfigStatus) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		glog.Warningf("Failed to marshal the status of ConfigMap %s: %v", contribution.Key(), err)
+		return
+	}
+	if string(data) == contribution.status {
+		return
+	}
+
+	switch status.State {
+	case ConfigStateApplied:
+		glog.Infof("Applied the configuration of ConfigMap %s: watchers %v and notifiers %v", contribution.Key(), status.Watchers, status.Notifiers)
+	case ConfigStateConflict:
+		glog.Warningf("Partially applied the configuration of ConfigMap %s: %s", contribution.Key(), strings.Join(status.Conflicts, ", "))
+	default:
+		glog.Warningf("Rejected the configuration of ConfigMap %s: %s", contribution.Key(), status.Message)
+	}
+
+	_, kclient, err := source.factory.Clients()
+	if err != nil {
+		glog.Warningf("Failed to write the status of ConfigMap %s: %v", contribution.Key(), err)
+		return
+	}
+	configMap, err := getConfigMap(kclient, contribution.Namespace, contribution.Name)
+	if err != nil {
+		glog.Warningf("Failed to write the status of ConfigMap %s: %v", contribution.Key(), err)
+		return
+	}
+	if configMap.Metadata.Annotations == nil {
+		configMap.Metadata.Annotations = make(map[string]string)
+	}
+	configMap.Metadata.Annotations[ConfigStatusAnnotation] = string(data)
+	// on a conflict, it will be written again on the next sync
+	if err := updateConfigMap(kclient, configMap); err != nil {
+		glog.Warningf("Failed to write the status of ConfigMap %s: %v", contribution.Key(), err)
+		return
+	}
+	contribution.status = string(data)
+}
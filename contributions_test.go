@@ -0,0 +1,226 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	"k8s.io/kubernetes/pkg/api/v1"
+)
+
+func newTestConfigMap(namespace string, name string, order string, config string) *ConfigMap {
+	configMap := NewConfigMap(namespace, name)
+	if len(order) > 0 {
+		configMap.Metadata.Annotations = map[string]string{ConfigOrderAnnotation: order}
+	}
+	configMap.Data[DefaultConfigMapsKey] = config
+	return configMap
+}
+
+func TestNewConfigContribution(t *testing.T) {
+	tests := []struct {
+		configMap         *ConfigMap
+		expectedError     bool
+		expectedNamespace string
+		expectedSecret    SecretKeyRef
+	}{
+		{
+			configMap: newTestConfigMap("team", "notifier", "", `
+buildsWatchers:
+  builds:
+    notifiers: [flow]
+notifiers:
+  flow:
+    tokenSecret:
+      name: flowdock
+`),
+			expectedNamespace: "team",
+			expectedSecret:    SecretKeyRef{Name: "flowdock", Key: DefaultTokenSecretKey, Namespace: "team"},
+		},
+		// should not watch other namespaces
+		{
+			configMap: newTestConfigMap("team", "notifier", "", `
+buildsWatchers:
+  builds:
+    allNamespaces: true
+`),
+			expectedError: true,
+		},
+		{
+			configMap: newTestConfigMap("team", "notifier", "", `
+buildsWatchers:
+  builds:
+    namespace: other
+`),
+			expectedError: true,
+		},
+		// should not read the secrets of other namespaces
+		{
+			configMap: newTestConfigMap("team", "notifier", "", `
+notifiers:
+  flow:
+    tokenSecret:
+      name: flowdock
+      namespace: other
+`),
+			expectedError: true,
+		},
+		// should not use local files
+		{
+			configMap: newTestConfigMap("team", "notifier", "", `
+notifiers:
+  flow:
+    queueDir: /tmp
+`),
+			expectedError: true,
+		},
+		// should only define watchers and notifiers
+		{
+			configMap: newTestConfigMap("team", "notifier", "", `
+routing:
+  defaultNotifiers: [flow]
+`),
+			expectedError: true,
+		},
+		{
+			configMap:     newTestConfigMap("team", "notifier", "first", ``),
+			expectedError: true,
+		},
+		{
+			configMap:     &ConfigMap{Metadata: v1.ObjectMeta{Namespace: "team", Name: "empty"}},
+			expectedError: true,
+		},
+	}
+
+	for count, test := range tests {
+		contribution := NewConfigContribution(test.configMap, DefaultConfigMapsKey)
+		if test.expectedError {
+			if contribution.Err == nil {
+				t.Errorf("Test[%d] Failed: Expected an error", count)
+			}
+			continue
+		}
+		if contribution.Err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, contribution.Err)
+			continue
+		}
+		if namespace := contribution.BuildsWatchers["builds"].Namespace; namespace != test.expectedNamespace {
+			t.Errorf("Test[%d] Failed: Expected namespace '%v' but got '%v'", count, test.expectedNamespace, namespace)
+		}
+		if secret := contribution.Notifiers["flow"].TokenSecret; !reflect.DeepEqual(secret, test.expectedSecret) {
+			t.Errorf("Test[%d] Failed: Expected secret '%+v' but got '%+v'", count, test.expectedSecret, secret)
+		}
+	}
+}
+
+func TestMergeConfigs(t *testing.T) {
+	base := &AppConfig{
+		BuildsWatchers: map[string]*BuildsWatcherConfig{
+			"all": {AllNamespaces: true},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"default": {},
+			"ops":     {},
+		},
+	}
+	base.SetDefaults()
+
+	contributions := []*ConfigContribution{
+		// merged last, because of its order
+		NewConfigContribution(newTestConfigMap("team", "late", "10", `
+buildsWatchers:
+  builds:
+    notifiers: [flow]
+notifiers:
+  flow:
+    fromName: Late
+`), DefaultConfigMapsKey),
+		NewConfigContribution(newTestConfigMap("team", "base", "", `
+buildsWatchers:
+  builds:
+    notifiers: [flow, ops]
+notifiers:
+  flow: {}
+`), DefaultConfigMapsKey),
+		NewConfigContribution(newTestConfigMap("other", "config", "", `
+buildsWatchers:
+  builds:
+    notifiers: [flow]
+`), DefaultConfigMapsKey),
+		NewConfigContribution(newTestConfigMap("other", "templates", "", `
+notifiers:
+  invalid:
+    subjectTemplate: "{{.Name"
+`), DefaultConfigMapsKey),
+		NewConfigContribution(newTestConfigMap("other", "scope", "", `
+buildsWatchers:
+  builds:
+    allNamespaces: true
+`), DefaultConfigMapsKey),
+		NewConfigContribution(newTestConfigMap("routed", "config", "", `
+buildsWatchers:
+  builds:
+    namespace: routed
+`), DefaultConfigMapsKey),
+	}
+
+	merged, statuses := MergeConfigs(base, contributions, ValidateAppConfig)
+
+	expectedStates := map[string]string{
+		"team/base":       ConfigStateApplied,
+		"team/late":       ConfigStateConflict,
+		"other/config":    ConfigStateInvalid,
+		"other/templates": ConfigStateInvalid,
+		"other/scope":     ConfigStateInvalid,
+		"routed/config":   ConfigStateInvalid,
+	}
+	for key, expectedState := range expectedStates {
+		if status := statuses[key]; status == nil || status.State != expectedState {
+			t.Errorf("Expected state '%v' for ConfigMap %s but got '%+v'", expectedState, key, status)
+		}
+	}
+	if message := statuses["routed/config"].Message; !strings.Contains(message, "needs a list of notifiers") {
+		t.Errorf("Expected the missing notifiers of ConfigMap routed/config to be reported but got '%v'", message)
+	}
+	if conflicts := statuses["team/late"].Conflicts; len(conflicts) != 2 {
+		t.Errorf("Expected 2 conflicts for ConfigMap team/late but got '%v'", conflicts)
+	}
+
+	if watchers := sortedKeys(merged.BuildsWatchers); !reflect.DeepEqual(watchers, []string{"all", "team/builds"}) {
+		t.Errorf("Unexpected merged watchers '%v'", watchers)
+	}
+	if notifiers := sortedKeys(merged.Notifiers); !reflect.DeepEqual(notifiers, []string{"default", "ops", "team/flow"}) {
+		t.Errorf("Unexpected merged notifiers '%v'", notifiers)
+	}
+	if notifiers := merged.BuildsWatchers["team/builds"].Notifiers; !reflect.DeepEqual(notifiers, []string{"team/flow", "ops"}) {
+		t.Errorf("Unexpected notifiers of watcher team/builds '%v'", notifiers)
+	}
+	// the base configuration should not have been changed
+	if watchers := sortedKeys(base.BuildsWatchers); !reflect.DeepEqual(watchers, []string{"all"}) {
+		t.Errorf("Unexpected base watchers '%v'", watchers)
+	}
+}
+
+func TestConfigMapsSourceStop(t *testing.T) {
+	source, err := NewConfigMapsSource(clientcmd.Factory{}, ConfigMapsConfig{AllNamespaces: true, ResyncPeriod: "1h"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		source.Run()
+		close(stopped)
+	}()
+	source.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the periodic sync to stop")
+	}
+	// stopping twice is harmless
+	source.Stop()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a schedule defined with the standard cron syntax
+// "minute hour day-of-month month day-of-week", in a specific timezone.
+// Each field supports "*", single values, ranges ("1-5"), lists ("1,3,5") and steps ("*/15", "0-30/10").
+// The "@hourly", "@daily", "@weekly" and "@monthly" shortcuts are supported too.
+type CronSchedule struct {
+	Spec     string
+	Location *time.Location
+
+	minutes uint64
+	hours   uint64
+	doms    uint64
+	months  uint64
+	dows    uint64
+	// domStar and dowStar are true if the day-of-month / day-of-week fields are "*"
+	// if both are restricted, a day matches if it matches either of them (as cron does)
+	domStar bool
+	dowStar bool
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// ParseCronSchedule parses the given cron spec, for the given timezone (such as "Europe/Paris")
+// An empty timezone means the local timezone.
+func ParseCronSchedule(spec string, timezone string) (*CronSchedule, error) {
+	location := time.Local
+	if len(timezone) > 0 {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %s: %v", timezone, err)
+		}
+	}
+
+	expanded := strings.TrimSpace(spec)
+	if shortcut, found := cronShortcuts[expanded]; found {
+		expanded = shortcut
+	}
+
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron spec %q: expected 5 fields but got %d", spec, len(fields))
+	}
+
+	schedule := &CronSchedule{
+		Spec:     spec,
+		Location: location,
+		domStar:  fields[2] == "*",
+		dowStar:  fields[4] == "*",
+	}
+
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute field in cron spec %q: %v", spec, err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour field in cron spec %q: %v", spec, err)
+	}
+	if schedule.doms, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day-of-month field in cron spec %q: %v", spec, err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month field in cron spec %q: %v", spec, err)
+	}
+	if schedule.dows, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day-of-week field in cron spec %q: %v", spec, err)
+	}
+	// 7 is sunday too
+	if schedule.dows&(1<<7) != 0 {
+		schedule.dows |= 1 << 0
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a single cron field into a bitset
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			start, end = value, value
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is out of range [%d-%d]", part, min, max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time matching the schedule, strictly after the given time
+func (schedule *CronSchedule) Next(after time.Time) time.Time {
+	t := after.In(schedule.Location).Truncate(time.Minute).Add(time.Minute)
+	// a matching time is always found within 5 years (for example for the 29th of February)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if schedule.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, schedule.Location)
+			continue
+		}
+		if !schedule.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, schedule.Location)
+			continue
+		}
+		if schedule.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, schedule.Location)
+			continue
+		}
+		if schedule.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (schedule *CronSchedule) matchesDay(t time.Time) bool {
+	domMatch := schedule.doms&(1<<uint(t.Day())) != 0
+	dowMatch := schedule.dows&(1<<uint(t.Weekday())) != 0
+	switch {
+	case schedule.domStar && schedule.dowStar:
+		return true
+	case schedule.domStar:
+		return dowMatch
+	case schedule.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
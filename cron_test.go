@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		spec         string
+		timezone     string
+		after        string
+		expectedNext string
+	}{
+		{spec: "@daily", timezone: "UTC", after: "2016-03-10T10:20:00Z", expectedNext: "2016-03-11T00:00:00Z"},
+		{spec: "*/15 * * * *", timezone: "UTC", after: "2016-03-10T10:20:30Z", expectedNext: "2016-03-10T10:30:00Z"},
+		// every monday at 9:00
+		{spec: "0 9 * * 1", timezone: "UTC", after: "2016-03-10T10:20:00Z", expectedNext: "2016-03-14T09:00:00Z"},
+		// sunday can be 0 or 7
+		{spec: "0 9 * * 7", timezone: "UTC", after: "2016-03-10T10:20:00Z", expectedNext: "2016-03-13T09:00:00Z"},
+		// when both day fields are restricted, either can match
+		{spec: "0 0 1 * 1", timezone: "UTC", after: "2016-03-10T10:20:00Z", expectedNext: "2016-03-14T00:00:00Z"},
+		{spec: "0 0 29 2 *", timezone: "UTC", after: "2016-03-10T10:20:00Z", expectedNext: "2020-02-29T00:00:00Z"},
+		// in a specific timezone
+		{spec: "30 8 * * 1-5", timezone: "Europe/Paris", after: "2016-03-11T10:00:00Z", expectedNext: "2016-03-14T07:30:00Z"},
+	}
+
+	for count, test := range tests {
+		schedule, err := ParseCronSchedule(test.spec, test.timezone)
+		if err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		after, _ := time.Parse(time.RFC3339, test.after)
+		expectedNext, _ := time.Parse(time.RFC3339, test.expectedNext)
+		result := schedule.Next(after)
+		if !result.Equal(expectedNext) {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, expectedNext, result.UTC())
+		}
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	}
+
+	for count, spec := range specs {
+		if _, err := ParseCronSchedule(spec, "UTC"); err == nil {
+			t.Errorf("Test[%d] Failed: Expected an error for spec %q", count, spec)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultDedupMaxEntries = 10000
+	DefaultDedupTTL        = "24h"
+)
+
+// PhaseTracker remembers the last notified phase of each object (by UID)
+// so that repeated events for the same phase (label/annotation updates, ...)
+// are notified only once.
+// It holds at most MaxEntries objects, which expire after the TTL,
+// and can optionally be persisted in a StateStore.
+type PhaseTracker struct {
+	MaxEntries int
+	TTL        time.Duration
+	Entries    map[string]*PhaseTrackerEntry
+
+	dirty bool
+	store StateStore
+	mutex sync.Mutex
+}
+
+// PhaseTrackerEntry is the last notified phase of an object
+type PhaseTrackerEntry struct {
+	Phase      string
+	NotifiedAt time.Time
+}
+
+// NewPhaseTracker returns a new PhaseTracker, loaded from the given store (which is optional)
+func NewPhaseTracker(maxEntries int, ttl string, store StateStore) (*PhaseTracker, error) {
+	duration, err := time.ParseDuration(ttl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dedupTTL %s: %v", ttl, err)
+	}
+
+	tracker := &PhaseTracker{
+		MaxEntries: maxEntries,
+		TTL:        duration,
+		Entries:    make(map[string]*PhaseTrackerEntry),
+		store:      store,
+	}
+
+	if store != nil {
+		entries := make(map[string]*PhaseTrackerEntry)
+		if _, err := store.Load(&entries); err != nil {
+			return nil, err
+		}
+		tracker.Entries = entries
+	}
+
+	return tracker, nil
+}
+
+// ShouldNotify returns true if the given phase is different from the last notified phase
+// of the object with the given UID - in which case it is recorded as the last notified phase.
+func (tracker *PhaseTracker) ShouldNotify(uid string, phase string) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	now := time.Now()
+	if entry, found := tracker.Entries[uid]; found && now.Sub(entry.NotifiedAt) < tracker.TTL {
+		if entry.Phase == phase {
+			return false
+		}
+	}
+
+	tracker.Entries[uid] = &PhaseTrackerEntry{
+		Phase:      phase,
+		NotifiedAt: now,
+	}
+	tracker.dirty = true
+	tracker.evict(now)
+	return true
+}
+
+// Forget removes the object with the given UID
+func (tracker *PhaseTracker) Forget(uid string) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if _, found := tracker.Entries[uid]; found {
+		delete(tracker.Entries, uid)
+		tracker.dirty = true
+	}
+}
+
+// Len returns the number of tracked objects
+func (tracker *PhaseTracker) Len() int {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return len(tracker.Entries)
+}
+
+// evict removes the expired entries, and then the oldest ones, until we have at most MaxEntries
+// it must be called with the lock held
+func (tracker *PhaseTracker) evict(now time.Time) {
+	if len(tracker.Entries) <= tracker.MaxEntries {
+		return
+	}
+
+	for uid, entry := range tracker.Entries {
+		if now.Sub(entry.NotifiedAt) >= tracker.TTL {
+			delete(tracker.Entries, uid)
+		}
+	}
+
+	for len(tracker.Entries) > tracker.MaxEntries {
+		var oldestUID string
+		var oldest *PhaseTrackerEntry
+		for uid, entry := range tracker.Entries {
+			if oldest == nil || entry.NotifiedAt.Before(oldest.NotifiedAt) {
+				oldestUID, oldest = uid, entry
+			}
+		}
+		delete(tracker.Entries, oldestUID)
+	}
+}
+
+// Flush saves the tracked objects to the store, if they have changed
+func (tracker *PhaseTracker) Flush() error {
+	if tracker.store == nil {
+		return nil
+	}
+
+	tracker.mutex.Lock()
+	if !tracker.dirty {
+		tracker.mutex.Unlock()
+		return nil
+	}
+	entries := make(map[string]PhaseTrackerEntry, len(tracker.Entries))
+	for uid, entry := range tracker.Entries {
+		entries[uid] = *entry
+	}
+	tracker.dirty = false
+	tracker.mutex.Unlock()
+
+	if err := tracker.store.Save(entries); err != nil {
+		// saved again on the next flush - with the changes made in the meantime
+		tracker.mutex.Lock()
+		tracker.dirty = true
+		tracker.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// flushPeriodically saves the entries to the store (if any) at the given interval, until the stop channel is closed
+func (tracker *PhaseTracker) flushPeriodically(interval time.Duration, stop <-chan struct{}) {
+	if tracker.store == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := tracker.Flush(); err != nil {
+				glog.Warningf("Failed to save the notified phases: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPhaseTrackerShouldNotify(t *testing.T) {
+	tracker := &PhaseTracker{
+		MaxEntries: 2,
+		TTL:        time.Hour,
+		Entries:    make(map[string]*PhaseTrackerEntry),
+	}
+
+	tests := []struct {
+		uid            string
+		phase          string
+		expectedResult bool
+	}{
+		// should notify a new object
+		{uid: "a", phase: "Running", expectedResult: true},
+		// should not notify the same phase twice
+		{uid: "a", phase: "Running", expectedResult: false},
+		// should notify a phase transition
+		{uid: "a", phase: "Complete", expectedResult: true},
+		{uid: "a", phase: "Complete", expectedResult: false},
+		{uid: "b", phase: "Complete", expectedResult: true},
+		// should evict the oldest object when full
+		{uid: "c", phase: "Complete", expectedResult: true},
+		{uid: "a", phase: "Complete", expectedResult: true},
+	}
+
+	for count, test := range tests {
+		result := tracker.ShouldNotify(test.uid, test.phase)
+		if result != test.expectedResult {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedResult, result)
+		}
+		if tracker.Len() > tracker.MaxEntries {
+			t.Errorf("Test[%d] Failed: Expected at most %d entries but got %d", count, tracker.MaxEntries, tracker.Len())
+		}
+	}
+}
+
+func TestPhaseTrackerFlushPeriodically(t *testing.T) {
+	store := &countingStateStore{}
+	tracker, err := NewPhaseTracker(10, "1h", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		tracker.flushPeriodically(time.Millisecond, stop)
+		close(stopped)
+	}()
+	tracker.ShouldNotify("a", "Complete")
+	waitForSaves(t, store, 1)
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the periodic flush to stop")
+	}
+	tracker.ShouldNotify("b", "Complete")
+	time.Sleep(10 * time.Millisecond)
+	if store.Saves() != 1 {
+		t.Errorf("Expected no more saves once stopped but got %d", store.Saves())
+	}
+}
+
+func TestPhaseTrackerFlushRetry(t *testing.T) {
+	store := &countingStateStore{}
+	tracker, err := NewPhaseTracker(10, "1h", store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.ShouldNotify("a", "Complete")
+
+	store.SetError(errors.New("configmap update failed"))
+	if err := tracker.Flush(); err == nil {
+		t.Errorf("Expected the error of the store")
+	}
+	store.SetError(nil)
+	if err := tracker.Flush(); err != nil {
+		t.Errorf("Expected the notified phases to be saved but got %v", err)
+	}
+	if store.Saves() != 2 {
+		t.Errorf("Expected the failed save to be retried but got %d saves", store.Saves())
+	}
+	if err := tracker.Flush(); err != nil || store.Saves() != 2 {
+		t.Errorf("Expected nothing more to save but got %d saves (%v)", store.Saves(), err)
+	}
+}
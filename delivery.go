@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pborman/uuid"
+	"github.com/wm/go-flowdock/flowdock"
+)
+
+const (
+	DefaultRetryInitialBackoff = "1s"
+	DefaultRetryMaxBackoff     = "5m"
+	DefaultRetryMaxAge         = "1h"
+	DefaultDeadLetterFileName  = "dead-letters.log"
+)
+
+// Delivery is a rendered Flowdock inbox message waiting to be sent
+type Delivery struct {
+	ID            string
+	CreatedAt     time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	Options       flowdock.InboxCreateOptions
+}
+
+// DeliveryQueue sends deliveries one at a time, retrying the failed ones
+// with an exponential backoff (or the delay requested by the server)
+// until they are delivered, or too old - in which case they are written to the dead-letter log.
+// If a directory is configured, the pending deliveries are stored on disk,
+// so that they survive a restart.
+type DeliveryQueue struct {
+	Name           string
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxAge         time.Duration
+	Dir            string
+	DeadLetterPath string
+
+	send    func(*Delivery) error
+	mutex   sync.Mutex
+	pending []*Delivery
+	wakeup  chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// RetryableError is an error for which the delivery should be retried
+// optionally after a specific delay
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func NewDeliveryQueue(name string, config NotifierConfig, send func(*Delivery) error) (*DeliveryQueue, error) {
+	initialBackoff, err := time.ParseDuration(config.RetryInitialBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retryInitialBackoff %s: %v", config.RetryInitialBackoff, err)
+	}
+	maxBackoff, err := time.ParseDuration(config.RetryMaxBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retryMaxBackoff %s: %v", config.RetryMaxBackoff, err)
+	}
+	maxAge, err := time.ParseDuration(config.RetryMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retryMaxAge %s: %v", config.RetryMaxAge, err)
+	}
+
+	queue := &DeliveryQueue{
+		Name:           name,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     maxBackoff,
+		MaxAge:         maxAge,
+		DeadLetterPath: config.DeadLetterPath,
+		send:           send,
+		wakeup:         make(chan struct{}, 1),
+		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+	}
+
+	if len(config.QueueDir) > 0 {
+		// each notifier has its own sub-directory, so that the notifiers sharing a queueDir
+		// don't load (and send) the deliveries of each other
+		queue.Dir = filepath.Join(config.QueueDir, name)
+		if err := os.MkdirAll(queue.Dir, 0700); err != nil {
+			return nil, err
+		}
+		if len(queue.DeadLetterPath) == 0 {
+			queue.DeadLetterPath = filepath.Join(queue.Dir, DefaultDeadLetterFileName)
+		}
+		if err := queue.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return queue, nil
+}
+
+// Enqueue adds a new delivery to the queue, to be sent as soon as possible
+func (queue *DeliveryQueue) Enqueue(options flowdock.InboxCreateOptions) {
+	now := time.Now()
+	delivery := &Delivery{
+		ID:            uuid.New(),
+		CreatedAt:     now,
+		NextAttemptAt: now,
+		Options:       options,
+	}
+
+	if err := queue.store(delivery); err != nil {
+		glog.Warningf("Failed to store delivery %s of %s on disk: %v", delivery.ID, queue.Name, err)
+	}
+
+	queue.mutex.Lock()
+	queue.pending = append(queue.pending, delivery)
+	queue.mutex.Unlock()
+
+	select {
+	case queue.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Len returns the number of pending deliveries
+func (queue *DeliveryQueue) Len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return len(queue.pending)
+}
+
+// Run sends the pending deliveries, until the queue is stopped
+func (queue *DeliveryQueue) Run() {
+	defer close(queue.stopped)
+	for {
+		select {
+		case <-queue.done:
+			return
+		default:
+		}
+
+		delivery := queue.next()
+		if delivery == nil {
+			select {
+			case <-queue.wakeup:
+			case <-queue.done:
+				return
+			}
+			continue
+		}
+
+		if wait := delivery.NextAttemptAt.Sub(time.Now()); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-queue.wakeup:
+			case <-queue.done:
+				return
+			}
+			continue
+		}
+
+		queue.attempt(delivery)
+	}
+}
+
+// Stop stops sending the deliveries - waiting for the current attempt, if any - and returns the pending ones
+// They are still stored on disk, if the queue has a directory.
+// The queue must have been started.
+func (queue *DeliveryQueue) Stop() []*Delivery {
+	close(queue.done)
+	<-queue.stopped
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	pending := queue.pending
+	queue.pending = nil
+	return pending
+}
+
+// HandOver stops the queue, and moves its pending deliveries to the given queue - which must not have been started yet
+// The deliveries of the stopped queue replace the ones with the same ID that the next queue
+// may have loaded from its directory, as they are more recent. And if both queues share the same directory,
+// the deliveries loaded by the next queue which are no longer pending have been sent in the meantime.
+func (queue *DeliveryQueue) HandOver(next *DeliveryQueue) {
+	pending := queue.Stop()
+	handedOver := make(map[string]bool, len(pending))
+	for _, delivery := range pending {
+		handedOver[delivery.ID] = true
+	}
+
+	next.mutex.Lock()
+	kept := []*Delivery{}
+	for _, delivery := range next.pending {
+		if next.Dir != queue.Dir && !handedOver[delivery.ID] {
+			kept = append(kept, delivery)
+		}
+	}
+	next.pending = kept
+	for _, delivery := range pending {
+		if next.Dir != queue.Dir {
+			if err := next.store(delivery); err != nil {
+				glog.Warningf("Failed to store delivery %s of %s on disk: %v", delivery.ID, next.Name, err)
+			}
+			queue.removeFile(delivery)
+		}
+		next.pending = append(next.pending, delivery)
+	}
+	sort.Sort(deliveriesByCreationTime(next.pending))
+	next.mutex.Unlock()
+
+	if len(pending) > 0 {
+		glog.Infof("Handed over %d pending deliveries from %s to %s", len(pending), queue.Name, next.Name)
+	}
+}
+
+// next returns the pending delivery with the earliest next attempt, or nil
+func (queue *DeliveryQueue) next() *Delivery {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	var next *Delivery
+	for _, delivery := range queue.pending {
+		if next == nil || delivery.NextAttemptAt.Before(next.NextAttemptAt) {
+			next = delivery
+		}
+	}
+	return next
+}
+
+func (queue *DeliveryQueue) attempt(delivery *Delivery) {
+	delivery.Attempts++
+	err := queue.send(delivery)
+	if err == nil {
+		glog.V(2).Infof("Delivery %s of %s succeeded after %d attempt(s)", delivery.ID, queue.Name, delivery.Attempts)
+		queue.remove(delivery)
+		return
+	}
+
+	delivery.LastError = err.Error()
+	retryable, ok := err.(*RetryableError)
+	if !ok {
+		glog.Errorf("Delivery %s of %s failed with a non-retryable error: %v", delivery.ID, queue.Name, err)
+		queue.deadLetter(delivery)
+		return
+	}
+
+	delay := retryable.RetryAfter
+	if delay <= 0 {
+		delay = queue.backoff(delivery.Attempts)
+	}
+	delivery.NextAttemptAt = time.Now().Add(delay)
+	if delivery.NextAttemptAt.Sub(delivery.CreatedAt) > queue.MaxAge {
+		glog.Errorf("Delivery %s of %s failed %d time(s) and is now too old to be retried: %v", delivery.ID, queue.Name, delivery.Attempts, err)
+		queue.deadLetter(delivery)
+		return
+	}
+
+	glog.Warningf("Delivery %s of %s failed (attempt %d), retrying in %v: %v", delivery.ID, queue.Name, delivery.Attempts, delay, err)
+	if err := queue.store(delivery); err != nil {
+		glog.Warningf("Failed to store delivery %s of %s on disk: %v", delivery.ID, queue.Name, err)
+	}
+}
+
+// backoff returns the exponential backoff delay for the given number of attempts
+func (queue *DeliveryQueue) backoff(attempts int) time.Duration {
+	delay := queue.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= queue.MaxBackoff {
+			return queue.MaxBackoff
+		}
+	}
+	return delay
+}
+
+func (queue *DeliveryQueue) remove(delivery *Delivery) {
+	queue.mutex.Lock()
+	for i, d := range queue.pending {
+		if d == delivery {
+			queue.pending = append(queue.pending[:i], queue.pending[i+1:]...)
+			break
+		}
+	}
+	queue.mutex.Unlock()
+
+	queue.removeFile(delivery)
+}
+
+// removeFile removes the delivery from the disk, if the queue has a directory
+func (queue *DeliveryQueue) removeFile(delivery *Delivery) {
+	if len(queue.Dir) > 0 {
+		if err := os.Remove(queue.deliveryPath(delivery)); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("Failed to remove delivery %s of %s from disk: %v", delivery.ID, queue.Name, err)
+		}
+	}
+}
+
+// deadLetter removes the delivery from the queue, and appends it to the dead-letter log
+func (queue *DeliveryQueue) deadLetter(delivery *Delivery) {
+	queue.remove(delivery)
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		glog.Errorf("Failed to marshal dead delivery %s of %s: %v", delivery.ID, queue.Name, err)
+		return
+	}
+
+	if len(queue.DeadLetterPath) == 0 {
+		glog.Errorf("Dead delivery of %s: %s", queue.Name, data)
+		return
+	}
+
+	file, err := os.OpenFile(queue.DeadLetterPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		glog.Errorf("Failed to open the dead-letter log %s: %v - dead delivery is %s", queue.DeadLetterPath, err, data)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		glog.Errorf("Failed to write to the dead-letter log %s: %v - dead delivery is %s", queue.DeadLetterPath, err, data)
+	}
+}
+
+func (queue *DeliveryQueue) deliveryPath(delivery *Delivery) string {
+	return filepath.Join(queue.Dir, delivery.ID+".json")
+}
+
+// store writes the delivery on disk, if the queue has a directory
+func (queue *DeliveryQueue) store(delivery *Delivery) error {
+	if len(queue.Dir) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	// the deliveries are only readable by the owner, as their last error may contain private data
+	// write to a temp file first, so that we never load a partially written delivery
+	tmpPath := queue.deliveryPath(delivery) + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, queue.deliveryPath(delivery))
+}
+
+// load reads the pending deliveries stored on disk
+func (queue *DeliveryQueue) load() error {
+	paths, err := filepath.Glob(filepath.Join(queue.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		delivery := &Delivery{}
+		if err := json.Unmarshal(data, delivery); err != nil {
+			glog.Warningf("Ignoring invalid delivery file %s: %v", path, err)
+			continue
+		}
+		queue.pending = append(queue.pending, delivery)
+	}
+
+	sort.Sort(deliveriesByCreationTime(queue.pending))
+	if len(queue.pending) > 0 {
+		glog.Infof("Loaded %d pending deliveries for %s from %s", len(queue.pending), queue.Name, queue.Dir)
+	}
+	return nil
+}
+
+type deliveriesByCreationTime []*Delivery
+
+func (d deliveriesByCreationTime) Len() int           { return len(d) }
+func (d deliveriesByCreationTime) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d deliveriesByCreationTime) Less(i, j int) bool { return d[i].CreatedAt.Before(d[j].CreatedAt) }
+
+// classifyFlowdockError wraps the errors returned by the Flowdock API
+// that are worth retrying (network errors, rate limiting, server errors)
+// into a RetryableError
+func classifyFlowdockError(resp *http.Response, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return &RetryableError{Err: err}
+	}
+
+	if resp == nil {
+		// the request could not even be sent
+		return &RetryableError{Err: err}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &RetryableError{Err: err, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode >= 500:
+		return &RetryableError{Err: err, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	default:
+		return err
+	}
+}
+
+// redactError replaces the given secret in the message of the error,
+// so that it is never logged, stored or exposed.
+// The Flowdock client includes the request URL - and so the flow token - in its errors.
+func redactError(err error, secret string) error {
+	if err == nil || len(secret) == 0 || !strings.Contains(err.Error(), secret) {
+		return err
+	}
+	if retryable, ok := err.(*RetryableError); ok {
+		return &RetryableError{Err: redactError(retryable.Err, secret), RetryAfter: retryable.RetryAfter}
+	}
+	return errors.New(strings.Replace(err.Error(), secret, RedactedValue, -1))
+}
+
+// parseRetryAfter parses the value of a Retry-After header,
+// which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(time.Now())
+	}
+	return 0
+}
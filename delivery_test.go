@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wm/go-flowdock/flowdock"
+)
+
+func TestDeliveryQueueBackoff(t *testing.T) {
+	queue := &DeliveryQueue{
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     10 * time.Second,
+	}
+
+	tests := []struct {
+		attempts      int
+		expectedDelay time.Duration
+	}{
+		{attempts: 1, expectedDelay: 1 * time.Second},
+		{attempts: 2, expectedDelay: 2 * time.Second},
+		{attempts: 3, expectedDelay: 4 * time.Second},
+		{attempts: 4, expectedDelay: 8 * time.Second},
+		// should not exceed the max backoff
+		{attempts: 5, expectedDelay: 10 * time.Second},
+		{attempts: 50, expectedDelay: 10 * time.Second},
+	}
+
+	for count, test := range tests {
+		result := queue.backoff(test.attempts)
+		if result != test.expectedDelay {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedDelay, result)
+		}
+	}
+}
+
+func TestClassifyFlowdockError(t *testing.T) {
+	err := errors.New("failure")
+
+	tests := []struct {
+		resp               *http.Response
+		err                error
+		expectedRetryable  bool
+		expectedRetryAfter time.Duration
+	}{
+		// no error
+		{
+			resp: &http.Response{StatusCode: 200},
+		},
+		// should retry when the request could not be sent
+		{
+			err:               err,
+			expectedRetryable: true,
+		},
+		// should retry after the delay requested by the server when rate-limited
+		{
+			resp: &http.Response{
+				StatusCode: 429,
+				Header:     http.Header{"Retry-After": []string{"30"}},
+			},
+			err:                err,
+			expectedRetryable:  true,
+			expectedRetryAfter: 30 * time.Second,
+		},
+		// should retry on server errors
+		{
+			resp:              &http.Response{StatusCode: 503},
+			err:               err,
+			expectedRetryable: true,
+		},
+		// should not retry on client errors
+		{
+			resp: &http.Response{StatusCode: 404},
+			err:  err,
+		},
+	}
+
+	for count, test := range tests {
+		result := classifyFlowdockError(test.resp, test.err)
+		retryable, isRetryable := result.(*RetryableError)
+		if isRetryable != test.expectedRetryable {
+			t.Errorf("Test[%d] Failed: Expected retryable '%v' but got '%v'", count, test.expectedRetryable, isRetryable)
+			continue
+		}
+		if isRetryable && retryable.RetryAfter != test.expectedRetryAfter {
+			t.Errorf("Test[%d] Failed: Expected retry after '%v' but got '%v'", count, test.expectedRetryAfter, retryable.RetryAfter)
+		}
+	}
+}
+
+func TestDeliveryQueueHandOver(t *testing.T) {
+	previousDir, err := ioutil.TempDir("", "previous-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(previousDir)
+	nextDir, err := ioutil.TempDir("", "next-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(nextDir)
+
+	config := NotifierConfig{
+		RetryInitialBackoff: "1s",
+		RetryMaxBackoff:     "1m",
+		RetryMaxAge:         "1h",
+	}
+	send := func(*Delivery) error {
+		return &RetryableError{Err: errors.New("failure"), RetryAfter: time.Minute}
+	}
+
+	previousConfig := config
+	previousConfig.QueueDir = previousDir
+	previous, err := NewDeliveryQueue("previous", previousConfig, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.Enqueue(flowdock.InboxCreateOptions{Subject: "first"})
+	previous.Enqueue(flowdock.InboxCreateOptions{Subject: "second"})
+
+	// another notifier sharing the same queueDir should not load the deliveries of this notifier
+	other, err := NewDeliveryQueue("other", previousConfig, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Len() != 0 {
+		t.Errorf("Expected no deliveries loaded by another notifier from %s but got %d", previousDir, other.Len())
+	}
+	// the same notifier re-created with the same queueDir (on reload) loads its deliveries
+	sameDir, err := NewDeliveryQueue("previous", previousConfig, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameDir.Len() != 2 {
+		t.Fatalf("Expected 2 deliveries loaded from %s but got %d", previousDir, sameDir.Len())
+	}
+	files, _ := filepath.Glob(filepath.Join(previousDir, "previous", "*.json"))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected the delivery %s to be stored with mode 0600 but got %v", file, info.Mode().Perm())
+		}
+	}
+	// started once the deliveries have been loaded, so that its attempts don't race with the loading
+	go previous.Run()
+
+	nextConfig := config
+	nextConfig.QueueDir = nextDir
+	next, err := NewDeliveryQueue("next", nextConfig, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.HandOver(next)
+
+	if previous.Len() != 0 {
+		t.Errorf("Expected no deliveries left in the previous queue but got %d", previous.Len())
+	}
+	if next.Len() != 2 {
+		t.Errorf("Expected 2 deliveries in the next queue but got %d", next.Len())
+	}
+	for dir, expectedFiles := range map[string]int{filepath.Join(previousDir, "previous"): 0, filepath.Join(nextDir, "next"): 2} {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		if len(files) != expectedFiles {
+			t.Errorf("Expected %d deliveries stored in %s but got %d", expectedFiles, dir, len(files))
+		}
+	}
+
+	go next.Run()
+	next.HandOver(sameDir)
+	if sameDir.Len() != 2 {
+		t.Errorf("Expected 2 deliveries in the queue of the same notifier but got %d", sameDir.Len())
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	tests := []struct {
+		err                error
+		secret             string
+		expectedError      string
+		expectedRetryAfter time.Duration
+		expectedRetryable  bool
+	}{
+		{nil, "secret", "", 0, false},
+		{errors.New("POST https://api.flowdock.com/v1/messages/team_inbox/secret: 401"), "", "POST https://api.flowdock.com/v1/messages/team_inbox/secret: 401", 0, false},
+		{errors.New("POST https://api.flowdock.com/v1/messages/team_inbox/secret: 401"), "secret", "POST https://api.flowdock.com/v1/messages/team_inbox/REDACTED: 401", 0, false},
+		{&RetryableError{Err: errors.New("POST .../team_inbox/secret: 503"), RetryAfter: time.Minute}, "secret", "POST .../team_inbox/REDACTED: 503", time.Minute, true},
+		{&RetryableError{Err: errors.New("connection refused")}, "secret", "connection refused", 0, true},
+	}
+
+	for count, test := range tests {
+		err := redactError(test.err, test.secret)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("Test[%d] Failed: Expected no error but got '%v'", count, err)
+			}
+			continue
+		}
+		if err.Error() != test.expectedError {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedError, err.Error())
+		}
+		retryable, ok := err.(*RetryableError)
+		if ok != test.expectedRetryable {
+			t.Errorf("Test[%d] Failed: Expected a retryable error '%v' but got '%v'", count, test.expectedRetryable, ok)
+		}
+		if ok && retryable.RetryAfter != test.expectedRetryAfter {
+			t.Errorf("Test[%d] Failed: Expected a retry after '%v' but got '%v'", count, test.expectedRetryAfter, retryable.RetryAfter)
+		}
+	}
+}
+
+func TestFlowdockNotifierTokenSourceError(t *testing.T) {
+	config := NotifierConfig{}
+	config.SetDefaults()
+	notifier, err := NewFlowdockNotifier("secret", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.TokenSource = func(namespace string) (string, error) {
+		return "", fmt.Errorf("secret %s/flowdock not found", namespace)
+	}
+
+	err = notifier.send(&Delivery{Options: flowdock.InboxCreateOptions{Project: "team"}})
+	if _, ok := err.(*RetryableError); !ok {
+		t.Errorf("Expected a retryable error when the token can't be read, but got '%#v'", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "secret team/flowdock not found") {
+		t.Errorf("Expected the error of the token source but got '%v'", err)
+	}
+}
+
+func TestFlowdockNotifierDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dry-run-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NotifierConfig{
+		Token:               "token",
+		RetryInitialBackoff: "1s",
+		RetryMaxBackoff:     "1m",
+		RetryMaxAge:         "1h",
+		QueueDir:            dir,
+	}
+	stored, err := NewDeliveryQueue("stored", config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Enqueue(flowdock.InboxCreateOptions{Subject: "stored"})
+
+	config.DryRun = true
+	notifier, err := NewFlowdockNotifier("dry-run", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// any call to the API would fail
+	notifier.FlowdockClient.RestURL, _ = url.Parse("http://127.0.0.1:1/")
+
+	if notifier.Queue.Len() != 0 {
+		t.Errorf("Expected the stored deliveries not to be loaded but got %d", notifier.Queue.Len())
+	}
+	if err := notifier.Send(NewSampleBuildEvent()); err != nil {
+		t.Errorf("Expected the message to be logged but got '%v'", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "stored", "*.json")); len(files) != 1 {
+		t.Errorf("Expected the stored delivery to be left in %s but got %d", dir, len(files))
+	}
+}
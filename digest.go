@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/unversioned"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultDigestSubjectTemplate = "{{.ObjectType}} digest{{if .Namespace}} for {{.Namespace}}{{end}}: {{.Status}}"
+	DefaultDigestContentTemplate = `<h3>{{.ObjectType}} digest{{if .Namespace}} for {{.Namespace}}{{end}}</h3>
+<p>From {{.ObjectStartTime}} to {{.ObjectEndTime}}: {{.SuccessCount}} succeeded, {{.FailureCount}} failed, {{.OtherCount}} other</p>
+<table>
+	<tr><th>{{.ObjectType}}</th><th>Status</th><th>Duration</th><th>Link</th></tr>
+{{range .Items}}
+	<tr><td>{{.Namespace}}/{{.Name}}</td><td>{{.Status}}</td><td>{{.Duration}}</td><td><a href="{{.Url}}">{{.Url}}</a></td></tr>
+{{end}}
+</table>`
+)
+
+// Batcher collects the events over a time window, and then sends a single DigestEvent
+// Failures can optionally bypass the batching, and be sent immediately.
+type Batcher struct {
+	Window         time.Duration
+	BypassFailures bool
+
+	out     func(Event)
+	mutex   sync.Mutex
+	pending []Event
+	start   time.Time
+	// timer flushes the current batch at the end of the window
+	timer *time.Timer
+	// batch identifies the current batch, so that the timer of a batch which has already been flushed does nothing
+	batch uint64
+}
+
+func NewBatcher(window string, bypassFailures bool, out func(Event)) (*Batcher, error) {
+	duration, err := time.ParseDuration(window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid batchWindow %s: %v", window, err)
+	}
+	return &Batcher{
+		Window:         duration,
+		BypassFailures: bypassFailures,
+		out:            out,
+	}, nil
+}
+
+// Add adds an event to the current batch - starting a new batch if needed
+// The reports and the digests are never batched.
+func (batcher *Batcher) Add(event Event) {
+	if isSummaryEvent(event) || (batcher.BypassFailures && event.IsFailure()) {
+		batcher.out(event)
+		return
+	}
+
+	batcher.mutex.Lock()
+	defer batcher.mutex.Unlock()
+
+	if len(batcher.pending) == 0 {
+		batcher.start = time.Now()
+		batch := batcher.batch
+		batcher.timer = time.AfterFunc(batcher.Window, func() {
+			batcher.flushBatch(batch)
+		})
+	}
+	batcher.pending = append(batcher.pending, event)
+}
+
+// Flush sends the current batch: as-is if it contains a single event, or as a digest
+func (batcher *Batcher) Flush() {
+	batcher.mutex.Lock()
+	events, start := batcher.take()
+	batcher.mutex.Unlock()
+
+	batcher.send(events, start)
+}
+
+// flushBatch sends the given batch at the end of its window - unless it has already been flushed
+func (batcher *Batcher) flushBatch(batch uint64) {
+	batcher.mutex.Lock()
+	if batch != batcher.batch {
+		batcher.mutex.Unlock()
+		return
+	}
+	events, start := batcher.take()
+	batcher.mutex.Unlock()
+
+	batcher.send(events, start)
+}
+
+// take returns the events of the current batch, and starts a new one - the mutex must be held
+func (batcher *Batcher) take() ([]Event, time.Time) {
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+	batcher.batch++
+	events := batcher.pending
+	batcher.pending = nil
+	return events, batcher.start
+}
+
+func (batcher *Batcher) send(events []Event, start time.Time) {
+	switch len(events) {
+	case 0:
+		return
+	case 1:
+		batcher.out(events[0])
+	default:
+		glog.V(2).Infof("Sending a digest of %d events", len(events))
+		batcher.out(NewDigestEvent(events, start, time.Now()))
+	}
+}
+
+// isSummaryEvent returns true for the events which already summarize other events - the reports and the digests
+func isSummaryEvent(event Event) bool {
+	switch event.(type) {
+	case *BuildsReport, *DigestEvent:
+		return true
+	default:
+		return false
+	}
+}
+
+// DigestEvent is an Event that summarizes multiple events
+type DigestEvent struct {
+	items []DigestItem
+	start unversioned.Time
+	end   unversioned.Time
+}
+
+// DigestItem is the summary of an event, in a digest
+type DigestItem struct {
+	Namespace  string
+	Name       string
+	ObjectType string
+	Status     string
+	Duration   time.Duration
+	IsSuccess  bool
+	IsFailure  bool
+	Url        string
+}
+
+func NewDigestEvent(events []Event, start time.Time, end time.Time) *DigestEvent {
+	items := []DigestItem{}
+	for _, event := range events {
+		// digests of digests are flattened
+		if digest, isDigest := event.(*DigestEvent); isDigest {
+			items = append(items, digest.items...)
+			continue
+		}
+		items = append(items, DigestItem{
+			Namespace:  event.Namespace(),
+			Name:       event.Name(),
+			ObjectType: event.ObjectType(),
+			Status:     event.Status(),
+			Duration:   event.ObjectDuration(),
+			IsSuccess:  event.IsSuccess(),
+			IsFailure:  event.IsFailure(),
+			Url:        event.Url(),
+		})
+	}
+	return &DigestEvent{
+		items: items,
+		start: unversioned.NewTime(start),
+		end:   unversioned.NewTime(end),
+	}
+}
+
+// Items returns the summaries of the events
+func (event *DigestEvent) Items() []DigestItem {
+	return event.items
+}
+
+// SuccessCount returns the number of successful events
+func (event *DigestEvent) SuccessCount() int {
+	count := 0
+	for _, item := range event.items {
+		if item.IsSuccess {
+			count++
+		}
+	}
+	return count
+}
+
+// FailureCount returns the number of failed events
+func (event *DigestEvent) FailureCount() int {
+	count := 0
+	for _, item := range event.items {
+		if item.IsFailure {
+			count++
+		}
+	}
+	return count
+}
+
+// OtherCount returns the number of events that are neither successful nor failed
+func (event *DigestEvent) OtherCount() int {
+	return len(event.items) - event.SuccessCount() - event.FailureCount()
+}
+
+// Namespace returns the namespace of the events, if they all share the same namespace
+func (event *DigestEvent) Namespace() string {
+	namespace := ""
+	for i, item := range event.items {
+		if i > 0 && item.Namespace != namespace {
+			return ""
+		}
+		namespace = item.Namespace
+	}
+	return namespace
+}
+
+func (event *DigestEvent) Name() string {
+	return fmt.Sprintf("%d events", len(event.items))
+}
+
+// ObjectType returns the type of the objects, if they all share the same type
+func (event *DigestEvent) ObjectType() string {
+	objectType := ""
+	for i, item := range event.items {
+		if i > 0 && item.ObjectType != objectType {
+			return "Object"
+		}
+		objectType = item.ObjectType
+	}
+	return objectType
+}
+
+func (event *DigestEvent) ObjectStartTime() *unversioned.Time {
+	return &event.start
+}
+
+func (event *DigestEvent) ObjectEndTime() *unversioned.Time {
+	return &event.end
+}
+
+func (event *DigestEvent) ObjectDuration() time.Duration {
+	return event.end.Sub(event.start.Time)
+}
+
+func (event *DigestEvent) Input() string {
+	return ""
+}
+
+func (event *DigestEvent) Output() string {
+	return ""
+}
+
+func (event *DigestEvent) Status() string {
+	return fmt.Sprintf("%d succeeded, %d failed", event.SuccessCount(), event.FailureCount())
+}
+
+func (event *DigestEvent) IsSuccess() bool {
+	return event.FailureCount() == 0 && event.SuccessCount() > 0
+}
+
+func (event *DigestEvent) IsFailure() bool {
+	return event.FailureCount() > 0
+}
+
+func (event *DigestEvent) Transition() string {
+	return ""
+}
+
+func (event *DigestEvent) Logs() string {
+	return ""
+}
+
+func (event *DigestEvent) Events() []string {
+	return []string{}
+}
+
+func (event *DigestEvent) NodeName() string {
+	return ""
+}
+
+func (event *DigestEvent) Url() string {
+	return ""
+}
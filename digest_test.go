@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// testEvent is a minimal Event implementation, for the tests
+type testEvent struct {
+	namespace string
+	name      string
+	status    string
+	success   bool
+	failure   bool
+}
+
+func (e *testEvent) Namespace() string                  { return e.namespace }
+func (e *testEvent) Name() string                       { return e.name }
+func (e *testEvent) ObjectType() string                 { return "Build" }
+func (e *testEvent) ObjectStartTime() *unversioned.Time { return nil }
+func (e *testEvent) ObjectEndTime() *unversioned.Time   { return nil }
+func (e *testEvent) ObjectDuration() time.Duration      { return time.Minute }
+func (e *testEvent) Input() string                      { return "" }
+func (e *testEvent) Output() string                     { return "" }
+func (e *testEvent) Status() string                     { return e.status }
+func (e *testEvent) IsSuccess() bool                    { return e.success }
+func (e *testEvent) IsFailure() bool                    { return e.failure }
+func (e *testEvent) Transition() string                 { return "" }
+func (e *testEvent) Logs() string                       { return "" }
+func (e *testEvent) Events() []string                   { return []string{} }
+func (e *testEvent) NodeName() string                   { return "" }
+func (e *testEvent) Url() string                        { return "" }
+
+func TestBatcher(t *testing.T) {
+	sent := []Event{}
+	batcher := &Batcher{
+		Window:         time.Hour,
+		BypassFailures: true,
+		out: func(event Event) {
+			sent = append(sent, event)
+		},
+	}
+
+	batcher.Add(&testEvent{namespace: "a", name: "a-1", status: "Complete", success: true})
+	batcher.Add(&testEvent{namespace: "a", name: "a-2", status: "Complete", success: true})
+	batcher.Add(&testEvent{namespace: "a", name: "a-3", status: "Failed", failure: true})
+	batcher.Add(&testEvent{namespace: "a", name: "a-4", status: "New"})
+
+	// the failure should bypass the batching
+	if len(sent) != 1 || sent[0].Name() != "a-3" {
+		t.Fatalf("Expected the failure to be sent immediately, but got %v", sent)
+	}
+
+	batcher.Flush()
+	if len(sent) != 2 {
+		t.Fatalf("Expected a digest to be sent, but got %v", sent)
+	}
+	digest, isDigest := sent[1].(*DigestEvent)
+	if !isDigest {
+		t.Fatalf("Expected a digest, but got %T", sent[1])
+	}
+	if len(digest.Items()) != 3 || digest.SuccessCount() != 2 || digest.FailureCount() != 0 || digest.OtherCount() != 1 {
+		t.Errorf("Unexpected digest %+v", digest.Items())
+	}
+	if digest.Namespace() != "a" {
+		t.Errorf("Expected the digest namespace to be 'a', but got '%s'", digest.Namespace())
+	}
+
+	// nothing left to send
+	batcher.Flush()
+	if len(sent) != 2 {
+		t.Errorf("Expected nothing more to be sent, but got %v", sent)
+	}
+}
+
+func TestBatcherFlushStopsTimer(t *testing.T) {
+	sent := []Event{}
+	batcher := &Batcher{
+		Window: time.Hour,
+		out: func(event Event) {
+			sent = append(sent, event)
+		},
+	}
+
+	batcher.Add(&testEvent{namespace: "a", name: "a-1", status: "Complete", success: true})
+	timer, firstBatch := batcher.timer, batcher.batch
+	batcher.Flush()
+	if timer.Stop() {
+		t.Errorf("Expected the timer of the flushed batch to be stopped")
+	}
+
+	batcher.Add(&testEvent{namespace: "a", name: "a-2", status: "Complete", success: true})
+	// the timer of the first batch fires late: it should not flush the second batch
+	batcher.flushBatch(firstBatch)
+	if len(sent) != 1 || sent[0].Name() != "a-1" {
+		t.Errorf("Expected only the first batch to be sent, but got %v", sent)
+	}
+
+	batcher.flushBatch(batcher.batch)
+	if len(sent) != 2 || sent[1].Name() != "a-2" {
+		t.Errorf("Expected the second batch to be sent at the end of its window, but got %v", sent)
+	}
+}
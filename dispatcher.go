@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultBufferSize = 100
+)
+
+// OverflowPolicy defines what happens when an event is dispatched to a full notifier buffer
+type OverflowPolicy string
+
+const (
+	// OverflowBlock blocks the watcher until there is room in the buffer
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest drops the oldest buffered event to make room for the new one
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest drops the new event
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+)
+
+// Dispatcher fans out the events from the watchers to the notifiers
+// Each notifier has its own bounded buffer, so that a slow notifier
+// doesn't stall the watchers nor the other notifiers.
+// Notifiers can be added and removed while the events are dispatched.
+type Dispatcher struct {
+	buffers map[string]*NotifierBuffer
+	// muted holds the time until which a notifier is muted - it outlives the restarts of the notifier
+	muted map[string]time.Time
+	mutex sync.RWMutex
+}
+
+// NotifierBuffer is a bounded buffer of events in front of a notifier
+type NotifierBuffer struct {
+	Name     string
+	Policy   OverflowPolicy
+	Notifier Notifier
+	// Batcher is optional: if set, the events are sent to the notifier as digests
+	Batcher *Batcher
+	// Quiet is optional: if set, the events are dropped or held during the quiet windows of the notifier
+	Quiet   *QuietGate
+	events  chan Event
+	dropped uint64
+	// done is closed to stop the buffer, and stopped once the notifier has handled its last event
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	next     *NotifierBuffer
+	// closing protects the channel of the notifier, which is closed when the buffer is stopped
+	closing sync.Mutex
+	closed  bool
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		buffers: make(map[string]*NotifierBuffer),
+		muted:   make(map[string]time.Time),
+	}
+}
+
+// AddNotifier registers a notifier with the dispatcher, and starts both the notifier
+// and the forwarding of its buffered events
+func (dispatcher *Dispatcher) AddNotifier(name string, notifier Notifier, config NotifierConfig) error {
+	buffer, err := newNotifierBuffer(name, notifier, config)
+	if err != nil {
+		return err
+	}
+
+	dispatcher.mutex.Lock()
+	if _, found := dispatcher.buffers[name]; found {
+		dispatcher.mutex.Unlock()
+		return fmt.Errorf("notifier %s is already registered", name)
+	}
+	dispatcher.buffers[name] = buffer
+	dispatcher.mutex.Unlock()
+
+	buffer.start()
+	return nil
+}
+
+// ReplaceNotifier registers a new notifier in place of the existing one with the same name (if any)
+// The new events are buffered for the new notifier right away, but it is started only once
+// the previous one has handled its last event, and handOver (which is optional) has been called
+// with the previous notifier - so that its pending work can be moved to the new one.
+// The events held by the quiet windows of the previous notifier are moved to the new one.
+func (dispatcher *Dispatcher) ReplaceNotifier(name string, notifier Notifier, config NotifierConfig, handOver func(previous Notifier)) error {
+	buffer, err := newNotifierBuffer(name, notifier, config)
+	if err != nil {
+		return err
+	}
+
+	dispatcher.mutex.Lock()
+	previous, found := dispatcher.buffers[name]
+	dispatcher.buffers[name] = buffer
+	dispatcher.mutex.Unlock()
+
+	if found {
+		previous.stop(buffer)
+		if handOver != nil {
+			handOver(previous.Notifier)
+		}
+	}
+	buffer.start()
+	return nil
+}
+
+// RemoveNotifier unregisters a notifier, and stops it once its buffered (and held) events
+// have been handled. It returns the removed notifier - which has stopped - or nil if it was not registered.
+func (dispatcher *Dispatcher) RemoveNotifier(name string) Notifier {
+	dispatcher.mutex.Lock()
+	buffer, found := dispatcher.buffers[name]
+	delete(dispatcher.buffers, name)
+	dispatcher.mutex.Unlock()
+
+	if !found {
+		return nil
+	}
+	buffer.Stop()
+	return buffer.Notifier
+}
+
+// HasNotifier returns true if a notifier with the given name has been registered
+func (dispatcher *Dispatcher) HasNotifier(name string) bool {
+	dispatcher.mutex.RLock()
+	defer dispatcher.mutex.RUnlock()
+	_, found := dispatcher.buffers[name]
+	return found
+}
+
+// Buffers returns the buffers of all the registered notifiers
+func (dispatcher *Dispatcher) Buffers() map[string]*NotifierBuffer {
+	dispatcher.mutex.RLock()
+	defer dispatcher.mutex.RUnlock()
+	buffers := make(map[string]*NotifierBuffer, len(dispatcher.buffers))
+	for name, buffer := range dispatcher.buffers {
+		buffers[name] = buffer
+	}
+	return buffers
+}
+
+// Mute drops the events dispatched to the given notifier until the given time
+// A zero time unmutes the notifier.
+func (dispatcher *Dispatcher) Mute(name string, until time.Time) {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	if until.IsZero() {
+		delete(dispatcher.muted, name)
+		return
+	}
+	dispatcher.muted[name] = until
+}
+
+// MutedUntil returns the time until which the given notifier is muted - or a zero time if it is not muted
+func (dispatcher *Dispatcher) MutedUntil(name string) time.Time {
+	dispatcher.mutex.RLock()
+	defer dispatcher.mutex.RUnlock()
+	if until, found := dispatcher.muted[name]; found && time.Now().Before(until) {
+		return until
+	}
+	return time.Time{}
+}
+
+// Dispatch sends the event to the buffers of the given notifiers - except the muted ones
+func (dispatcher *Dispatcher) Dispatch(notifierNames []string, event Event) {
+	for _, name := range notifierNames {
+		if !dispatcher.MutedUntil(name).IsZero() {
+			glog.V(3).Infof("Notifier %s is muted, dropping event for %s %s/%s", name, event.ObjectType(), event.Namespace(), event.Name())
+			mutedEvents.WithLabelValues(name).Inc()
+			continue
+		}
+		dispatcher.mutex.RLock()
+		buffer, found := dispatcher.buffers[name]
+		dispatcher.mutex.RUnlock()
+		if found {
+			buffer.Push(event)
+		}
+	}
+}
+
+func newNotifierBuffer(name string, notifier Notifier, config NotifierConfig) (*NotifierBuffer, error) {
+	policy := OverflowPolicy(config.OverflowPolicy)
+	switch policy {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+	default:
+		return nil, fmt.Errorf("unknown overflow policy %s for notifier %s", config.OverflowPolicy, name)
+	}
+
+	buffer := &NotifierBuffer{
+		Name:     name,
+		Policy:   policy,
+		Notifier: notifier,
+		events:   make(chan Event, config.BufferSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if len(config.BatchWindow) > 0 {
+		batcher, err := NewBatcher(config.BatchWindow, config.BatchBypassFailures, buffer.send)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batching for notifier %s: %v", name, err)
+		}
+		buffer.Batcher = batcher
+	}
+	if len(config.QuietWindows) > 0 {
+		quiet, err := NewQuietGate(config.QuietWindows, buffer.forward)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet windows for notifier %s: %v", name, err)
+		}
+		buffer.Quiet = quiet
+	}
+	return buffer, nil
+}
+
+// start runs the notifier, and forwards the buffered events to it
+func (buffer *NotifierBuffer) start() {
+	notifierStopped := make(chan struct{})
+	go func() {
+		buffer.Notifier.Run()
+		close(notifierStopped)
+	}()
+	go func() {
+		buffer.Run()
+		<-notifierStopped
+		close(buffer.stopped)
+	}()
+}
+
+// Push adds an event to the buffer, applying the overflow policy if the buffer is full
+// The events pushed once the buffer has been stopped are dropped, whatever the policy.
+func (buffer *NotifierBuffer) Push(event Event) {
+	select {
+	case <-buffer.done:
+		buffer.drop(event, "has been stopped")
+		return
+	default:
+	}
+
+	switch buffer.Policy {
+	case OverflowDropNewest:
+		select {
+		case buffer.events <- event:
+		default:
+			buffer.drop(event, buffer.fullReason())
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case buffer.events <- event:
+				return
+			case <-buffer.done:
+				buffer.drop(event, "has been stopped")
+				return
+			default:
+			}
+			select {
+			case oldest := <-buffer.events:
+				buffer.drop(oldest, buffer.fullReason())
+			default:
+			}
+		}
+	default:
+		select {
+		case buffer.events <- event:
+		case <-buffer.done:
+			buffer.drop(event, "has been stopped")
+		}
+	}
+}
+
+func (buffer *NotifierBuffer) fullReason() string {
+	return fmt.Sprintf("is full (%d events)", cap(buffer.events))
+}
+
+func (buffer *NotifierBuffer) drop(event Event, reason string) {
+	dropped := atomic.AddUint64(&buffer.dropped, 1)
+	glog.Warningf("Buffer of notifier %s %s, dropping event for %s %s/%s (%d dropped so far)",
+		buffer.Name, reason, event.ObjectType(), event.Namespace(), event.Name(), dropped)
+}
+
+// Depth returns the number of events waiting in the buffer
+func (buffer *NotifierBuffer) Depth() int {
+	return len(buffer.events)
+}
+
+// Capacity returns the max number of events the buffer can hold
+func (buffer *NotifierBuffer) Capacity() int {
+	return cap(buffer.events)
+}
+
+// Dropped returns the number of events dropped because the buffer was full - or stopped
+func (buffer *NotifierBuffer) Dropped() uint64 {
+	return atomic.LoadUint64(&buffer.dropped)
+}
+
+// Stop stops forwarding the events: the buffered events - and the events held by the quiet windows
+// or the batcher - are sent to the notifier, and then its channel is closed.
+// It returns once the notifier has handled the last event.
+func (buffer *NotifierBuffer) Stop() {
+	buffer.stop(nil)
+}
+
+// stop stops the buffer, moving the events held by the quiet windows to the next buffer (if any)
+func (buffer *NotifierBuffer) stop(next *NotifierBuffer) {
+	buffer.stopOnce.Do(func() {
+		buffer.next = next
+		close(buffer.done)
+	})
+	<-buffer.stopped
+}
+
+// Run forwards the buffered events to the notifier, until the buffer is stopped
+func (buffer *NotifierBuffer) Run() {
+	for {
+		select {
+		case event := <-buffer.events:
+			buffer.handle(event)
+		case <-buffer.done:
+			buffer.drain()
+			return
+		}
+	}
+}
+
+// drain sends all the remaining events to the notifier, and closes its channel
+func (buffer *NotifierBuffer) drain() {
+	// only Run reads the events, so the length can't decrease behind our back
+	for len(buffer.events) > 0 {
+		buffer.handle(<-buffer.events)
+	}
+	if buffer.Quiet != nil {
+		if buffer.next != nil && buffer.next.Quiet != nil {
+			buffer.Quiet.HandOver(buffer.next.Quiet)
+		} else {
+			buffer.Quiet.ReleaseAll()
+		}
+	}
+	if buffer.Batcher != nil {
+		buffer.Batcher.Flush()
+	}
+
+	buffer.closing.Lock()
+	defer buffer.closing.Unlock()
+	buffer.closed = true
+	close(buffer.Notifier.Channel())
+	glog.V(1).Infof("Stopped forwarding the events to notifier %s", buffer.Name)
+}
+
+func (buffer *NotifierBuffer) handle(event Event) {
+	glog.V(4).Infof("Forwarding event to notifier %s (%d/%d events buffered)", buffer.Name, buffer.Depth(), buffer.Capacity())
+	if buffer.Quiet != nil {
+		buffer.Quiet.Handle(event)
+		return
+	}
+	buffer.forward(event)
+}
+
+// forward sends the event to the notifier - through the batcher, if any
+func (buffer *NotifierBuffer) forward(event Event) {
+	if buffer.Batcher != nil {
+		buffer.Batcher.Add(event)
+		return
+	}
+	buffer.send(event)
+}
+
+// send sends the event to the notifier - unless its channel has been closed,
+// which can happen when a timer of the quiet windows or the batcher fires after the buffer has been stopped
+func (buffer *NotifierBuffer) send(event Event) {
+	buffer.closing.Lock()
+	defer buffer.closing.Unlock()
+	if buffer.closed {
+		glog.Warningf("Notifier %s has been stopped, dropping event for %s %s/%s", buffer.Name, event.ObjectType(), event.Namespace(), event.Name())
+		return
+	}
+	buffer.Notifier.Channel() <- event
+}
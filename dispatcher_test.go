@@ -0,0 +1,162 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// channelNotifier records the events it receives, once it has been released
+type channelNotifier struct {
+	channel  chan Event
+	release  chan struct{}
+	mutex    sync.Mutex
+	received []string
+}
+
+func newChannelNotifier(released bool) *channelNotifier {
+	notifier := &channelNotifier{
+		channel: make(chan Event),
+		release: make(chan struct{}),
+	}
+	if released {
+		close(notifier.release)
+	}
+	return notifier
+}
+
+func (notifier *channelNotifier) Channel() chan Event {
+	return notifier.channel
+}
+
+func (notifier *channelNotifier) Run() {
+	<-notifier.release
+	for event := range notifier.channel {
+		notifier.mutex.Lock()
+		notifier.received = append(notifier.received, event.Name())
+		notifier.mutex.Unlock()
+	}
+}
+
+func (notifier *channelNotifier) Received() []string {
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+	return append([]string{}, notifier.received...)
+}
+
+func TestNotifierBufferPush(t *testing.T) {
+	tests := []struct {
+		policy OverflowPolicy
+		pushed []string
+		// stopAfter is the number of events pushed before the buffer is stopped - or -1
+		stopAfter       int
+		expectedEvents  []string
+		expectedDropped uint64
+	}{
+		{OverflowDropNewest, []string{"a", "b"}, -1, []string{"a", "b"}, 0},
+		{OverflowDropNewest, []string{"a", "b", "c", "d"}, -1, []string{"a", "b"}, 2},
+		{OverflowDropOldest, []string{"a", "b", "c", "d"}, -1, []string{"c", "d"}, 2},
+		{OverflowBlock, []string{"a", "b", "c"}, 2, []string{"a", "b"}, 1},
+		// once stopped, the events are dropped even if there is room in the buffer
+		{OverflowDropNewest, []string{"a", "b"}, 1, []string{"a"}, 1},
+		{OverflowDropOldest, []string{"a", "b", "c"}, 2, []string{"a", "b"}, 1},
+		{OverflowBlock, []string{"a", "b"}, 1, []string{"a"}, 1},
+	}
+
+	for count, test := range tests {
+		// the buffer is not started, so that the events stay in it
+		buffer, err := newNotifierBuffer("test", newChannelNotifier(true), NotifierConfig{BufferSize: 2, OverflowPolicy: string(test.policy)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range test.pushed {
+			if i == test.stopAfter {
+				close(buffer.done)
+			}
+			buffer.Push(&testEvent{namespace: "ns", name: name})
+		}
+
+		events := []string{}
+		for len(buffer.events) > 0 {
+			events = append(events, (<-buffer.events).Name())
+		}
+		if !reflect.DeepEqual(events, test.expectedEvents) {
+			t.Errorf("Test[%d] Failed: Expected the buffered events '%v' but got '%v'", count, test.expectedEvents, events)
+		}
+		if buffer.Dropped() != test.expectedDropped {
+			t.Errorf("Test[%d] Failed: Expected '%v' dropped events but got '%v'", count, test.expectedDropped, buffer.Dropped())
+		}
+	}
+}
+
+func TestDispatcherSlowNotifier(t *testing.T) {
+	slow := newChannelNotifier(false)
+	fast := newChannelNotifier(true)
+	dispatcher := NewDispatcher()
+	if err := dispatcher.AddNotifier("slow", slow, NotifierConfig{BufferSize: 1, OverflowPolicy: string(OverflowDropNewest)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.AddNotifier("fast", fast, NotifierConfig{BufferSize: 1, OverflowPolicy: string(OverflowBlock)}); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatched := make(chan struct{})
+	go func() {
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			dispatcher.Dispatch([]string{"slow", "fast"}, &testEvent{namespace: "ns", name: name})
+		}
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the slow notifier not to stall the dispatch")
+	}
+
+	dispatcher.RemoveNotifier("fast")
+	if received := fast.Received(); !reflect.DeepEqual(received, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("Expected the fast notifier to receive all the events but got '%v'", received)
+	}
+
+	// the slow notifier holds 1 event, and its buffer another one
+	slowBuffer := dispatcher.Buffers()["slow"]
+	if slowBuffer.Dropped() < 3 {
+		t.Errorf("Expected at least 3 events dropped for the slow notifier but got %d", slowBuffer.Dropped())
+	}
+	close(slow.release)
+	dispatcher.RemoveNotifier("slow")
+	if received := slow.Received(); uint64(len(received))+slowBuffer.Dropped() != 5 {
+		t.Errorf("Expected the events of the slow notifier to be either received or dropped but got '%v' and %d dropped", received, slowBuffer.Dropped())
+	}
+}
+
+func TestNotifierBufferSummaryEvents(t *testing.T) {
+	now := time.Now()
+	notifier := newChannelNotifier(true)
+	buffer, err := newNotifierBuffer("test", notifier, NotifierConfig{
+		BufferSize:     10,
+		OverflowPolicy: string(OverflowBlock),
+		BatchWindow:    "1h",
+		QuietWindows: []QuietWindowConfig{
+			{Start: now.Add(-time.Hour).Format(time.RFC3339), End: now.Add(time.Hour).Format(time.RFC3339), Mode: QuietModeDrop},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.start()
+
+	report := &BuildsReport{namespace: "ns"}
+	digest := NewDigestEvent([]Event{&testEvent{namespace: "ns", name: "a"}, &testEvent{namespace: "ns", name: "b"}}, now, now)
+	// the build is dropped by the quiet window, but the report and the digest skip both the quiet window and the batching
+	buffer.Push(&testEvent{namespace: "ns", name: "build", status: "Complete", success: true})
+	buffer.Push(report)
+	buffer.Push(digest)
+	buffer.Stop()
+
+	expected := []string{report.Name(), digest.Name()}
+	if received := notifier.Received(); !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected the report and the digest to be sent as-is '%v' but got '%v'", expected, received)
+	}
+}
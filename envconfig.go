@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	"github.com/golang/glog"
+)
+
+const (
+	NotifiersEnvVarPrefix      = "NOTIFIERS_"
+	BuildsWatchersEnvVarPrefix = "BUILDS_WATCHERS_"
+)
+
+// notifierEnvVarOptions are the options of a notifier that can be set with a NOTIFIERS_<NAME>_<OPTION> environment variable
+var notifierEnvVarOptions = []string{
+	"TYPE", "TOKEN", "TOKEN_SECRET_NAME", "TOKEN_SECRET_KEY", "TOKEN_SECRET_NAMESPACE",
+	"SOURCE", "FROM_NAME", "FROM_ADDRESS", "TAGS", "SUBJECT_TEMPLATE", "CONTENT_TEMPLATE",
+	"BATCH_WINDOW", "BATCH_BYPASS_FAILURES", "BUFFER_SIZE", "OVERFLOW_POLICY", "DRY_RUN",
+	"QUEUE_DIR", "DEAD_LETTER_PATH", "RETRY_INITIAL_BACKOFF", "RETRY_MAX_BACKOFF", "RETRY_MAX_AGE",
+	"OUTPUT", "MAX_FILE_SIZE_MB", "MAX_BACKUPS",
+}
+
+// buildsWatcherEnvVarOptions are the options of a builds watcher that can be set with a BUILDS_WATCHERS_<NAME>_<OPTION> environment variable
+var buildsWatcherEnvVarOptions = []string{
+	"NAMESPACE", "ALL_NAMESPACES", "NOTIFIERS", "PHASES", "LABEL_SELECTOR", "FIELD_SELECTOR",
+	"NOTIFY_MODE", "STATE_PATH", "BATCH_WINDOW", "ANNOTATIONS",
+}
+
+// setNotifiersFromEnvVar creates or completes the notifiers with the NOTIFIERS_<NAME>_<OPTION> environment variables
+func (appConfig *AppConfig) setNotifiersFromEnvVar(environ []string) error {
+	for _, envVar := range parseEnvVars(environ, NotifiersEnvVarPrefix, notifierEnvVarOptions) {
+		name := envVarConfigName(envVar.name, sortedKeys(appConfig.Notifiers))
+		if _, found := appConfig.Notifiers[name]; !found {
+			appConfig.Notifiers[name] = &NotifierConfig{}
+		}
+		if err := appConfig.Notifiers[name].setOption(envVar.option, envVar.value); err != nil {
+			return fmt.Errorf("invalid %s: %v", envVar.key, err)
+		}
+	}
+	return nil
+}
+
+// setBuildsWatchersFromEnvVar creates or completes the watchers with the BUILDS_WATCHERS_<NAME>_<OPTION> environment variables
+func (appConfig *AppConfig) setBuildsWatchersFromEnvVar(environ []string) error {
+	for _, envVar := range parseEnvVars(environ, BuildsWatchersEnvVarPrefix, buildsWatcherEnvVarOptions) {
+		name := envVarConfigName(envVar.name, sortedKeys(appConfig.BuildsWatchers))
+		if _, found := appConfig.BuildsWatchers[name]; !found {
+			appConfig.BuildsWatchers[name] = &BuildsWatcherConfig{}
+		}
+		if err := appConfig.BuildsWatchers[name].setOption(envVar.option, envVar.value); err != nil {
+			return fmt.Errorf("invalid %s: %v", envVar.key, err)
+		}
+	}
+	return nil
+}
+
+// setOption sets the option of a NOTIFIERS_<NAME>_<OPTION> environment variable
+func (notifierConfig *NotifierConfig) setOption(option string, value string) error {
+	switch option {
+	case "TYPE":
+		notifierConfig.Type = value
+	case "TOKEN":
+		notifierConfig.Token = value
+	case "TOKEN_SECRET_NAME":
+		notifierConfig.TokenSecret.Name = value
+	case "TOKEN_SECRET_KEY":
+		notifierConfig.TokenSecret.Key = value
+	case "TOKEN_SECRET_NAMESPACE":
+		notifierConfig.TokenSecret.Namespace = value
+	case "SOURCE":
+		notifierConfig.Source = value
+	case "FROM_NAME":
+		notifierConfig.FromName = value
+	case "FROM_ADDRESS":
+		notifierConfig.FromAddress = value
+	case "TAGS":
+		notifierConfig.Tags = splitEnvVarList(value)
+	case "SUBJECT_TEMPLATE":
+		notifierConfig.SubjectTemplate = value
+	case "CONTENT_TEMPLATE":
+		notifierConfig.ContentTemplate = value
+	case "BATCH_WINDOW":
+		notifierConfig.BatchWindow = value
+	case "BATCH_BYPASS_FAILURES":
+		return parseEnvVarBool(value, &notifierConfig.BatchBypassFailures)
+	case "BUFFER_SIZE":
+		return parseEnvVarInt(value, &notifierConfig.BufferSize)
+	case "OVERFLOW_POLICY":
+		notifierConfig.OverflowPolicy = value
+	case "DRY_RUN":
+		return parseEnvVarBool(value, &notifierConfig.DryRun)
+	case "QUEUE_DIR":
+		notifierConfig.QueueDir = value
+	case "DEAD_LETTER_PATH":
+		notifierConfig.DeadLetterPath = value
+	case "RETRY_INITIAL_BACKOFF":
+		notifierConfig.RetryInitialBackoff = value
+	case "RETRY_MAX_BACKOFF":
+		notifierConfig.RetryMaxBackoff = value
+	case "RETRY_MAX_AGE":
+		notifierConfig.RetryMaxAge = value
+	case "OUTPUT":
+		notifierConfig.Output = value
+	case "MAX_FILE_SIZE_MB":
+		return parseEnvVarInt(value, &notifierConfig.MaxFileSizeMB)
+	case "MAX_BACKUPS":
+		return parseEnvVarInt(value, &notifierConfig.MaxBackups)
+	default:
+		return fmt.Errorf("unknown option %s", option)
+	}
+	return nil
+}
+
+// setOption sets the option of a BUILDS_WATCHERS_<NAME>_<OPTION> environment variable
+func (watcherConfig *BuildsWatcherConfig) setOption(option string, value string) error {
+	switch option {
+	case "NAMESPACE":
+		watcherConfig.Namespace = value
+	case "ALL_NAMESPACES":
+		return parseEnvVarBool(value, &watcherConfig.AllNamespaces)
+	case "NOTIFIERS":
+		watcherConfig.Notifiers = splitEnvVarList(value)
+	case "PHASES":
+		// only the listed phases are watched
+		watcherConfig.WatchForBuildPhase = make(map[buildapi.BuildPhase]bool)
+		for _, phase := range buildPhases {
+			watcherConfig.WatchForBuildPhase[buildapi.BuildPhase(phase)] = false
+		}
+		for _, phase := range splitEnvVarList(value) {
+			watcherConfig.WatchForBuildPhase[buildapi.BuildPhase(phase)] = true
+		}
+	case "LABEL_SELECTOR":
+		watcherConfig.LabelSelector = value
+	case "FIELD_SELECTOR":
+		watcherConfig.FieldSelector = value
+	case "NOTIFY_MODE":
+		watcherConfig.NotifyMode = value
+	case "STATE_PATH":
+		watcherConfig.StatePath = value
+	case "BATCH_WINDOW":
+		watcherConfig.BatchWindow = value
+	case "ANNOTATIONS":
+		return parseEnvVarBool(value, &watcherConfig.Annotations)
+	default:
+		return fmt.Errorf("unknown option %s", option)
+	}
+	return nil
+}
+
+// envVar is an environment variable such as NOTIFIERS_<NAME>_<OPTION>
+type envVar struct {
+	key    string
+	name   string
+	option string
+	value  string
+}
+
+// parseEnvVars returns the environment variables with the given prefix, and ending with one of the given options
+// The longest option wins, so that NOTIFIERS_OPS_TOKEN_SECRET_NAME is the TOKEN_SECRET_NAME of the OPS notifier.
+func parseEnvVars(environ []string, prefix string, options []string) []envVar {
+	options = append([]string{}, options...)
+	sort.Sort(sort.Reverse(byLength(options)))
+
+	envVars := []envVar{}
+	for _, entry := range sortStrings(environ) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+			continue
+		}
+		key, value := parts[0], parts[1]
+		rest := strings.TrimPrefix(key, prefix)
+		found := false
+		for _, option := range options {
+			if strings.HasSuffix(rest, "_"+option) && len(rest) > len(option)+1 {
+				envVars = append(envVars, envVar{
+					key:    key,
+					name:   strings.TrimSuffix(rest, "_"+option),
+					option: option,
+					value:  value,
+				})
+				found = true
+				break
+			}
+		}
+		if !found {
+			glog.Warningf("Ignoring the environment variable %s: unknown option", key)
+		}
+	}
+	return envVars
+}
+
+// envVarConfigName returns the name of the watcher or notifier for the given environment variable name:
+// the existing one whose name matches - such as "team-ops" for TEAM_OPS - or the name in lower case, with dashes.
+func envVarConfigName(name string, existingNames []string) string {
+	for _, existingName := range existingNames {
+		if envVarName(existingName) == name {
+			return existingName
+		}
+	}
+	return strings.Replace(strings.ToLower(name), "_", "-", -1)
+}
+
+// envVarName returns the name as it appears in an environment variable: in upper case, with underscores
+func envVarName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// splitEnvVarList splits a comma-separated list
+func splitEnvVarList(value string) []string {
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func parseEnvVarBool(value string, target *bool) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+func parseEnvVarInt(value string, target *int) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLength) Less(i, j int) bool { return len(s[i]) < len(s[j]) }
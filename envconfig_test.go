@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+)
+
+func TestSetFromEnvVars(t *testing.T) {
+	appConfig := &AppConfig{
+		BuildsWatchers: map[string]*BuildsWatcherConfig{
+			"team-payments": {Namespace: "payments"},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"default":  {},
+			"team-ops": {FromName: "Ops"},
+		},
+	}
+	environ := []string{
+		"NOTIFIERS_DEFAULT_TOKEN=default-token",
+		"NOTIFIERS_TEAM_OPS_TOKEN_SECRET_NAME=ops-flowdock",
+		"NOTIFIERS_TEAM_OPS_TAGS=#ops, {{.Namespace}}",
+		"NOTIFIERS_AUDIT_LOG_TYPE=json",
+		"NOTIFIERS_AUDIT_LOG_MAX_BACKUPS=3",
+		"NOTIFIERS_DEFAULT_DRY_RUN=true",
+		"NOTIFIERS_SERVICE_HOST=172.30.0.1",
+		"BUILDS_WATCHERS_TEAM_PAYMENTS_NOTIFIERS=team-ops,audit-log",
+		"BUILDS_WATCHERS_FRONTEND_NAMESPACE=frontend",
+		"BUILDS_WATCHERS_FRONTEND_PHASES=Failed,Error",
+		"PATH=/usr/bin",
+	}
+
+	if err := appConfig.setNotifiersFromEnvVar(environ); err != nil {
+		t.Fatalf("Failed to set the notifiers: %v", err)
+	}
+	if err := appConfig.setBuildsWatchersFromEnvVar(environ); err != nil {
+		t.Fatalf("Failed to set the watchers: %v", err)
+	}
+
+	expectedNotifiers := map[string]*NotifierConfig{
+		"default":   {Token: "default-token", DryRun: true},
+		"team-ops":  {FromName: "Ops", TokenSecret: SecretKeyRef{Name: "ops-flowdock"}, Tags: []string{"#ops", "{{.Namespace}}"}},
+		"audit-log": {Type: "json", MaxBackups: 3},
+	}
+	if !reflect.DeepEqual(appConfig.Notifiers, expectedNotifiers) {
+		t.Errorf("Expected notifiers '%+v' but got '%+v'", expectedNotifiers, appConfig.Notifiers)
+	}
+
+	expectedWatchers := map[string]*BuildsWatcherConfig{
+		"team-payments": {Namespace: "payments", Notifiers: []string{"team-ops", "audit-log"}},
+		"frontend": {
+			Namespace: "frontend",
+			WatchForBuildPhase: map[buildapi.BuildPhase]bool{
+				buildapi.BuildPhaseNew:       false,
+				buildapi.BuildPhasePending:   false,
+				buildapi.BuildPhaseRunning:   false,
+				buildapi.BuildPhaseComplete:  false,
+				buildapi.BuildPhaseFailed:    true,
+				buildapi.BuildPhaseError:     true,
+				buildapi.BuildPhaseCancelled: false,
+			},
+		},
+	}
+	if !reflect.DeepEqual(appConfig.BuildsWatchers, expectedWatchers) {
+		t.Errorf("Expected watchers '%+v' but got '%+v'", expectedWatchers, appConfig.BuildsWatchers)
+	}
+
+	if err := appConfig.setNotifiersFromEnvVar([]string{"NOTIFIERS_DEFAULT_BUFFER_SIZE=large"}); err == nil {
+		t.Errorf("Expected an error for an invalid buffer size")
+	}
+}
@@ -27,6 +27,7 @@ type Event interface {
 	Status() string
 	IsSuccess() bool
 	IsFailure() bool
+	Transition() string
 	Logs() string
 	Events() []string
 	NodeName() string
@@ -38,6 +39,8 @@ type BuildEvent struct {
 	Build              *buildapi.Build
 	factory            clientcmd.Factory
 	openshiftPublicUrl string
+	// transition is the result of the build compared to the previous build of the same BuildConfig
+	transition string
 }
 
 func NewBuildEvent(factory clientcmd.Factory, event watch.Event) *BuildEvent {
@@ -49,6 +52,42 @@ func NewBuildEvent(factory clientcmd.Factory, event watch.Event) *BuildEvent {
 	}
 }
 
+// offlineBuildEvent is a BuildEvent which doesn't call the API:
+// it is used to render the templates without a cluster, for a build read from a file, a sample build or a replayed event
+type offlineBuildEvent struct {
+	*BuildEvent
+}
+
+// NewOfflineBuildEvent returns the event of the given build, with placeholders for what would need the API
+// (the node name, the logs and the events)
+func NewOfflineBuildEvent(build *buildapi.Build, transition string) Event {
+	event := newOfflineBuildEvent(watch.Event{Type: watch.Modified, Object: build})
+	event.transition = transition
+	return event
+}
+
+func newOfflineBuildEvent(event watch.Event) *offlineBuildEvent {
+	return &offlineBuildEvent{
+		BuildEvent: &BuildEvent{
+			Event:              event,
+			Build:              event.Object.(*buildapi.Build),
+			openshiftPublicUrl: "https://openshift.example.com",
+		},
+	}
+}
+
+func (event *offlineBuildEvent) NodeName() string {
+	return "node-1.example.com"
+}
+
+func (event *offlineBuildEvent) Logs() string {
+	return "(the logs of the build)"
+}
+
+func (event *offlineBuildEvent) Events() []string {
+	return []string{"(the events of the build)"}
+}
+
 func (event *BuildEvent) Namespace() string {
 	return event.Build.Namespace
 }
@@ -115,6 +154,20 @@ func (event *BuildEvent) IsFailure() bool {
 	}
 }
 
+// Transition returns "broken", "still-failing", "fixed", "success" or "failure"
+// for a finished build, or an empty string
+func (event *BuildEvent) Transition() string {
+	return event.transition
+}
+
+func (event *BuildEvent) Labels() map[string]string {
+	return event.Build.Labels
+}
+
+func (event *BuildEvent) Annotations() map[string]string {
+	return event.Build.Annotations
+}
+
 func (event *BuildEvent) NodeName() string {
 	_, kclient, err := event.factory.Clients()
 	if err != nil {
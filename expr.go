@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Expression is a boolean expression over the fields of an Event, such as
+//
+//	namespace matches "prod-.*" && isFailure && labels.team == "payments"
+//
+// It supports the "==", "!=" and "matches" (a regex, which must match the whole value) operators,
+// "&&", "||", "!" and parentheses, string literals, true and false.
+// The fields are namespace, name, objectType, status, transition, input and output (strings),
+// isSuccess and isFailure (booleans), and labels.KEY and annotations.KEY for the labels and annotations
+// of the object (empty strings if they are not defined).
+type Expression struct {
+	Source string
+	root   exprNode
+}
+
+// LabeledEvent is implemented by the events which have access to the labels and annotations of their object
+type LabeledEvent interface {
+	Labels() map[string]string
+	Annotations() map[string]string
+}
+
+func ParseExpression(source string) (*Expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	parser := &exprParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err == nil && parser.pos < len(parser.tokens) {
+		err = fmt.Errorf("unexpected %q", parser.tokens[parser.pos].value)
+	}
+	if err == nil && root.kind() != exprBool {
+		err = fmt.Errorf("the expression is not a boolean")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", source, err)
+	}
+	return &Expression{
+		Source: source,
+		root:   root,
+	}, nil
+}
+
+// Match evaluates the expression against the given event
+func (expr *Expression) Match(event Event) bool {
+	return expr.root.eval(event).(bool)
+}
+
+type exprKind int
+
+const (
+	exprString exprKind = iota
+	exprBool
+)
+
+type exprNode interface {
+	kind() exprKind
+	eval(event Event) interface{}
+}
+
+type exprLiteral struct {
+	value interface{}
+}
+
+func (node *exprLiteral) kind() exprKind {
+	if _, isBool := node.value.(bool); isBool {
+		return exprBool
+	}
+	return exprString
+}
+
+func (node *exprLiteral) eval(event Event) interface{} {
+	return node.value
+}
+
+type exprField struct {
+	name string
+}
+
+var exprStringFields = map[string]func(Event) string{
+	"namespace":  Event.Namespace,
+	"name":       Event.Name,
+	"objectType": Event.ObjectType,
+	"status":     Event.Status,
+	"transition": Event.Transition,
+	"input":      Event.Input,
+	"output":     Event.Output,
+}
+
+var exprBoolFields = map[string]func(Event) bool{
+	"isSuccess": Event.IsSuccess,
+	"isFailure": Event.IsFailure,
+}
+
+func (node *exprField) kind() exprKind {
+	if _, isBool := exprBoolFields[node.name]; isBool {
+		return exprBool
+	}
+	return exprString
+}
+
+func (node *exprField) eval(event Event) interface{} {
+	if field, found := exprBoolFields[node.name]; found {
+		return field(event)
+	}
+	if field, found := exprStringFields[node.name]; found {
+		return field(event)
+	}
+	labeled, isLabeled := event.(LabeledEvent)
+	if !isLabeled {
+		return ""
+	}
+	if strings.HasPrefix(node.name, "labels.") {
+		return labeled.Labels()[strings.TrimPrefix(node.name, "labels.")]
+	}
+	return labeled.Annotations()[strings.TrimPrefix(node.name, "annotations.")]
+}
+
+type exprNot struct {
+	operand exprNode
+}
+
+func (node *exprNot) kind() exprKind {
+	return exprBool
+}
+
+func (node *exprNot) eval(event Event) interface{} {
+	return !node.operand.eval(event).(bool)
+}
+
+type exprBinary struct {
+	operator    string
+	left, right exprNode
+	regexp      *regexp.Regexp
+}
+
+func (node *exprBinary) kind() exprKind {
+	return exprBool
+}
+
+func (node *exprBinary) eval(event Event) interface{} {
+	switch node.operator {
+	case "&&":
+		return node.left.eval(event).(bool) && node.right.eval(event).(bool)
+	case "||":
+		return node.left.eval(event).(bool) || node.right.eval(event).(bool)
+	case "==":
+		return node.left.eval(event) == node.right.eval(event)
+	case "!=":
+		return node.left.eval(event) != node.right.eval(event)
+	case "matches":
+		return node.regexp.MatchString(node.left.eval(event).(string))
+	}
+	return false
+}
+
+type exprToken struct {
+	// literal is true for the string literals
+	literal bool
+	value   string
+}
+
+func tokenizeExpression(source string) ([]exprToken, error) {
+	tokens := []exprToken{}
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, exprToken{value: string(r)})
+			i++
+		case r == '!' && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, exprToken{value: "!="})
+			i += 2
+		case r == '!':
+			tokens = append(tokens, exprToken{value: "!"})
+			i++
+		case r == '=' || r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, fmt.Errorf("unexpected %q at position %d", string(r), i)
+			}
+			tokens = append(tokens, exprToken{value: string([]rune{r, r})})
+			i += 2
+		case r == '"':
+			value := []rune{}
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value = append(value, runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, exprToken{literal: true, value: string(value)})
+			i++
+		case isExprIdentifierRune(r):
+			start := i
+			for ; i < len(runes) && isExprIdentifierRune(runes[i]); i++ {
+			}
+			tokens = append(tokens, exprToken{value: string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected %q at position %d", string(r), i)
+		}
+	}
+	return tokens, nil
+}
+
+// isExprIdentifierRune returns true for the runes allowed in the identifiers
+// which include the runes allowed in the label keys, such as "labels.example.com/team"
+func isExprIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '/' || r == '-'
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (parser *exprParser) peek() (exprToken, bool) {
+	if parser.pos >= len(parser.tokens) {
+		return exprToken{}, false
+	}
+	return parser.tokens[parser.pos], true
+}
+
+func (parser *exprParser) accept(operator string) bool {
+	if token, found := parser.peek(); found && !token.literal && token.value == operator {
+		parser.pos++
+		return true
+	}
+	return false
+}
+
+func (parser *exprParser) parseOr() (exprNode, error) {
+	return parser.parseBinary("||", parser.parseAnd)
+}
+
+func (parser *exprParser) parseAnd() (exprNode, error) {
+	return parser.parseBinary("&&", parser.parseUnary)
+}
+
+func (parser *exprParser) parseBinary(operator string, parseOperand func() (exprNode, error)) (exprNode, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for parser.accept(operator) {
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind() != exprBool || right.kind() != exprBool {
+			return nil, fmt.Errorf("the operands of %s must be booleans", operator)
+		}
+		left = &exprBinary{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (parser *exprParser) parseUnary() (exprNode, error) {
+	if parser.accept("!") {
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.kind() != exprBool {
+			return nil, fmt.Errorf("the operand of ! must be a boolean")
+		}
+		return &exprNot{operand: operand}, nil
+	}
+	return parser.parseComparison()
+}
+
+func (parser *exprParser) parseComparison() (exprNode, error) {
+	left, err := parser.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, operator := range []string{"==", "!=", "matches"} {
+		if !parser.accept(operator) {
+			continue
+		}
+		right, err := parser.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		node := &exprBinary{operator: operator, left: left, right: right}
+		if operator == "matches" {
+			literal, isLiteral := right.(*exprLiteral)
+			if !isLiteral || left.kind() != exprString || right.kind() != exprString {
+				return nil, fmt.Errorf("matches needs a string on the left, and a string literal regex on the right")
+			}
+			node.regexp, err = regexp.Compile("^(?:" + literal.value.(string) + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %s: %v", literal.value, err)
+			}
+		} else if left.kind() != right.kind() {
+			return nil, fmt.Errorf("can't compare a string with a boolean")
+		}
+		return node, nil
+	}
+	return left, nil
+}
+
+func (parser *exprParser) parseOperand() (exprNode, error) {
+	token, found := parser.peek()
+	if !found {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	parser.pos++
+
+	switch {
+	case token.literal:
+		return &exprLiteral{value: token.value}, nil
+	case token.value == "(":
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !parser.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return node, nil
+	case token.value == "true" || token.value == "false":
+		return &exprLiteral{value: token.value == "true"}, nil
+	case strings.HasPrefix(token.value, "labels.") || strings.HasPrefix(token.value, "annotations."):
+		return &exprField{name: token.value}, nil
+	}
+	if _, found := exprStringFields[token.value]; found {
+		return &exprField{name: token.value}, nil
+	}
+	if _, found := exprBoolFields[token.value]; found {
+		return &exprField{name: token.value}, nil
+	}
+	return nil, fmt.Errorf("unknown field %q", token.value)
+}
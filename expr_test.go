@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// labeledTestEvent is a testEvent with labels and annotations
+type labeledTestEvent struct {
+	testEvent
+	labels      map[string]string
+	annotations map[string]string
+}
+
+func (e *labeledTestEvent) Labels() map[string]string      { return e.labels }
+func (e *labeledTestEvent) Annotations() map[string]string { return e.annotations }
+
+func TestExpressionMatch(t *testing.T) {
+	event := &labeledTestEvent{
+		testEvent: testEvent{namespace: "prod-payments", name: "api-3", status: "Failed", failure: true},
+		labels: map[string]string{
+			"team":                "payments",
+			"example.com/on-call": "alice",
+		},
+		annotations: map[string]string{
+			"openshift.io/build.number": "3",
+		},
+	}
+
+	tests := []struct {
+		expression     string
+		expectedResult bool
+	}{
+		{expression: `isFailure`, expectedResult: true},
+		{expression: `!isFailure`, expectedResult: false},
+		{expression: `namespace matches "prod-.*" && isFailure && labels.team == "payments"`, expectedResult: true},
+		{expression: `namespace matches "prod-.*" && isFailure && labels.team == "billing"`, expectedResult: false},
+		// regexes should match the whole value
+		{expression: `namespace matches "prod"`, expectedResult: false},
+		{expression: `labels.team == "billing" || (isFailure && status != "Error")`, expectedResult: true},
+		{expression: `labels.example.com/on-call == "alice"`, expectedResult: true},
+		{expression: `annotations.openshift.io/build.number == "3"`, expectedResult: true},
+		// undefined labels are empty strings
+		{expression: `labels.undefined == ""`, expectedResult: true},
+		{expression: `isSuccess == false`, expectedResult: true},
+		{expression: `name == "api-\"3"`, expectedResult: false},
+	}
+
+	for count, test := range tests {
+		expr, err := ParseExpression(test.expression)
+		if err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		result := expr.Match(event)
+		if result != test.expectedResult {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedResult, result)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	expressions := []string{
+		``,
+		`namespace`,
+		`unknown == "a"`,
+		`isFailure &&`,
+		`isFailure & isSuccess`,
+		`(isFailure`,
+		`isFailure)`,
+		`name == "unterminated`,
+		`name matches "("`,
+		`name matches namespace`,
+		`isFailure == "true"`,
+		`!name`,
+		`name && isFailure`,
+	}
+
+	for count, expression := range expressions {
+		if _, err := ParseExpression(expression); err == nil {
+			t.Errorf("Test[%d] Failed: Expected an error for expression %s", count, expression)
+		}
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	router, err := NewRouter(RoutingConfig{
+		Rules: []RoutingRuleConfig{
+			{Name: "payments", Expression: `labels.team == "payments"`, Notifiers: []string{"payments"}},
+			{Name: "prod-failures", Expression: `namespace matches "prod-.*" && isFailure`, Notifiers: []string{"ops", "payments"}},
+		},
+		DefaultNotifiers: []string{"default"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the router: %v", err)
+	}
+
+	tests := []struct {
+		event             Event
+		expectedNotifiers []string
+	}{
+		{
+			event:             &labeledTestEvent{testEvent: testEvent{namespace: "prod-a", failure: true}, labels: map[string]string{"team": "payments"}},
+			expectedNotifiers: []string{"payments", "ops"},
+		},
+		{
+			event:             &labeledTestEvent{testEvent: testEvent{namespace: "prod-a", success: true}},
+			expectedNotifiers: []string{"default"},
+		},
+		// events without labels can still be routed on their other fields
+		{
+			event:             &testEvent{namespace: "prod-a", failure: true},
+			expectedNotifiers: []string{"ops", "payments"},
+		},
+	}
+
+	for count, test := range tests {
+		result := router.Route(test.event)
+		if !reflect.DeepEqual(result, test.expectedNotifiers) {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedNotifiers, result)
+		}
+	}
+
+	if notifiers := router.Notifiers(); !reflect.DeepEqual(notifiers, []string{"default", "payments", "ops"}) {
+		t.Errorf("Unexpected notifiers %v", notifiers)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	buildutil "github.com/openshift/origin/pkg/build/util"
+
+	"k8s.io/kubernetes/pkg/fields"
+)
+
+// BuildFilter filters the builds of a watcher, on top of the label selector which is passed to the watch.
+// The field selector is evaluated client-side, on the same fields as the API server
+// ("metadata.name", "metadata.namespace", "status" and "podName"), the BuildConfigs are matched
+// with glob patterns, and the namespaces with regexes - mostly useful when watching all the namespaces.
+// The exclusions win over the inclusions, and empty inclusions include everything.
+type BuildFilter struct {
+	FieldSelector       fields.Selector
+	IncludeBuildConfigs []string
+	ExcludeBuildConfigs []string
+	IncludeNamespaces   []*regexp.Regexp
+	ExcludeNamespaces   []*regexp.Regexp
+}
+
+func NewBuildFilter(config BuildsWatcherConfig) (*BuildFilter, error) {
+	filter := &BuildFilter{
+		FieldSelector:       fields.Everything(),
+		IncludeBuildConfigs: config.IncludeBuildConfigs,
+		ExcludeBuildConfigs: config.ExcludeBuildConfigs,
+	}
+
+	if len(config.FieldSelector) > 0 {
+		selector, err := fields.ParseSelector(config.FieldSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fieldSelector %s: %v", config.FieldSelector, err)
+		}
+		filter.FieldSelector = selector
+	}
+
+	for _, patterns := range [][]string{config.IncludeBuildConfigs, config.ExcludeBuildConfigs} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid BuildConfig pattern %s: %v", pattern, err)
+			}
+		}
+	}
+
+	var err error
+	if filter.IncludeNamespaces, err = compileAnchoredRegexps(config.IncludeNamespaces); err != nil {
+		return nil, err
+	}
+	if filter.ExcludeNamespaces, err = compileAnchoredRegexps(config.ExcludeNamespaces); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// Accept returns true if the given build matches the filter
+func (filter *BuildFilter) Accept(build *buildapi.Build) bool {
+	if !filter.FieldSelector.Matches(buildapi.BuildToSelectableFields(build)) {
+		return false
+	}
+
+	if len(filter.IncludeNamespaces) > 0 && !matchesAnyRegexp(filter.IncludeNamespaces, build.Namespace) {
+		return false
+	}
+	if matchesAnyRegexp(filter.ExcludeNamespaces, build.Namespace) {
+		return false
+	}
+
+	configName := buildutil.ConfigNameForBuild(build)
+	if len(filter.IncludeBuildConfigs) > 0 && !matchesAnyGlob(filter.IncludeBuildConfigs, configName) {
+		return false
+	}
+	if matchesAnyGlob(filter.ExcludeBuildConfigs, configName) {
+		return false
+	}
+
+	return true
+}
+
+// compileAnchoredRegexps compiles the given regexes, so that they match the whole string
+func compileAnchoredRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	regexps := []*regexp.Regexp{}
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace regex %s: %v", expr, err)
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+func matchesAnyRegexp(regexps []*regexp.Regexp, value string) bool {
+	for _, re := range regexps {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+)
+
+func TestBuildFilterAccept(t *testing.T) {
+	build := func(namespace string, config string, phase buildapi.BuildPhase) *buildapi.Build {
+		return &buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace: namespace,
+				Name:      config + "-1",
+				Labels: map[string]string{
+					buildapi.BuildConfigLabel: config,
+				},
+			},
+			Status: buildapi.BuildStatus{
+				Phase: phase,
+			},
+		}
+	}
+
+	tests := []struct {
+		config         BuildsWatcherConfig
+		build          *buildapi.Build
+		expectedResult bool
+	}{
+		// should accept everything by default
+		{
+			config:         BuildsWatcherConfig{},
+			build:          build("team-a", "app", buildapi.BuildPhaseComplete),
+			expectedResult: true,
+		},
+		{
+			config:         BuildsWatcherConfig{FieldSelector: "status!=Cancelled"},
+			build:          build("team-a", "app", buildapi.BuildPhaseCancelled),
+			expectedResult: false,
+		},
+		{
+			config:         BuildsWatcherConfig{FieldSelector: "metadata.namespace=team-a"},
+			build:          build("team-a", "app", buildapi.BuildPhaseComplete),
+			expectedResult: true,
+		},
+		{
+			config:         BuildsWatcherConfig{IncludeBuildConfigs: []string{"api-*"}},
+			build:          build("team-a", "app", buildapi.BuildPhaseComplete),
+			expectedResult: false,
+		},
+		{
+			config:         BuildsWatcherConfig{IncludeBuildConfigs: []string{"api-*", "app"}},
+			build:          build("team-a", "app", buildapi.BuildPhaseComplete),
+			expectedResult: true,
+		},
+		// exclusions should win over inclusions
+		{
+			config:         BuildsWatcherConfig{IncludeBuildConfigs: []string{"*"}, ExcludeBuildConfigs: []string{"*-test"}},
+			build:          build("team-a", "app-test", buildapi.BuildPhaseComplete),
+			expectedResult: false,
+		},
+		// namespace regexes should match the whole namespace
+		{
+			config:         BuildsWatcherConfig{IncludeNamespaces: []string{"team"}},
+			build:          build("team-a", "app", buildapi.BuildPhaseComplete),
+			expectedResult: false,
+		},
+		{
+			config:         BuildsWatcherConfig{IncludeNamespaces: []string{"team-.*"}},
+			build:          build("team-a", "app", buildapi.BuildPhaseComplete),
+			expectedResult: true,
+		},
+		{
+			config:         BuildsWatcherConfig{IncludeNamespaces: []string{"team-.*"}, ExcludeNamespaces: []string{".*-a"}},
+			build:          build("team-a", "app", buildapi.BuildPhaseComplete),
+			expectedResult: false,
+		},
+	}
+
+	for count, test := range tests {
+		filter, err := NewBuildFilter(test.config)
+		if err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		result := filter.Accept(test.build)
+		if result != test.expectedResult {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedResult, result)
+		}
+	}
+}
+
+func TestNewBuildFilterErrors(t *testing.T) {
+	configs := []BuildsWatcherConfig{
+		{FieldSelector: "status"},
+		{IncludeBuildConfigs: []string{"["}},
+		{ExcludeNamespaces: []string{"("}},
+	}
+
+	for count, config := range configs {
+		if _, err := NewBuildFilter(config); err == nil {
+			t.Errorf("Test[%d] Failed: Expected an error for config %+v", count, config)
+		}
+	}
+}
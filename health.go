@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultHttpAddress                = ":8080"
+	DefaultHttpWatchTimeout           = "5m"
+	DefaultHttpNotifierFailureTimeout = "15m"
+	// DefaultDeliveryHistorySize is the number of recent deliveries kept by each notifier, for the admin API
+	DefaultDeliveryHistorySize = 50
+)
+
+// WatcherStatus is the state of the watch of a builds watcher, reported by the health endpoints
+type WatcherStatus struct {
+	mutex             sync.Mutex
+	connected         bool
+	lastConnected     time.Time
+	disconnectedSince time.Time
+	lastEvent         time.Time
+	reconnects        int
+	lastError         string
+	lastErrorTime     time.Time
+	paused            bool
+}
+
+func NewWatcherStatus() *WatcherStatus {
+	return &WatcherStatus{
+		disconnectedSince: time.Now(),
+	}
+}
+
+// Connected records that the watch has been (re-)opened
+func (status *WatcherStatus) Connected() {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	if !status.lastConnected.IsZero() {
+		status.reconnects++
+	}
+	status.connected = true
+	status.lastConnected = time.Now()
+}
+
+// Disconnected records that the watch has been closed - because of the given error, if any
+func (status *WatcherStatus) Disconnected(err error) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	if status.connected {
+		status.connected = false
+		status.disconnectedSince = time.Now()
+	}
+	if err != nil {
+		status.lastError = err.Error()
+		status.lastErrorTime = time.Now()
+	}
+}
+
+// Event records that an event has been received
+func (status *WatcherStatus) Event() {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.lastEvent = time.Now()
+}
+
+// SetPaused pauses or resumes the notifications of the watcher - a paused watcher keeps watching,
+// but drops the events it receives
+func (status *WatcherStatus) SetPaused(paused bool) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.paused = paused
+}
+
+func (status *WatcherStatus) Paused() bool {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	return status.paused
+}
+
+// WatcherHealth is the JSON representation of the state of a watcher
+type WatcherHealth struct {
+	Connected         bool       `json:"connected"`
+	LastConnectedTime *time.Time `json:"lastConnectedTime,omitempty"`
+	// DisconnectedSince is only set when the watch is not open
+	DisconnectedSince *time.Time `json:"disconnectedSince,omitempty"`
+	LastEventTime     *time.Time `json:"lastEventTime,omitempty"`
+	Reconnects        int        `json:"reconnects"`
+	LastError         string     `json:"lastError,omitempty"`
+	LastErrorTime     *time.Time `json:"lastErrorTime,omitempty"`
+	Paused            bool       `json:"paused"`
+}
+
+func (status *WatcherStatus) Health() WatcherHealth {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	health := WatcherHealth{
+		Connected:         status.connected,
+		LastConnectedTime: optionalTime(status.lastConnected),
+		LastEventTime:     optionalTime(status.lastEvent),
+		Reconnects:        status.reconnects,
+		LastError:         status.lastError,
+		LastErrorTime:     optionalTime(status.lastErrorTime),
+		Paused:            status.paused,
+	}
+	if !status.connected {
+		health.DisconnectedSince = optionalTime(status.disconnectedSince)
+	}
+	return health
+}
+
+// NotifierStatus is the state of the deliveries of a notifier, reported by the health endpoints
+type NotifierStatus struct {
+	mutex        sync.Mutex
+	lastSuccess  time.Time
+	lastFailure  time.Time
+	failingSince time.Time
+	lastError    string
+	// history holds the most recent deliveries, the oldest first
+	history []DeliveryRecord
+}
+
+// DeliveryRecord is an entry of the recent delivery history of a notifier
+type DeliveryRecord struct {
+	Time    time.Time `json:"time"`
+	Project string    `json:"project"`
+	Subject string    `json:"subject"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Record records the result of a delivery: a success if err is nil, or a failure
+func (status *NotifierStatus) Record(err error) {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	now := time.Now()
+	if err == nil {
+		status.lastSuccess = now
+		status.failingSince = time.Time{}
+		return
+	}
+	status.lastFailure = now
+	status.lastError = err.Error()
+	if status.failingSince.IsZero() {
+		status.failingSince = now
+	}
+}
+
+// RecordDelivery records the result of a delivery - like Record - and adds it to the history
+func (status *NotifierStatus) RecordDelivery(project string, subject string, err error) {
+	status.Record(err)
+
+	record := DeliveryRecord{
+		Time:    time.Now(),
+		Project: project,
+		Subject: subject,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	status.history = append(status.history, record)
+	if len(status.history) > DefaultDeliveryHistorySize {
+		status.history = status.history[len(status.history)-DefaultDeliveryHistorySize:]
+	}
+}
+
+// History returns the most recent deliveries, the latest first
+func (status *NotifierStatus) History() []DeliveryRecord {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	history := make([]DeliveryRecord, 0, len(status.history))
+	for i := len(status.history) - 1; i >= 0; i-- {
+		history = append(history, status.history[i])
+	}
+	return history
+}
+
+// NotifierHealth is the JSON representation of the state of a notifier
+type NotifierHealth struct {
+	// BufferDepth is the number of events waiting to be handled by the notifier
+	BufferDepth int    `json:"bufferDepth"`
+	Dropped     uint64 `json:"dropped"`
+	// QueueDepth is the number of deliveries waiting to be sent (or retried) by a Flowdock notifier
+	QueueDepth      int        `json:"queueDepth"`
+	LastSuccessTime *time.Time `json:"lastSuccessTime,omitempty"`
+	LastFailureTime *time.Time `json:"lastFailureTime,omitempty"`
+	LastError       string     `json:"lastError,omitempty"`
+	// FailingSince is only set while the deliveries are failing
+	FailingSince *time.Time `json:"failingSince,omitempty"`
+	// MutedUntil is only set while the notifier is muted through the admin API
+	MutedUntil *time.Time `json:"mutedUntil,omitempty"`
+}
+
+func (status *NotifierStatus) Health() NotifierHealth {
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	return NotifierHealth{
+		LastSuccessTime: optionalTime(status.lastSuccess),
+		LastFailureTime: optionalTime(status.lastFailure),
+		LastError:       status.lastError,
+		FailingSince:    optionalTime(status.failingSince),
+	}
+}
+
+// Health is the JSON representation of the state of the application, returned by the health endpoints
+type Health struct {
+	Status string `json:"status"`
+	// Problems explain why the status is not "ok"
+	Problems []string `json:"problems,omitempty"`
+	// Configured is false until a valid configuration has been applied
+	Configured bool `json:"configured"`
+	// Started is false until the watchers have been started - and on the replicas which are not the leader
+	Started bool `json:"started"`
+	// Leader is only set when the leader election is enabled
+	Leader    *LeaderHealth             `json:"leader,omitempty"`
+	Watchers  map[string]WatcherHealth  `json:"watchers"`
+	Notifiers map[string]NotifierHealth `json:"notifiers"`
+}
+
+// LeaderHealth is the JSON representation of the leader election, as observed by this replica
+type LeaderHealth struct {
+	// Leader is the identity of the current leader
+	Leader string `json:"leader"`
+	// Identity is the identity of this replica
+	Identity string `json:"identity"`
+	IsLeader bool   `json:"isLeader"`
+}
+
+// Health returns the state of the running watchers and notifiers
+func (runtime *Runtime) Health() Health {
+	health := Health{
+		Status:    "ok",
+		Problems:  []string{},
+		Watchers:  make(map[string]WatcherHealth),
+		Notifiers: make(map[string]NotifierHealth),
+	}
+
+	runtime.mutex.Lock()
+	health.Configured = runtime.config != nil
+	health.Started = runtime.started
+	for name, task := range runtime.watchers {
+		health.Watchers[name] = task.watcher.Status.Health()
+	}
+	runtime.mutex.Unlock()
+
+	if runtime.Leader != nil {
+		health.Leader = &LeaderHealth{
+			Leader:   runtime.Leader.Leader(),
+			Identity: runtime.Leader.Identity,
+			IsLeader: runtime.Leader.IsLeader(),
+		}
+	}
+
+	for name, buffer := range runtime.Dispatcher.Buffers() {
+		var notifierHealth NotifierHealth
+		switch notifier := buffer.Notifier.(type) {
+		case *FlowdockNotifier:
+			notifierHealth = notifier.Status.Health()
+			notifierHealth.QueueDepth = notifier.Queue.Len()
+		case *JsonNotifier:
+			notifierHealth = notifier.Status.Health()
+		}
+		notifierHealth.BufferDepth = buffer.Depth()
+		notifierHealth.Dropped = buffer.Dropped()
+		notifierHealth.MutedUntil = optionalTime(runtime.Dispatcher.MutedUntil(name))
+		health.Notifiers[name] = notifierHealth
+	}
+	return health
+}
+
+// healthHandler returns the state of the application as JSON - with a 503 status
+// if check reports problems
+func healthHandler(runtime *Runtime, check func(health *Health) []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := runtime.Health()
+		health.Problems = check(&health)
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(health.Problems) > 0 {
+			health.Status = "failing"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(health); err != nil {
+			glog.Warningf("Failed to write the health of the application: %v", err)
+		}
+	}
+}
+
+// livenessProblems reports the running watchers which have been disconnected for more than the given timeout:
+// their watch loop is most likely stuck, and only a restart would fix it.
+func livenessProblems(watchTimeout time.Duration) func(health *Health) []string {
+	return func(health *Health) []string {
+		problems := []string{}
+		for _, name := range sortedKeys(health.Watchers) {
+			watcher := health.Watchers[name]
+			if watcher.DisconnectedSince != nil && time.Since(*watcher.DisconnectedSince) > watchTimeout {
+				problems = append(problems, fmt.Sprintf("watcher %s has been disconnected since %v", name, watcher.DisconnectedSince.Format(time.RFC3339)))
+			}
+		}
+		return problems
+	}
+}
+
+// readinessProblems reports a configuration which has not been applied yet, the running watchers which
+// have not opened their first watch yet, and the notifiers which have been failing for more than the given timeout
+func readinessProblems(notifierFailureTimeout time.Duration) func(health *Health) []string {
+	return func(health *Health) []string {
+		problems := []string{}
+		if !health.Configured {
+			problems = append(problems, "the configuration has not been applied yet")
+		}
+		for _, name := range sortedKeys(health.Watchers) {
+			watcher := health.Watchers[name]
+			if watcher.LastConnectedTime == nil {
+				problems = append(problems, fmt.Sprintf("watcher %s has not started watching yet", name))
+			}
+		}
+		for _, name := range sortedKeys(health.Notifiers) {
+			notifier := health.Notifiers[name]
+			if notifier.FailingSince != nil && time.Since(*notifier.FailingSince) > notifierFailureTimeout {
+				problems = append(problems, fmt.Sprintf("notifier %s has been failing since %v: %s", name, notifier.FailingSince.Format(time.RFC3339), notifier.LastError))
+			}
+		}
+		return problems
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
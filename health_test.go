@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+)
+
+func TestHealthProblems(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-time.Hour)
+
+	tests := []struct {
+		health                    Health
+		expectedLivenessProblems  []string
+		expectedReadinessProblems []string
+	}{
+		{
+			health: Health{
+				Configured: true,
+				Watchers: map[string]WatcherHealth{
+					"all": {Connected: true, LastConnectedTime: &longAgo},
+				},
+				Notifiers: map[string]NotifierHealth{
+					"default": {LastSuccessTime: &now},
+				},
+			},
+			expectedLivenessProblems:  []string{},
+			expectedReadinessProblems: []string{},
+		},
+		// should not be ready before the configuration is applied, and before the first watch
+		{
+			health: Health{
+				Watchers: map[string]WatcherHealth{
+					"all": {DisconnectedSince: &now},
+				},
+			},
+			expectedLivenessProblems: []string{},
+			expectedReadinessProblems: []string{
+				"the configuration has not been applied yet",
+				"watcher all has not started watching yet",
+			},
+		},
+		// should not be alive with a watcher disconnected for too long, nor ready with a notifier failing for too long
+		{
+			health: Health{
+				Configured: true,
+				Watchers: map[string]WatcherHealth{
+					"all":  {LastConnectedTime: &longAgo, DisconnectedSince: &longAgo},
+					"team": {LastConnectedTime: &longAgo, DisconnectedSince: &now},
+				},
+				Notifiers: map[string]NotifierHealth{
+					"default": {FailingSince: &longAgo, LastError: "503 Service Unavailable"},
+					"ops":     {FailingSince: &now, LastError: "503 Service Unavailable"},
+				},
+			},
+			expectedLivenessProblems: []string{
+				"watcher all has been disconnected since " + longAgo.Format(time.RFC3339),
+			},
+			expectedReadinessProblems: []string{
+				"notifier default has been failing since " + longAgo.Format(time.RFC3339) + ": 503 Service Unavailable",
+			},
+		},
+	}
+
+	for count, test := range tests {
+		liveness := livenessProblems(5 * time.Minute)(&test.health)
+		if !reflect.DeepEqual(liveness, test.expectedLivenessProblems) {
+			t.Errorf("Test[%d] Failed: Expected liveness problems '%v' but got '%v'", count, test.expectedLivenessProblems, liveness)
+		}
+		readiness := readinessProblems(15 * time.Minute)(&test.health)
+		if !reflect.DeepEqual(readiness, test.expectedReadinessProblems) {
+			t.Errorf("Test[%d] Failed: Expected readiness problems '%v' but got '%v'", count, test.expectedReadinessProblems, readiness)
+		}
+	}
+}
+
+func TestNotifierStatus(t *testing.T) {
+	status := &NotifierStatus{}
+	status.Record(errors.New("first failure"))
+	failingSince := status.Health().FailingSince
+	status.Record(errors.New("second failure"))
+
+	health := status.Health()
+	if health.FailingSince == nil || !health.FailingSince.Equal(*failingSince) {
+		t.Errorf("Expected to be failing since the first failure '%v' but got '%v'", failingSince, health.FailingSince)
+	}
+	if health.LastError != "second failure" {
+		t.Errorf("Expected the last error 'second failure' but got '%v'", health.LastError)
+	}
+
+	status.Record(nil)
+	health = status.Health()
+	if health.FailingSince != nil || health.LastSuccessTime == nil || health.LastFailureTime == nil {
+		t.Errorf("Expected a success after the failures but got '%+v'", health)
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error))
+	server, err := NewHttpServer(HttpConfig{WatchTimeout: "5m", NotifierFailureTimeout: "15m"}, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedStatusCodes := []int{http.StatusServiceUnavailable, http.StatusOK}
+	for count, expectedStatusCode := range expectedStatusCodes {
+		if count > 0 {
+			config := &AppConfig{
+				BuildsWatchers: map[string]*BuildsWatcherConfig{"all": {AllNamespaces: true}},
+				Notifiers:      map[string]*NotifierConfig{"default": {}},
+			}
+			config.SetDefaults()
+			if err := runtime.Apply(config); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		request, err := http.NewRequest("GET", "/readyz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		server.Mux.ServeHTTP(recorder, request)
+		if recorder.Code != expectedStatusCode {
+			t.Errorf("Test[%d] Failed: Expected status code '%v' but got '%v'", count, expectedStatusCode, recorder.Code)
+		}
+		var health Health
+		if err := json.Unmarshal(recorder.Body.Bytes(), &health); err != nil {
+			t.Errorf("Test[%d] Failed: invalid JSON response %s: %v", count, recorder.Body.String(), err)
+		}
+	}
+}
+
+func TestHealthRedactsFlowdockToken(t *testing.T) {
+	requestedPath := ""
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message":"invalid flow token"}`))
+	}))
+	defer api.Close()
+
+	config := NotifierConfig{Token: "secret-token"}
+	config.SetDefaults()
+	notifier, err := NewFlowdockNotifier("ops", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.FlowdockClient.RestURL, _ = url.Parse(api.URL + "/")
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error))
+	if err := runtime.Dispatcher.AddNotifier("ops", notifier, config); err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Dispatcher.RemoveNotifier("ops")
+
+	err = notifier.Send(NewSampleBuildEvent())
+	if err == nil {
+		t.Fatalf("Expected the notification to fail with a 401")
+	}
+	if !strings.Contains(requestedPath, "secret-token") {
+		t.Fatalf("Expected the token in the path of the request but got '%v'", requestedPath)
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Expected the token to be redacted from the error but got '%v'", err)
+	}
+
+	health := runtime.Health()
+	health.Problems = readinessProblems(0)(&health)
+	data, err := json.Marshal(health)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Errorf("Expected the token to be redacted from the health but got '%s'", data)
+	}
+	// the first problem is the configuration, which has not been applied
+	if len(health.Problems) != 2 || !strings.Contains(health.Problems[1], "team_inbox/"+RedactedValue) {
+		t.Errorf("Expected a problem with the redacted error but got '%v'", health.Problems)
+	}
+}
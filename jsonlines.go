@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api/unversioned"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultJsonOutput        = "stdout"
+	DefaultJsonMaxFileSizeMB = 100
+	DefaultJsonMaxBackups    = 3
+)
+
+// JsonNotifier writes every event it receives as a JSON line
+// either to stdout or to a (rotating) file.
+// It is useful for debugging, for shipping the events to a log pipeline,
+// or as a dry-run sink when testing a configuration without a Flowdock token.
+type JsonNotifier struct {
+	Config    NotifierConfig
+	Templates *MessageTemplates
+	// Status records the results of the writes, for the health endpoints
+	Status  *NotifierStatus
+	writer  io.Writer
+	channel chan Event
+}
+
+// JsonLine is the JSON representation of a notification
+type JsonLine struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Content string    `json:"content"`
+	Tags    []string  `json:"tags"`
+	Event   JsonEvent `json:"event"`
+}
+
+// JsonEvent is the JSON representation of an Event
+type JsonEvent struct {
+	Namespace       string            `json:"namespace"`
+	Name            string            `json:"name"`
+	ObjectType      string            `json:"objectType"`
+	ObjectStartTime *unversioned.Time `json:"objectStartTime,omitempty"`
+	ObjectEndTime   *unversioned.Time `json:"objectEndTime,omitempty"`
+	ObjectDuration  string            `json:"objectDuration"`
+	Input           string            `json:"input"`
+	Output          string            `json:"output"`
+	Status          string            `json:"status"`
+	IsSuccess       bool              `json:"isSuccess"`
+	IsFailure       bool              `json:"isFailure"`
+	Transition      string            `json:"transition,omitempty"`
+	NodeName        string            `json:"nodeName"`
+	Url             string            `json:"url"`
+	Logs            string            `json:"logs"`
+	Events          []string          `json:"events"`
+}
+
+func NewJsonEvent(event Event) JsonEvent {
+	return JsonEvent{
+		Namespace:       event.Namespace(),
+		Name:            event.Name(),
+		ObjectType:      event.ObjectType(),
+		ObjectStartTime: event.ObjectStartTime(),
+		ObjectEndTime:   event.ObjectEndTime(),
+		ObjectDuration:  event.ObjectDuration().String(),
+		Input:           event.Input(),
+		Output:          event.Output(),
+		Status:          event.Status(),
+		IsSuccess:       event.IsSuccess(),
+		IsFailure:       event.IsFailure(),
+		Transition:      event.Transition(),
+		NodeName:        event.NodeName(),
+		Url:             event.Url(),
+		Logs:            event.Logs(),
+		Events:          event.Events(),
+	}
+}
+
+func NewJsonNotifier(name string, config NotifierConfig) (*JsonNotifier, error) {
+	templates, err := NewMessageTemplates(config)
+	if err != nil {
+		return nil, err
+	}
+	templates.Name = name
+
+	var writer io.Writer
+	switch config.Output {
+	case "", "stdout":
+		writer = os.Stdout
+	default:
+		writer, err = newRotatingFile(config.Output, int64(config.MaxFileSizeMB)*1024*1024, config.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	notifier := &JsonNotifier{
+		Config:    config,
+		Templates: templates,
+		Status:    &NotifierStatus{},
+		writer:    writer,
+		channel:   make(chan Event),
+	}
+	return notifier, nil
+}
+
+func (notifier *JsonNotifier) Channel() chan Event {
+	return notifier.channel
+}
+
+func (notifier *JsonNotifier) Run() {
+	for {
+		event, open := <-notifier.channel
+
+		if !open {
+			glog.V(1).Infof("JSON Channel has been closed")
+			// stdout is left open
+			if file, ok := notifier.writer.(*rotatingFile); ok {
+				if err := file.Close(); err != nil {
+					glog.Warningf("Failed to close %s: %v", notifier.Config.Output, err)
+				}
+			}
+			break
+		}
+
+		if err := notifier.writeEvent(event); err != nil {
+			glog.Errorf("Failed to write a JSON line to %s: %v", notifier.Config.Output, err)
+		}
+	}
+}
+
+// Send writes the event immediately
+func (notifier *JsonNotifier) Send(event Event) error {
+	return notifier.writeEvent(event)
+}
+
+func (notifier *JsonNotifier) writeEvent(event Event) error {
+	message, err := notifier.Templates.Render(event)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(JsonLine{
+		Time:    time.Now(),
+		Subject: message.Subject,
+		Content: message.Content,
+		Tags:    message.Tags,
+		Event:   NewJsonEvent(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = notifier.writer.Write(append(line, '\n'))
+	notifier.Status.RecordDelivery(event.Namespace(), message.Subject, err)
+	recordNotification(notifier.Templates.Name, false, err)
+	return err
+}
+
+// rotatingFile is an io.Writer that writes to a file,
+// and rotates it when it reaches a max size.
+// The rotated files are named path.1, path.2, ... (path.1 being the most recent)
+// It can be written concurrently: by the notifier, and by the test notifications of the admin API.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// the current file is still open: keep writing to it, rather than losing the events
+			glog.Warningf("Failed to rotate %s: %v", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.file.Close()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file to the backups, and opens a new one
+// The current file is moved aside first, and the backups are only shifted once it has been moved,
+// so that a failed rotation - retried on the next write - never pushes the backups off the end.
+// The current file is renamed while it is still open, and only closed once the new one has been opened,
+// so that it can still be written to if anything fails.
+func (f *rotatingFile) rotate() error {
+	if f.maxBackups <= 0 {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return f.reopen()
+	}
+
+	rotating := f.path + ".rotating"
+	if err := os.Rename(f.path, rotating); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		// the current file has been removed behind our back: there is nothing to back up
+		return f.reopen()
+	}
+	if err := f.shiftBackups(rotating); err != nil {
+		// put the current file back, so that the next rotation starts from the same files
+		if restoreErr := os.Rename(rotating, f.path); restoreErr != nil {
+			glog.Warningf("Failed to restore %s: %v", f.path, restoreErr)
+		}
+		return err
+	}
+	return f.reopen()
+}
+
+// shiftBackups renames path.N to path.N+1 - dropping the oldest one - and the rotated file to path.1
+func (f *rotatingFile) shiftBackups(rotated string) error {
+	for i := f.maxBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", f.path, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(rotated, fmt.Sprintf("%s.1", f.path))
+}
+
+// reopen opens a new current file, and closes the previous one
+func (f *rotatingFile) reopen() error {
+	previous := f.file
+	if err := f.open(); err != nil {
+		// the current file has been moved: don't move it again until it reaches the max size again
+		f.size = 0
+		return err
+	}
+	return previous.Close()
+}
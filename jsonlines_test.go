@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJsonNotifierWriteEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NotifierConfig{
+		Type:            JsonNotifierType,
+		Output:          filepath.Join(dir, "notifications.jsonl"),
+		SubjectTemplate: "{{.Namespace}}/{{.Name}} is {{.Status}}",
+		ContentTemplate: "from {{.Input}}",
+		Tags:            []string{"{{.Namespace}}", "{{.Status}}"},
+	}
+	config.SetDefaults()
+	notifier, err := NewJsonNotifier("default", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := NewSampleBuildEvent()
+	if err := notifier.Send(event); err != nil {
+		t.Fatalf("Failed to write the event: %v", err)
+	}
+	if err := notifier.writer.(*rotatingFile).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readJsonLines(t, config.Output)
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 JSON line but got %d", len(lines))
+	}
+	line := lines[0]
+	if line.Subject != "sample-project/sample-app-1 is Failed" {
+		t.Errorf("Expected the rendered subject 'sample-project/sample-app-1 is Failed' but got '%v'", line.Subject)
+	}
+	if line.Content != "from "+event.Input() {
+		t.Errorf("Expected the rendered content 'from %v' but got '%v'", event.Input(), line.Content)
+	}
+	if !reflect.DeepEqual(line.Tags, []string{"sample-project", "Failed"}) {
+		t.Errorf("Expected the rendered tags '%v' but got '%v'", []string{"sample-project", "Failed"}, line.Tags)
+	}
+
+	// the raw event fields, as they are read back from JSON
+	var expectedEvent JsonEvent
+	raw, err := json.Marshal(NewJsonEvent(event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(raw, &expectedEvent); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(line.Event, expectedEvent) {
+		t.Errorf("Expected the event fields '%+v' but got '%+v'", expectedEvent, line.Event)
+	}
+	if line.Event.Namespace != "sample-project" || line.Event.Name != "sample-app-1" || line.Event.Status != "Failed" || !line.Event.IsFailure {
+		t.Errorf("Expected the fields of the sample build but got '%+v'", line.Event)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		maxBackups int
+		// lines are 10 bytes long, and the max size is 25 bytes: 2 lines per file
+		lines         int
+		expectedFiles map[string][]string
+	}{
+		{
+			maxBackups: 2,
+			lines:      3,
+			expectedFiles: map[string][]string{
+				"out":   {"line-0002"},
+				"out.1": {"line-0000", "line-0001"},
+			},
+		},
+		// the oldest backups are pruned
+		{
+			maxBackups: 2,
+			lines:      9,
+			expectedFiles: map[string][]string{
+				"out":   {"line-0008"},
+				"out.1": {"line-0006", "line-0007"},
+				"out.2": {"line-0004", "line-0005"},
+			},
+		},
+		// without backups, the file is truncated
+		{
+			maxBackups: 0,
+			lines:      5,
+			expectedFiles: map[string][]string{
+				"out": {"line-0004"},
+			},
+		},
+		// a negative maxBackups - kept as-is by the defaults - means no backups
+		{
+			maxBackups: -1,
+			lines:      5,
+			expectedFiles: map[string][]string{
+				"out": {"line-0004"},
+			},
+		},
+	}
+
+	for count, test := range tests {
+		dir, err := ioutil.TempDir("", "rotation")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		file, err := newRotatingFile(filepath.Join(dir, "out"), 25, test.maxBackups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < test.lines; i++ {
+			if _, err := fmt.Fprintf(file, "line-%04d\n", i); err != nil {
+				t.Errorf("Test[%d] Failed: unexpected error for line %d: %v", count, i, err)
+			}
+		}
+		file.Close()
+
+		files := readFiles(t, dir)
+		if !reflect.DeepEqual(files, test.expectedFiles) {
+			t.Errorf("Test[%d] Failed: Expected the files '%v' but got '%v'", count, test.expectedFiles, files)
+		}
+	}
+}
+
+func TestRotatingFileFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the current file can't be renamed to a non-empty directory
+	if err := os.MkdirAll(filepath.Join(dir, "out.1", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := newRotatingFile(filepath.Join(dir, "out"), 25, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := fmt.Fprintf(file, "line-%04d\n", i); err != nil {
+			t.Errorf("Expected the writes to keep working when the rotation fails, but line %d failed: %v", i, err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 5 {
+		t.Errorf("Expected the 5 lines in the current file but got %d", lines)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.1", "blocker")); err != nil {
+		t.Errorf("Expected the blocking directory to be left untouched but got %v", err)
+	}
+}
+
+func TestRotatingFileRemoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := newRotatingFile(filepath.Join(dir, "out"), 25, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for i := 0; i < 5; i++ {
+		if i == 3 {
+			// the current file is removed behind our back: the next rotation should not shift the backups
+			if err := os.Remove(filepath.Join(dir, "out")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := fmt.Fprintf(file, "line-%04d\n", i); err != nil {
+			t.Errorf("Unexpected error for line %d: %v", i, err)
+		}
+	}
+
+	expectedFiles := map[string][]string{
+		"out":   {"line-0004"},
+		"out.1": {"line-0000", "line-0001"},
+	}
+	if files := readFiles(t, dir); !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("Expected the files '%v' but got '%v'", expectedFiles, files)
+	}
+}
+
+func TestJsonNotifierMaxBackupsDefaults(t *testing.T) {
+	tests := []struct {
+		maxBackups         int
+		expectedMaxBackups int
+	}{
+		{0, DefaultJsonMaxBackups},
+		{1, 1},
+		{-1, -1},
+	}
+
+	for count, test := range tests {
+		config := NotifierConfig{Type: JsonNotifierType, MaxBackups: test.maxBackups}
+		config.SetDefaults()
+		if config.MaxBackups != test.expectedMaxBackups {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedMaxBackups, config.MaxBackups)
+		}
+	}
+}
+
+func TestJsonNotifierConcurrentSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonlines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NotifierConfig{
+		Type:   JsonNotifierType,
+		Output: filepath.Join(dir, "notifications.jsonl"),
+	}
+	config.SetDefaults()
+	notifier, err := NewJsonNotifier("default", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a small file, to rotate while writing
+	notifier.writer.(*rotatingFile).Close()
+	if notifier.writer, err = newRotatingFile(config.Output, 16*1024, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	const eventsCount = 50
+	done := make(chan struct{})
+	go func() {
+		notifier.Run()
+		close(done)
+	}()
+	sent := make(chan struct{})
+	go func() {
+		// the test notifications of the admin API
+		for i := 0; i < eventsCount; i++ {
+			if err := notifier.Send(NewSampleBuildEvent()); err != nil {
+				t.Errorf("Failed to send an event: %v", err)
+			}
+		}
+		close(sent)
+	}()
+	for i := 0; i < eventsCount; i++ {
+		notifier.Channel() <- NewSampleBuildEvent()
+	}
+	<-sent
+	close(notifier.Channel())
+	<-done
+
+	count := 0
+	for name, lines := range readFiles(t, dir) {
+		for _, line := range lines {
+			var jsonLine JsonLine
+			if err := json.Unmarshal([]byte(line), &jsonLine); err != nil {
+				t.Errorf("Invalid JSON line in %s: %v", name, err)
+			}
+			count++
+		}
+	}
+	if count != 2*eventsCount {
+		t.Errorf("Expected %d JSON lines but got %d", 2*eventsCount, count)
+	}
+}
+
+func readJsonLines(t *testing.T, path string) []JsonLine {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	lines := []JsonLine{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line JsonLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Invalid JSON line %s: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// readFiles returns the lines of each file of the given directory
+func readFiles(t *testing.T, dir string) map[string][]string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]string)
+	for _, info := range infos {
+		content, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[info.Name()] = strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	}
+	return files
+}
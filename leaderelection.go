@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	k8client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/golang/glog"
+)
+
+const (
+	LeaderElectionRecordAnnotation = "flowdock-notifier/leader"
+
+	DefaultLeaderElectionName          = "flowdock-notifier"
+	DefaultLeaderElectionLeaseDuration = "15s"
+	DefaultLeaderElectionRenewDeadline = "10s"
+	DefaultLeaderElectionRetryPeriod   = "2s"
+)
+
+// LeaderElectionRecord is the record stored in an annotation of the lock object
+type LeaderElectionRecord struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+}
+
+// LeaderElector elects a leader between multiple replicas,
+// using an annotation on an Endpoints object as a lock.
+// Only the leader should run the watchers,
+// and the followers take over when the leader fails to renew its lease.
+type LeaderElector struct {
+	Identity      string
+	Namespace     string
+	Name          string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	// endpoints returns the client of the Endpoints holding the lock
+	endpoints      func() (k8client.EndpointsInterface, error)
+	mutex          sync.Mutex
+	observedRecord LeaderElectionRecord
+	observedTime   time.Time
+}
+
+func NewLeaderElector(factory clientcmd.Factory, config LeaderElectionConfig) (*LeaderElector, error) {
+	leaseDuration, err := time.ParseDuration(config.LeaseDuration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid leaseDuration %s: %v", config.LeaseDuration, err)
+	}
+	renewDeadline, err := time.ParseDuration(config.RenewDeadline)
+	if err != nil {
+		return nil, fmt.Errorf("invalid renewDeadline %s: %v", config.RenewDeadline, err)
+	}
+	retryPeriod, err := time.ParseDuration(config.RetryPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retryPeriod %s: %v", config.RetryPeriod, err)
+	}
+	if renewDeadline >= leaseDuration {
+		return nil, fmt.Errorf("renewDeadline (%v) must be lower than leaseDuration (%v)", renewDeadline, leaseDuration)
+	}
+
+	identity := config.Identity
+	if len(identity) == 0 {
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	namespace := config.Namespace
+	if len(namespace) == 0 {
+		namespace, _, err = factory.OpenShiftClientConfig.Namespace()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &LeaderElector{
+		Identity:      identity,
+		Namespace:     namespace,
+		Name:          config.Name,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		endpoints: func() (k8client.EndpointsInterface, error) {
+			_, kclient, err := factory.Clients()
+			if err != nil {
+				return nil, err
+			}
+			return kclient.Endpoints(namespace), nil
+		},
+	}, nil
+}
+
+// Leader returns the identity of the current leader, as last observed
+func (elector *LeaderElector) Leader() string {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	return elector.observedRecord.HolderIdentity
+}
+
+// IsLeader returns true if we are the current leader
+func (elector *LeaderElector) IsLeader() bool {
+	return elector.Leader() == elector.Identity
+}
+
+// Run blocks until we become the leader, then calls onStartedLeading,
+// and keeps renewing the lease. If it fails to renew the lease before the renew deadline,
+// it calls onStoppedLeading and returns.
+func (elector *LeaderElector) Run(onStartedLeading func(), onStoppedLeading func()) {
+	glog.Infof("Trying to acquire the leader lease %s/%s as %s...", elector.Namespace, elector.Name, elector.Identity)
+	lastLeader := ""
+	for !elector.tryAcquireOrRenew() {
+		if leader := elector.Leader(); leader != lastLeader {
+			glog.Infof("Current leader is %s", leader)
+			lastLeader = leader
+		}
+		time.Sleep(elector.RetryPeriod)
+	}
+	glog.Infof("Acquired the leader lease %s/%s: %s is now the leader", elector.Namespace, elector.Name, elector.Identity)
+
+	go onStartedLeading()
+
+	lastRenew := time.Now()
+	for {
+		time.Sleep(elector.RetryPeriod)
+		if elector.tryAcquireOrRenew() {
+			lastRenew = time.Now()
+			continue
+		}
+		if time.Since(lastRenew) > elector.RenewDeadline {
+			glog.Errorf("Failed to renew the leader lease %s/%s since %v: %s is no longer the leader", elector.Namespace, elector.Name, lastRenew, elector.Identity)
+			onStoppedLeading()
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew tries to acquire (or renew) the lease, and returns true on success
+func (elector *LeaderElector) tryAcquireOrRenew() bool {
+	endpointsClient, err := elector.endpoints()
+	if err != nil {
+		glog.Errorf("Can't get kube client: %v", err)
+		return false
+	}
+
+	now := time.Now()
+	record := LeaderElectionRecord{
+		HolderIdentity:       elector.Identity,
+		LeaseDurationSeconds: int(elector.LeaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	endpoints, err := endpointsClient.Get(elector.Name)
+	if err != nil {
+		if !kerrors.IsNotFound(err) {
+			glog.Errorf("Failed to get the leader lease %s/%s: %v", elector.Namespace, elector.Name, err)
+			return false
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			glog.Errorf("Failed to marshal the leader election record: %v", err)
+			return false
+		}
+		_, err = endpointsClient.Create(&kapi.Endpoints{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace: elector.Namespace,
+				Name:      elector.Name,
+				Annotations: map[string]string{
+					LeaderElectionRecordAnnotation: string(data),
+				},
+			},
+		})
+		if err != nil {
+			glog.Errorf("Failed to create the leader lease %s/%s: %v", elector.Namespace, elector.Name, err)
+			return false
+		}
+		elector.observe(record, now)
+		return true
+	}
+
+	existing := LeaderElectionRecord{}
+	if data, found := endpoints.Annotations[LeaderElectionRecordAnnotation]; found {
+		if err := json.Unmarshal([]byte(data), &existing); err != nil {
+			glog.Warningf("Ignoring invalid leader election record %s: %v", data, err)
+		}
+	}
+
+	elector.mutex.Lock()
+	if !reflect.DeepEqual(existing, elector.observedRecord) {
+		elector.observedRecord = existing
+		elector.observedTime = now
+	}
+	leaseValid := elector.observedTime.Add(time.Duration(existing.LeaseDurationSeconds) * time.Second).After(now)
+	elector.mutex.Unlock()
+
+	if len(existing.HolderIdentity) > 0 && existing.HolderIdentity != elector.Identity && leaseValid {
+		return false
+	}
+	if existing.HolderIdentity == elector.Identity {
+		record.AcquireTime = existing.AcquireTime
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		glog.Errorf("Failed to marshal the leader election record: %v", err)
+		return false
+	}
+	if endpoints.Annotations == nil {
+		endpoints.Annotations = make(map[string]string)
+	}
+	endpoints.Annotations[LeaderElectionRecordAnnotation] = string(data)
+	// the update will fail with a conflict if someone else updated the lock in the meantime
+	if _, err := endpointsClient.Update(endpoints); err != nil {
+		glog.Warningf("Failed to update the leader lease %s/%s: %v", elector.Namespace, elector.Name, err)
+		return false
+	}
+
+	elector.observe(record, now)
+	return true
+}
+
+func (elector *LeaderElector) observe(record LeaderElectionRecord, now time.Time) {
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	elector.observedRecord = record
+	elector.observedTime = now
+}
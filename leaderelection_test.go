@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	k8client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// fakeEndpoints is an in-memory lock for the leader election
+type fakeEndpoints struct {
+	endpoints *kapi.Endpoints
+	// updateError is returned by the updates, if set
+	updateError error
+}
+
+func (fake *fakeEndpoints) Create(endpoints *kapi.Endpoints) (*kapi.Endpoints, error) {
+	if fake.endpoints != nil {
+		return nil, kerrors.NewAlreadyExists("endpoints", endpoints.Name)
+	}
+	fake.endpoints = copyEndpoints(endpoints)
+	return copyEndpoints(endpoints), nil
+}
+
+func (fake *fakeEndpoints) Get(name string) (*kapi.Endpoints, error) {
+	if fake.endpoints == nil {
+		return nil, kerrors.NewNotFound("endpoints", name)
+	}
+	return copyEndpoints(fake.endpoints), nil
+}
+
+func (fake *fakeEndpoints) Update(endpoints *kapi.Endpoints) (*kapi.Endpoints, error) {
+	if fake.updateError != nil {
+		return nil, fake.updateError
+	}
+	fake.endpoints = copyEndpoints(endpoints)
+	return copyEndpoints(endpoints), nil
+}
+
+func (fake *fakeEndpoints) List(selector labels.Selector) (*kapi.EndpointsList, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fake *fakeEndpoints) Delete(name string) error {
+	return errors.New("not implemented")
+}
+
+func (fake *fakeEndpoints) Watch(label labels.Selector, field fields.Selector, resourceVersion string) (watch.Interface, error) {
+	return nil, errors.New("not implemented")
+}
+
+func copyEndpoints(endpoints *kapi.Endpoints) *kapi.Endpoints {
+	copied := *endpoints
+	copied.Annotations = make(map[string]string)
+	for key, value := range endpoints.Annotations {
+		copied.Annotations[key] = value
+	}
+	return &copied
+}
+
+func newTestLeaderElector(identity string, lock *fakeEndpoints) *LeaderElector {
+	return &LeaderElector{
+		Identity:      identity,
+		Namespace:     "test",
+		Name:          "lock",
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+		endpoints: func() (k8client.EndpointsInterface, error) {
+			return lock, nil
+		},
+	}
+}
+
+func TestLeaderElectorTryAcquireOrRenew(t *testing.T) {
+	lock := &fakeEndpoints{}
+	a := newTestLeaderElector("a", lock)
+	b := newTestLeaderElector("b", lock)
+
+	tests := []struct {
+		elector *LeaderElector
+		// expire makes the lease observed (unchanged) by the elector expire before it tries
+		expire           bool
+		updateError      error
+		expectedAcquired bool
+		expectedLeader   string
+	}{
+		// should create the lock
+		{elector: a, expectedAcquired: true, expectedLeader: "a"},
+		// should not take over a valid lease
+		{elector: b, expectedAcquired: false, expectedLeader: "a"},
+		// should renew its own lease
+		{elector: a, expectedAcquired: true, expectedLeader: "a"},
+		// a renewed lease is valid again
+		{elector: b, expectedAcquired: false, expectedLeader: "a"},
+		// should take over a lease which has not been renewed for longer than its duration
+		{elector: b, expire: true, expectedAcquired: true, expectedLeader: "b"},
+		// should observe the new leader
+		{elector: a, expectedAcquired: false, expectedLeader: "b"},
+		// should fail to renew when the lock can't be updated
+		{elector: b, updateError: kerrors.NewConflict("endpoints", "lock", errors.New("conflict")), expectedAcquired: false, expectedLeader: "b"},
+		{elector: b, expectedAcquired: true, expectedLeader: "b"},
+	}
+
+	for count, test := range tests {
+		if test.expire {
+			test.elector.mutex.Lock()
+			test.elector.observedTime = test.elector.observedTime.Add(-time.Hour)
+			test.elector.mutex.Unlock()
+		}
+		lock.updateError = test.updateError
+
+		acquired := test.elector.tryAcquireOrRenew()
+		if acquired != test.expectedAcquired {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedAcquired, acquired)
+		}
+		if leader := test.elector.Leader(); leader != test.expectedLeader {
+			t.Errorf("Test[%d] Failed: Expected the leader '%v' but got '%v'", count, test.expectedLeader, leader)
+		}
+		if isLeader := test.elector.IsLeader(); isLeader != (test.expectedLeader == test.elector.Identity) {
+			t.Errorf("Test[%d] Failed: Expected IsLeader '%v' but got '%v'", count, test.expectedLeader == test.elector.Identity, isLeader)
+		}
+	}
+}
+
+func TestLeaderElectorKeepsAcquireTime(t *testing.T) {
+	lock := &fakeEndpoints{}
+	elector := newTestLeaderElector("a", lock)
+	if !elector.tryAcquireOrRenew() {
+		t.Fatalf("Expected to acquire the lease")
+	}
+	acquireTime := elector.observedRecord.AcquireTime
+
+	time.Sleep(time.Millisecond)
+	if !elector.tryAcquireOrRenew() {
+		t.Fatalf("Expected to renew the lease")
+	}
+	if !elector.observedRecord.AcquireTime.Equal(acquireTime) {
+		t.Errorf("Expected the acquire time '%v' to be kept but got '%v'", acquireTime, elector.observedRecord.AcquireTime)
+	}
+	if !elector.observedRecord.RenewTime.After(acquireTime) {
+		t.Errorf("Expected the renew time to be after '%v' but got '%v'", acquireTime, elector.observedRecord.RenewTime)
+	}
+}
+
+func TestRuntimeHealthLeader(t *testing.T) {
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error))
+	if health := runtime.Health(); health.Leader != nil {
+		t.Errorf("Expected no leader without leader election but got '%+v'", health.Leader)
+	}
+
+	lock := &fakeEndpoints{}
+	leader := newTestLeaderElector("a", lock)
+	leader.tryAcquireOrRenew()
+	runtime.Leader = newTestLeaderElector("b", lock)
+	runtime.Leader.tryAcquireOrRenew()
+	expectedLeader := &LeaderHealth{Leader: "a", Identity: "b", IsLeader: false}
+	if health := runtime.Health(); !reflect.DeepEqual(health.Leader, expectedLeader) {
+		t.Errorf("Expected the leader '%+v' but got '%+v'", expectedLeader, health.Leader)
+	}
+}
@@ -1,53 +1,98 @@
 package main
 
 import (
-	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/golang/glog"
-	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
-	"github.com/spf13/pflag"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
-	flags := pflag.NewFlagSet("openshift-flowdock-notifier", pflag.ExitOnError)
-	flags.AddGoFlagSet(flag.CommandLine)
-	flags.Parse(os.Args[1:])
-
-	factory := getFactory(flags)
+	if err := NewRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
 
-	appConfig, err := LoadAppConfig()
+// runNotifier watches the builds and sends the notifications, until it is interrupted
+func runNotifier(options *commandOptions) {
+	factory := options.factory
+	appConfig, err := LoadAppConfig(options.configFile)
 	if err != nil {
 		glog.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	if !appConfig.HasWatchers() {
-		glog.Fatalf("No watchers have been defined in the configuration. Closing application!")
-	}
-	if !appConfig.HasNotifiers() {
-		glog.Fatalf("No notifiers have been defined in the configuration. Closing application!")
+	if appConfig.LeaderElection.Enabled && appConfig.Sharding.Enabled {
+		glog.Fatalf("Leader election and sharding can't be enabled at the same time. Closing application!")
 	}
 
-	notifiers := make(map[string]FlowdockNotifier)
-	for notifierName, notifierConfig := range appConfig.Notifiers {
-		notifier, err := NewFlowdockNotifier(*notifierConfig)
+	var shards *ShardCoordinator
+	if appConfig.Sharding.Enabled {
+		shards, err = NewShardCoordinator(*factory, appConfig.Sharding)
 		if err != nil {
-			glog.Fatalf("Failed to create Flowdock Notifier %s: %v", notifierName, err)
+			glog.Fatalf("Failed to create the shard coordinator: %v", err)
 		}
-		notifiers[notifierName] = *notifier
-		go notifier.Run()
+		go shards.Run()
 	}
 
 	errors := make(chan error)
-	for watcherName, watcherConfig := range appConfig.BuildsWatchers {
-		watcher := NewBuildsWatcher(watcherName, *watcherConfig)
-		go func(factory *clientcmd.Factory, notifiers *map[string]FlowdockNotifier, errors chan<- error) {
-			if err := watcher.Watch(*factory, *notifiers); err != nil {
-				errors <- err
-			}
-		}(factory, &notifiers, errors)
+	runtime := NewRuntime(factory, shards, errors)
+	runtime.DryRun = options.dryRun
+	if runtime.DryRun {
+		glog.Infof("Dry-run mode: the notifications won't be sent to Flowdock")
+	}
+	if appConfig.LeaderElection.Enabled {
+		runtime.Leader, err = NewLeaderElector(*factory, appConfig.LeaderElection)
+		if err != nil {
+			glog.Fatalf("Failed to create the leader elector: %v", err)
+		}
+	}
+	if len(options.recordFile) > 0 {
+		runtime.Recorder, err = NewEventRecorder(options.recordFile)
+		if err != nil {
+			glog.Fatalf("Failed to open the recording file: %v", err)
+		}
+		defer runtime.Recorder.Close()
+		glog.Infof("Recording the events of the watchers in %s", options.recordFile)
+	}
+	if appConfig.Http.Enabled {
+		// started before the configuration is applied, so that the probes can report the startup
+		server, err := NewHttpServer(appConfig.Http, runtime)
+		if err != nil {
+			glog.Fatalf("Invalid configuration of the HTTP server: %v", err)
+		}
+		prometheus.MustRegister(NewRuntimeCollector(runtime))
+		go func() {
+			errors <- server.Run()
+		}()
+	}
+	if appConfig.ConfigMaps.Enabled {
+		runtime.ConfigMaps, err = NewConfigMapsSource(*factory, appConfig.ConfigMaps, runtime)
+		if err != nil {
+			glog.Fatalf("Failed to read the configuration from the ConfigMaps: %v", err)
+		}
+	}
+	if err := runtime.ApplyBase(appConfig); err != nil {
+		glog.Fatalf("Invalid configuration: %v. Closing application!", err)
+	}
+	if runtime.ConfigMaps != nil {
+		// read the ConfigMaps once before starting, so that their watchers start with the others
+		if err := runtime.ConfigMaps.Sync(); err != nil {
+			glog.Errorf("Failed to apply the configuration of the ConfigMaps: %v", err)
+		}
+		go runtime.ConfigMaps.Run()
+	}
+	runtime.WatchConfig()
+
+	if runtime.Leader != nil {
+		// only the leader runs the watchers and the reports
+		go runtime.Leader.Run(runtime.Start, func() {
+			errors <- fmt.Errorf("lost the leader lease")
+		})
+	} else {
+		runtime.Start()
 	}
 
 	c := make(chan os.Signal, 1)
@@ -56,6 +101,15 @@ func main() {
 	select {
 	case <-c:
 		glog.Infof("Interrupted by user (or killed) !")
+		if runtime.ConfigMaps != nil {
+			// don't apply a new configuration while stopping
+			runtime.ConfigMaps.Stop()
+		}
+		if shards != nil {
+			// hand over our namespaces to the other replicas
+			shards.Leave()
+		}
+		runtime.SecretTokens.Stop()
 	case err := <-errors:
 		glog.Fatalf("Error caught while watching: %v", err)
 	}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const MetricsNamespace = "flowdock_notifier"
+
+var (
+	eventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "events_received_total",
+		Help:      "Number of build events received by a watcher, by event type.",
+	}, []string{"watcher", "type"})
+
+	eventsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "events_accepted_total",
+		Help:      "Number of build events accepted by a watcher, and dispatched to its notifiers.",
+	}, []string{"watcher"})
+
+	eventsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "events_rejected_total",
+		Help:      "Number of build events rejected by a watcher, by reason.",
+	}, []string{"watcher", "reason"})
+
+	buildOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "builds_total",
+		Help:      "Number of finished builds seen by a watcher - before its filters - by namespace and phase.",
+	}, []string{"watcher", "namespace", "phase"})
+
+	notifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "notifications_total",
+		Help:      "Number of notifications sent by a notifier, by result: sent, failed or dry-run.",
+	}, []string{"notifier", "result"})
+
+	flowdockRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "flowdock_request_duration_seconds",
+		Help:      "Latency of the requests to the Flowdock API, by notifier.",
+	}, []string{"notifier"})
+
+	mutedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "muted_events_total",
+		Help:      "Number of events dropped because their notifier was muted through the admin API.",
+	}, []string{"notifier"})
+
+	templateErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "template_errors_total",
+		Help:      "Number of templates of a notifier which failed to render, by template: subject, content or tag.",
+	}, []string{"notifier", "template"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsReceived)
+	prometheus.MustRegister(eventsAccepted)
+	prometheus.MustRegister(eventsRejected)
+	prometheus.MustRegister(buildOutcomes)
+	prometheus.MustRegister(notifications)
+	prometheus.MustRegister(flowdockRequestDuration)
+	prometheus.MustRegister(mutedEvents)
+	prometheus.MustRegister(templateErrors)
+}
+
+// recordNotification counts the result of a notification
+func recordNotification(notifierName string, dryRun bool, err error) {
+	result := "sent"
+	switch {
+	case err != nil:
+		result = "failed"
+	case dryRun:
+		result = "dry-run"
+	}
+	notifications.WithLabelValues(notifierName, result).Inc()
+}
+
+// observeFlowdockRequest records the latency of a request to the Flowdock API, started at the given time
+func observeFlowdockRequest(notifierName string, start time.Time) {
+	flowdockRequestDuration.WithLabelValues(notifierName).Observe(time.Since(start).Seconds())
+}
+
+// RuntimeCollector exposes the state of the running watchers and notifiers - the same state
+// as the health endpoints - as metrics, collected at each scrape
+type RuntimeCollector struct {
+	runtime *Runtime
+
+	watcherConnected *prometheus.Desc
+	watchRestarts    *prometheus.Desc
+	lastEventTime    *prometheus.Desc
+	bufferDepth      *prometheus.Desc
+	droppedEvents    *prometheus.Desc
+	queueDepth       *prometheus.Desc
+	notifierFailing  *prometheus.Desc
+	lastSuccessTime  *prometheus.Desc
+	configured       *prometheus.Desc
+}
+
+func NewRuntimeCollector(runtime *Runtime) *RuntimeCollector {
+	return &RuntimeCollector{
+		runtime: runtime,
+		watcherConnected: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "watcher_connected"),
+			"Whether the watch of a watcher is open (1) or not (0).", []string{"watcher"}, nil),
+		watchRestarts: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "watch_restarts_total"),
+			"Number of times the watch of a watcher has been re-opened.", []string{"watcher"}, nil),
+		lastEventTime: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "watcher_last_event_timestamp_seconds"),
+			"Time of the last event received by a watcher, as a unix timestamp.", []string{"watcher"}, nil),
+		bufferDepth: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "buffer_depth"),
+			"Number of events waiting to be handled by a notifier.", []string{"notifier"}, nil),
+		droppedEvents: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "dropped_events_total"),
+			"Number of events dropped because the buffer of a notifier was full or stopped.", []string{"notifier"}, nil),
+		queueDepth: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "queue_depth"),
+			"Number of deliveries waiting to be sent (or retried) by a Flowdock notifier.", []string{"notifier"}, nil),
+		notifierFailing: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "notifier_failing"),
+			"Whether the last delivery of a notifier failed (1) or not (0).", []string{"notifier"}, nil),
+		lastSuccessTime: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "notifier_last_success_timestamp_seconds"),
+			"Time of the last successful delivery of a notifier, as a unix timestamp.", []string{"notifier"}, nil),
+		configured: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "", "configured"),
+			"Whether a valid configuration has been applied (1) or not (0).", nil, nil),
+	}
+}
+
+func (collector *RuntimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.watcherConnected
+	ch <- collector.watchRestarts
+	ch <- collector.lastEventTime
+	ch <- collector.bufferDepth
+	ch <- collector.droppedEvents
+	ch <- collector.queueDepth
+	ch <- collector.notifierFailing
+	ch <- collector.lastSuccessTime
+	ch <- collector.configured
+}
+
+func (collector *RuntimeCollector) Collect(ch chan<- prometheus.Metric) {
+	health := collector.runtime.Health()
+
+	ch <- prometheus.MustNewConstMetric(collector.configured, prometheus.GaugeValue, boolValue(health.Configured))
+	for name, watcher := range health.Watchers {
+		ch <- prometheus.MustNewConstMetric(collector.watcherConnected, prometheus.GaugeValue, boolValue(watcher.Connected), name)
+		ch <- prometheus.MustNewConstMetric(collector.watchRestarts, prometheus.CounterValue, float64(watcher.Reconnects), name)
+		if watcher.LastEventTime != nil {
+			ch <- prometheus.MustNewConstMetric(collector.lastEventTime, prometheus.GaugeValue, float64(watcher.LastEventTime.Unix()), name)
+		}
+	}
+	for name, notifier := range health.Notifiers {
+		ch <- prometheus.MustNewConstMetric(collector.bufferDepth, prometheus.GaugeValue, float64(notifier.BufferDepth), name)
+		ch <- prometheus.MustNewConstMetric(collector.droppedEvents, prometheus.CounterValue, float64(notifier.Dropped), name)
+		ch <- prometheus.MustNewConstMetric(collector.queueDepth, prometheus.GaugeValue, float64(notifier.QueueDepth), name)
+		ch <- prometheus.MustNewConstMetric(collector.notifierFailing, prometheus.GaugeValue, boolValue(notifier.FailingSince != nil), name)
+		if notifier.LastSuccessTime != nil {
+			ch <- prometheus.MustNewConstMetric(collector.lastSuccessTime, prometheus.GaugeValue, float64(notifier.LastSuccessTime.Unix()), name)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
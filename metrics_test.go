@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+	"github.com/prometheus/client_golang/prometheus"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/types"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+func TestMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newBuild := func(namespace, name string, phase buildapi.BuildPhase) *buildapi.Build {
+		return &buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(namespace + "/" + name)},
+			Status:     buildapi.BuildStatus{Phase: phase},
+		}
+	}
+	events := []watch.Event{
+		{Type: watch.Added, Object: newBuild("prod", "app-1", buildapi.BuildPhaseNew)},
+		{Type: watch.Modified, Object: newBuild("prod", "app-1", buildapi.BuildPhaseFailed)},
+		{Type: watch.Modified, Object: newBuild("prod", "app-1", buildapi.BuildPhaseFailed)},
+		{Type: watch.Modified, Object: newBuild("dev", "app-2", buildapi.BuildPhaseComplete)},
+		{Type: watch.Modified, Object: newBuild("dev", "app-3", buildapi.BuildPhaseCancelled)},
+		{Type: watch.Deleted, Object: newBuild("prod", "app-1", buildapi.BuildPhaseFailed)},
+	}
+
+	recordingPath := filepath.Join(dir, "events.jsonl")
+	recorder, err := NewEventRecorder(recordingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if err := recorder.Record("metrics-test", event); err != nil {
+			t.Fatalf("Failed to record an event: %v", err)
+		}
+	}
+	recorder.Close()
+	replay, err := NewReplay(recordingPath, 0)
+	if err != nil {
+		t.Fatalf("Failed to read the recording: %v", err)
+	}
+
+	config := &AppConfig{
+		BuildsWatchers: map[string]*BuildsWatcherConfig{
+			"metrics-test": {AllNamespaces: true, Notifiers: []string{"metrics-test"}},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"metrics-test": {Type: "json", Output: filepath.Join(dir, "notifications.jsonl")},
+		},
+		Routing: RoutingConfig{DefaultNotifiers: []string{"metrics-test"}},
+	}
+	config.SetDefaults()
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error, 1))
+	runtime.Replay = replay
+	if err := runtime.Apply(config); err != nil {
+		t.Fatalf("Failed to apply the configuration: %v", err)
+	}
+	runtime.Start()
+	runtime.Drain()
+
+	server, err := NewHttpServer(HttpConfig{WatchTimeout: "5m", NotifierFailureTimeout: "15m"}, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prometheus.Register(NewRuntimeCollector(runtime)); err != nil {
+		t.Fatal(err)
+	}
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := httptest.NewRecorder()
+	server.Mux.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("Expected status code '%v' but got '%v'", http.StatusOK, response.Code)
+	}
+	metrics := response.Body.String()
+
+	expectedMetrics := []string{
+		`flowdock_notifier_events_received_total{type="ADDED",watcher="metrics-test"} 1`,
+		`flowdock_notifier_events_received_total{type="MODIFIED",watcher="metrics-test"} 4`,
+		`flowdock_notifier_events_received_total{type="DELETED",watcher="metrics-test"} 1`,
+		`flowdock_notifier_events_accepted_total{watcher="metrics-test"} 2`,
+		`flowdock_notifier_events_rejected_total{reason="phase",watcher="metrics-test"} 2`,
+		`flowdock_notifier_events_rejected_total{reason="duplicate",watcher="metrics-test"} 1`,
+		`flowdock_notifier_events_rejected_total{reason="event-type",watcher="metrics-test"} 1`,
+		`flowdock_notifier_builds_total{namespace="prod",phase="Failed",watcher="metrics-test"} 1`,
+		`flowdock_notifier_builds_total{namespace="dev",phase="Complete",watcher="metrics-test"} 1`,
+		`flowdock_notifier_builds_total{namespace="dev",phase="Cancelled",watcher="metrics-test"} 1`,
+		`flowdock_notifier_notifications_total{notifier="metrics-test",result="sent"} 2`,
+		`flowdock_notifier_configured 1`,
+	}
+	for count, expected := range expectedMetrics {
+		if !strings.Contains(metrics, expected+"\n") {
+			t.Errorf("Test[%d] Failed: Expected the metric '%v' in the response", count, expected)
+		}
+	}
+	if t.Failed() {
+		t.Logf("Metrics: %s", metrics)
+	}
+}
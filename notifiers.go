@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"fmt"
 	"text/template"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/wm/go-flowdock/flowdock"
 )
 
+const (
+	FlowdockNotifierType = "flowdock"
+	JsonNotifierType     = "json"
+)
+
 const (
 	DefaultNotifierName       = "default"
 	DefaultSuccessFromAddress = "build+ok@flowdock.com"
@@ -48,16 +54,52 @@ const (
 </dl>`
 )
 
-type FlowdockNotifier struct {
-	Config          FlowdockNotifierConfig
-	Channel         chan Event
-	FlowdockClient  *flowdock.Client
-	SubjectTemplate *template.Template
-	ContentTemplate *template.Template
-	TagsTemplates   []*template.Template
+// Notifier sends notifications for the events received on its channel
+// Run returns once the channel has been closed, and the last event has been handled.
+type Notifier interface {
+	Channel() chan Event
+	Run()
+}
+
+// Sender is implemented by the notifiers which can send an event synchronously, without buffering nor retries
+// - such as a test notification
+type Sender interface {
+	Send(event Event) error
+}
+
+// NewNotifier returns a new Notifier of the type defined in the given config
+func NewNotifier(name string, config NotifierConfig) (Notifier, error) {
+	switch config.Type {
+	case FlowdockNotifierType:
+		return NewFlowdockNotifier(name, config)
+	case JsonNotifierType:
+		return NewJsonNotifier(name, config)
+	default:
+		return nil, fmt.Errorf("unknown notifier type %s", config.Type)
+	}
+}
+
+// Message is the result of the rendering of an event with the notifier templates
+type Message struct {
+	Subject string
+	Content string
+	Tags    []string
+}
+
+// MessageTemplates holds the compiled subject, content and tags templates of a notifier
+type MessageTemplates struct {
+	// Name is the name of the notifier, used to count the render errors
+	Name                  string
+	SubjectTemplate       *template.Template
+	ContentTemplate       *template.Template
+	DigestSubjectTemplate *template.Template
+	DigestContentTemplate *template.Template
+	ReportSubjectTemplate *template.Template
+	ReportContentTemplate *template.Template
+	TagsTemplates         []*template.Template
 }
 
-func NewFlowdockNotifier(config FlowdockNotifierConfig) (*FlowdockNotifier, error) {
+func NewMessageTemplates(config NotifierConfig) (*MessageTemplates, error) {
 	subjectTemplate, err := template.New("subject").Parse(config.SubjectTemplate)
 	if err != nil {
 		return nil, err
@@ -68,6 +110,26 @@ func NewFlowdockNotifier(config FlowdockNotifierConfig) (*FlowdockNotifier, erro
 		return nil, err
 	}
 
+	digestSubjectTemplate, err := template.New("digest-subject").Parse(config.DigestSubjectTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	digestContentTemplate, err := template.New("digest-content").Parse(config.DigestContentTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	reportSubjectTemplate, err := template.New("report-subject").Parse(config.ReportSubjectTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	reportContentTemplate, err := template.New("report-content").Parse(config.ReportContentTemplate)
+	if err != nil {
+		return nil, err
+	}
+
 	tagsTemplates := []*template.Template{}
 	for i, tagTmpl := range config.Tags {
 		tmpl, err := template.New(fmt.Sprintf("tag-%d", i)).Parse(tagTmpl)
@@ -77,50 +139,130 @@ func NewFlowdockNotifier(config FlowdockNotifierConfig) (*FlowdockNotifier, erro
 		tagsTemplates = append(tagsTemplates, tmpl)
 	}
 
+	return &MessageTemplates{
+		SubjectTemplate:       subjectTemplate,
+		ContentTemplate:       contentTemplate,
+		DigestSubjectTemplate: digestSubjectTemplate,
+		DigestContentTemplate: digestContentTemplate,
+		ReportSubjectTemplate: reportSubjectTemplate,
+		ReportContentTemplate: reportContentTemplate,
+		TagsTemplates:         tagsTemplates,
+	}, nil
+}
+
+// Render executes the templates against the given event
+// (or the digest/report templates, if the event is a digest/report)
+// Tags templates that fail are ignored
+func (templates *MessageTemplates) Render(event Event) (*Message, error) {
+	subjectTemplate, contentTemplate := templates.SubjectTemplate, templates.ContentTemplate
+	switch event.(type) {
+	case *DigestEvent:
+		subjectTemplate, contentTemplate = templates.DigestSubjectTemplate, templates.DigestContentTemplate
+	case *BuildsReport:
+		subjectTemplate, contentTemplate = templates.ReportSubjectTemplate, templates.ReportContentTemplate
+	}
+
+	subject, err := executeTemplate(subjectTemplate, event)
+	if err != nil {
+		templateErrors.WithLabelValues(templates.Name, "subject").Inc()
+		return nil, err
+	}
+	content, err := executeTemplate(contentTemplate, event)
+	if err != nil {
+		templateErrors.WithLabelValues(templates.Name, "content").Inc()
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, tagTmpl := range templates.TagsTemplates {
+		tag, err := executeTemplate(tagTmpl, event)
+		if err != nil {
+			templateErrors.WithLabelValues(templates.Name, "tag").Inc()
+			glog.Warningf("Ignoring tag template: %v", err)
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	return &Message{
+		Subject: subject,
+		Content: content,
+		Tags:    tags,
+	}, nil
+}
+
+type FlowdockNotifier struct {
+	Config         NotifierConfig
+	Templates      *MessageTemplates
+	FlowdockClient *flowdock.Client
+	Queue          *DeliveryQueue
+	// TokenSource is optional: if set, it returns the token to use for the given namespace
+	// instead of the token of the config
+	TokenSource func(namespace string) (string, error)
+	// Status records the results of the deliveries, for the health endpoints
+	Status  *NotifierStatus
+	channel chan Event
+}
+
+func NewFlowdockNotifier(name string, config NotifierConfig) (*FlowdockNotifier, error) {
+	templates, err := NewMessageTemplates(config)
+	if err != nil {
+		return nil, err
+	}
+	templates.Name = name
+
 	notifier := &FlowdockNotifier{
-		Config:          config,
-		Channel:         make(chan Event),
-		FlowdockClient:  flowdock.NewClient(nil),
-		SubjectTemplate: subjectTemplate,
-		ContentTemplate: contentTemplate,
-		TagsTemplates:   tagsTemplates,
+		Config:         config,
+		Templates:      templates,
+		FlowdockClient: flowdock.NewClient(nil),
+		Status:         &NotifierStatus{},
+		channel:        make(chan Event),
+	}
+
+	queueConfig := config
+	if config.DryRun {
+		// the stored deliveries are left untouched, for the notifier which will send them
+		queueConfig.QueueDir = ""
+		queueConfig.DeadLetterPath = ""
+	}
+	notifier.Queue, err = NewDeliveryQueue(name, queueConfig, notifier.sendNotification)
+	if err != nil {
+		return nil, err
 	}
+
 	return notifier, nil
 }
 
+func (notifier *FlowdockNotifier) Channel() chan Event {
+	return notifier.channel
+}
+
 func (notifier *FlowdockNotifier) Run() {
+	go notifier.Queue.Run()
+
 	for {
-		event, open := <-notifier.Channel
+		event, open := <-notifier.channel
 
 		if !open {
-			glog.Errorf("Flowdock Channel has been closed!")
+			// the queue keeps sending the pending deliveries, until it is stopped or handed over
+			glog.V(1).Infof("Flowdock Channel has been closed, %d deliveries are still pending", notifier.Queue.Len())
 			break
 		}
 
-		if err := notifier.sendNotification(event); err != nil {
-			glog.Errorf("Failed to send an inbox message to Flowdock: %v", err)
+		options, err := notifier.buildInboxMessage(event)
+		if err != nil {
+			glog.Errorf("Failed to build an inbox message for Flowdock: %v", err)
+			continue
 		}
+		notifier.Queue.Enqueue(*options)
 	}
 }
 
-func (notifier *FlowdockNotifier) sendNotification(event Event) error {
-	subject, err := executeTemplate(notifier.SubjectTemplate, event)
+// buildInboxMessage renders the event into a Flowdock inbox message
+func (notifier *FlowdockNotifier) buildInboxMessage(event Event) (*flowdock.InboxCreateOptions, error) {
+	message, err := notifier.Templates.Render(event)
 	if err != nil {
-		return err
-	}
-	content, err := executeTemplate(notifier.ContentTemplate, event)
-	if err != nil {
-		return err
-	}
-
-	tags := []string{}
-	for _, tagTmpl := range notifier.TagsTemplates {
-		tag, err := executeTemplate(tagTmpl, event)
-		if err != nil {
-			glog.Warningf("Ignoring tag template: %v", err)
-			continue
-		}
-		tags = append(tags, tag)
+		return nil, err
 	}
 
 	fromAddress := notifier.Config.FromAddress
@@ -131,20 +273,59 @@ func (notifier *FlowdockNotifier) sendNotification(event Event) error {
 		fromAddress = DefaultFailureFromAddress
 	}
 
-	glog.V(2).Infof("Sending an inbox message to Flowdock...")
-	_, resp, err := notifier.FlowdockClient.Inbox.Create(notifier.Config.Token, &flowdock.InboxCreateOptions{
+	return &flowdock.InboxCreateOptions{
 		Source:      notifier.Config.Source,
 		Project:     event.Namespace(),
 		FromAddress: fromAddress,
 		FromName:    notifier.Config.FromName,
-		Subject:     subject,
-		Content:     content,
-		Tags:        tags,
+		Subject:     message.Subject,
+		Content:     message.Content,
+		Tags:        message.Tags,
 		Link:        event.Url(),
-	})
+	}, nil
+}
+
+// Send sends the event immediately, without the delivery queue
+func (notifier *FlowdockNotifier) Send(event Event) error {
+	options, err := notifier.buildInboxMessage(event)
 	if err != nil {
 		return err
 	}
+	return notifier.sendNotification(&Delivery{Options: *options})
+}
+
+// sendNotification sends the delivery, and records the result in the status and the metrics of the notifier
+func (notifier *FlowdockNotifier) sendNotification(delivery *Delivery) error {
+	err := notifier.send(delivery)
+	notifier.Status.RecordDelivery(delivery.Options.Project, delivery.Options.Subject, err)
+	recordNotification(notifier.Queue.Name, notifier.Config.DryRun, err)
+	return err
+}
+
+func (notifier *FlowdockNotifier) send(delivery *Delivery) error {
+	if notifier.Config.DryRun {
+		glog.Infof("[dry-run] Notifier %s would have sent an inbox message to Flowdock: %+v", notifier.Queue.Name, delivery.Options)
+		return nil
+	}
+
+	token := notifier.Config.Token
+	if notifier.TokenSource != nil {
+		var err error
+		token, err = notifier.TokenSource(delivery.Options.Project)
+		if err != nil {
+			// the Secret may be created a bit later, or the API may be briefly unavailable:
+			// the token is read again - and the Secrets listed again if needed - on the next attempt
+			return &RetryableError{Err: fmt.Errorf("failed to read the token: %v", err)}
+		}
+	}
+
+	glog.V(2).Infof("Sending an inbox message to Flowdock...")
+	start := time.Now()
+	_, resp, err := notifier.FlowdockClient.Inbox.Create(token, &delivery.Options)
+	observeFlowdockRequest(notifier.Queue.Name, start)
+	if err != nil {
+		return redactError(classifyFlowdockError(resp, err), token)
+	}
 	glog.V(2).Infof("Successfully sent an inbox message to Flowdock. Response is: %+v", resp)
 	return nil
 }
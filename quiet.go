@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// QuietModeHold holds the events during the window, and sends them as a digest when it ends
+	QuietModeHold = "hold"
+	// QuietModeDrop drops the events during the window
+	QuietModeDrop = "drop"
+
+	// QuietUntilAnnotation is the annotation on a namespace that enables a quiet window until the given time (RFC3339)
+	QuietUntilAnnotation = "flowdock-notifier/quiet-until"
+	// QuietModeAnnotation is the annotation on a namespace that defines the mode of its quiet window
+	QuietModeAnnotation = "flowdock-notifier/quiet-mode"
+
+	DefaultQuietNamespaceCacheTTL = 30 * time.Second
+)
+
+// QuietWindow is a time window during which the non-failure events are dropped or held
+// It is either recurring (a cron schedule for its start, and a duration)
+// or a one-off window (a start and an end time).
+type QuietWindow struct {
+	Name     string
+	Mode     string
+	Schedule *CronSchedule
+	Duration time.Duration
+	Start    time.Time
+	End      time.Time
+}
+
+func NewQuietWindow(name string, config QuietWindowConfig) (*QuietWindow, error) {
+	window := &QuietWindow{
+		Name: name,
+		Mode: config.Mode,
+	}
+
+	switch config.Mode {
+	case QuietModeHold, QuietModeDrop:
+	default:
+		return nil, fmt.Errorf("unknown mode %s for quiet window %s", config.Mode, name)
+	}
+
+	if len(config.Schedule) > 0 {
+		schedule, err := ParseCronSchedule(config.Schedule, config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule for quiet window %s: %v", name, err)
+		}
+		duration, err := time.ParseDuration(config.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %s for quiet window %s: %v", config.Duration, name, err)
+		}
+		window.Schedule = schedule
+		window.Duration = duration
+		return window, nil
+	}
+
+	var err error
+	if window.Start, err = time.Parse(time.RFC3339, config.Start); err != nil {
+		return nil, fmt.Errorf("quiet window %s needs either a schedule, or a start and an end time: invalid start %s: %v", name, config.Start, err)
+	}
+	if window.End, err = time.Parse(time.RFC3339, config.End); err != nil {
+		return nil, fmt.Errorf("invalid end %s for quiet window %s: %v", config.End, name, err)
+	}
+	return window, nil
+}
+
+// ActiveUntil returns the end of the window if it is active at the given time, or the zero time
+func (window *QuietWindow) ActiveUntil(t time.Time) time.Time {
+	if window.Schedule == nil {
+		if !t.Before(window.Start) && t.Before(window.End) {
+			return window.End
+		}
+		return time.Time{}
+	}
+
+	// look for the occurrences that started during the last Duration
+	// they may overlap, if the duration is longer than the interval between 2 occurrences
+	var until time.Time
+	for start := window.Schedule.Next(t.Add(-window.Duration - time.Minute)); !start.IsZero() && !start.After(t); start = window.Schedule.Next(start) {
+		if end := start.Add(window.Duration); end.After(t) && end.After(until) {
+			until = end
+		}
+	}
+	return until
+}
+
+// QuietGate applies quiet windows to a flow of events:
+// outside of the windows - and for the failures - the events are sent as-is,
+// and during a window they are either dropped or held, and sent as a digest when the window ends.
+type QuietGate struct {
+	Windows []*QuietWindow
+	// NamespaceWindow is optional: it returns the end and the mode of the ad-hoc window of a namespace
+	NamespaceWindow func(namespace string) (time.Time, string)
+
+	out   func(Event)
+	mutex sync.Mutex
+	held  map[string]*heldEvents
+}
+
+type heldEvents struct {
+	events []Event
+	start  time.Time
+	until  time.Time
+}
+
+func NewQuietGate(configs []QuietWindowConfig, out func(Event)) (*QuietGate, error) {
+	gate := &QuietGate{
+		out:  out,
+		held: make(map[string]*heldEvents),
+	}
+	for i, config := range configs {
+		name := config.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", i)
+		}
+		window, err := NewQuietWindow(name, config)
+		if err != nil {
+			return nil, err
+		}
+		gate.Windows = append(gate.Windows, window)
+	}
+	return gate, nil
+}
+
+// Handle sends, drops or holds the given event, depending on the active windows
+// The failures, the reports and the digests are always sent.
+func (gate *QuietGate) Handle(event Event) {
+	if event.IsFailure() || isSummaryEvent(event) {
+		gate.out(event)
+		return
+	}
+
+	now := time.Now()
+	key, until, mode := gate.activeWindow(event, now)
+	if until.IsZero() {
+		gate.out(event)
+		return
+	}
+
+	if mode == QuietModeDrop {
+		glog.V(2).Infof("Dropping event for %s %s/%s during quiet window %s", event.ObjectType(), event.Namespace(), event.Name(), key)
+		return
+	}
+
+	glog.V(2).Infof("Holding event for %s %s/%s until the end of quiet window %s at %v", event.ObjectType(), event.Namespace(), event.Name(), key, until)
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+	group, found := gate.held[key]
+	if !found {
+		group = &heldEvents{start: now}
+		gate.held[key] = group
+	}
+	group.events = append(group.events, event)
+	if until.After(group.until) {
+		group.until = until
+		time.AfterFunc(until.Sub(now), func() {
+			gate.release(key)
+		})
+	}
+}
+
+// activeWindow returns the key, end and mode of the active window for the given event
+// drop windows win over hold windows, and the latest end wins between windows of the same mode
+func (gate *QuietGate) activeWindow(event Event, now time.Time) (string, time.Time, string) {
+	var (
+		key   string
+		until time.Time
+		mode  string
+	)
+	consider := func(windowKey string, windowUntil time.Time, windowMode string) {
+		if windowUntil.IsZero() {
+			return
+		}
+		if mode == QuietModeDrop && windowMode != QuietModeDrop {
+			return
+		}
+		if windowMode == mode && !windowUntil.After(until) {
+			return
+		}
+		key, until, mode = windowKey, windowUntil, windowMode
+	}
+
+	for _, window := range gate.Windows {
+		consider(window.Name, window.ActiveUntil(now), window.Mode)
+	}
+	if gate.NamespaceWindow != nil && len(event.Namespace()) > 0 {
+		if nsUntil, nsMode := gate.NamespaceWindow(event.Namespace()); nsUntil.After(now) {
+			consider("namespace:"+event.Namespace(), nsUntil, nsMode)
+		}
+	}
+	return key, until, mode
+}
+
+// release sends the events held for the given window, if it has ended
+func (gate *QuietGate) release(key string) {
+	gate.mutex.Lock()
+	group, found := gate.held[key]
+	if !found || time.Now().Before(group.until) {
+		// the window has been extended
+		gate.mutex.Unlock()
+		return
+	}
+	delete(gate.held, key)
+	gate.mutex.Unlock()
+
+	glog.V(1).Infof("Quiet window %s has ended, sending the %d held events", key, len(group.events))
+	if len(group.events) == 1 {
+		gate.out(group.events[0])
+		return
+	}
+	gate.out(NewDigestEvent(group.events, group.start, time.Now()))
+}
+
+// ReleaseAll sends all the held events right away, without waiting for the end of their windows
+func (gate *QuietGate) ReleaseAll() {
+	gate.mutex.Lock()
+	held := gate.held
+	gate.held = make(map[string]*heldEvents)
+	gate.mutex.Unlock()
+
+	for key, group := range held {
+		glog.V(1).Infof("Sending the %d events held for quiet window %s before its end", len(group.events), key)
+		if len(group.events) == 1 {
+			gate.out(group.events[0])
+			continue
+		}
+		gate.out(NewDigestEvent(group.events, group.start, time.Now()))
+	}
+}
+
+// HandOver moves the held events to the given gate, which sends them at the end of their windows
+func (gate *QuietGate) HandOver(next *QuietGate) {
+	gate.mutex.Lock()
+	held := gate.held
+	gate.held = make(map[string]*heldEvents)
+	gate.mutex.Unlock()
+
+	now := time.Now()
+	next.mutex.Lock()
+	defer next.mutex.Unlock()
+	for key, group := range held {
+		if existing, found := next.held[key]; found {
+			existing.events = append(existing.events, group.events...)
+			if group.start.Before(existing.start) {
+				existing.start = group.start
+			}
+			if !group.until.After(existing.until) {
+				continue
+			}
+			existing.until = group.until
+		} else {
+			next.held[key] = group
+		}
+		releaseKey := key
+		time.AfterFunc(group.until.Sub(now), func() {
+			next.release(releaseKey)
+		})
+	}
+}
+
+// NamespaceQuietWindows reads the ad-hoc quiet windows from the annotations of the namespaces
+// The annotations are cached for a short time, to avoid a request per event.
+type NamespaceQuietWindows struct {
+	TTL time.Duration
+
+	factory clientcmd.Factory
+	mutex   sync.Mutex
+	entries map[string]*namespaceQuietWindow
+}
+
+type namespaceQuietWindow struct {
+	fetchedAt time.Time
+	until     time.Time
+	mode      string
+}
+
+func NewNamespaceQuietWindows(factory clientcmd.Factory) *NamespaceQuietWindows {
+	return &NamespaceQuietWindows{
+		TTL:     DefaultQuietNamespaceCacheTTL,
+		factory: factory,
+		entries: make(map[string]*namespaceQuietWindow),
+	}
+}
+
+// Window returns the end and the mode of the quiet window of the given namespace
+// or the zero time if the namespace has no quiet window
+func (windows *NamespaceQuietWindows) Window(namespace string) (time.Time, string) {
+	windows.mutex.Lock()
+	defer windows.mutex.Unlock()
+
+	if entry, found := windows.entries[namespace]; found && time.Since(entry.fetchedAt) < windows.TTL {
+		return entry.until, entry.mode
+	}
+
+	entry := &namespaceQuietWindow{
+		fetchedAt: time.Now(),
+	}
+	windows.entries[namespace] = entry
+
+	_, kclient, err := windows.factory.Clients()
+	if err != nil {
+		glog.Warningf("Can't get kube client to read the quiet window of namespace %s: %v", namespace, err)
+		return entry.until, entry.mode
+	}
+	ns, err := kclient.Namespaces().Get(namespace)
+	if err != nil {
+		glog.Warningf("Failed to read the quiet window of namespace %s: %v", namespace, err)
+		return entry.until, entry.mode
+	}
+
+	if value, found := ns.Annotations[QuietUntilAnnotation]; found {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			glog.Warningf("Ignoring invalid %s annotation %s on namespace %s: %v", QuietUntilAnnotation, value, namespace, err)
+			return entry.until, entry.mode
+		}
+		entry.until = until
+		entry.mode = QuietModeHold
+		if ns.Annotations[QuietModeAnnotation] == QuietModeDrop {
+			entry.mode = QuietModeDrop
+		}
+	}
+	return entry.until, entry.mode
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuietWindowActiveUntil(t *testing.T) {
+	night, err := NewQuietWindow("night", QuietWindowConfig{Schedule: "0 22 * * *", Duration: "9h", Timezone: "UTC", Mode: QuietModeHold})
+	if err != nil {
+		t.Fatalf("Failed to create the window: %v", err)
+	}
+	maintenance, err := NewQuietWindow("maintenance", QuietWindowConfig{Start: "2016-03-10T12:00:00Z", End: "2016-03-10T14:00:00Z", Mode: QuietModeDrop})
+	if err != nil {
+		t.Fatalf("Failed to create the window: %v", err)
+	}
+
+	tests := []struct {
+		window        *QuietWindow
+		time          string
+		expectedUntil string
+	}{
+		{window: night, time: "2016-03-10T21:59:00Z", expectedUntil: ""},
+		{window: night, time: "2016-03-10T22:00:00Z", expectedUntil: "2016-03-11T07:00:00Z"},
+		{window: night, time: "2016-03-11T03:30:00Z", expectedUntil: "2016-03-11T07:00:00Z"},
+		{window: night, time: "2016-03-11T07:00:00Z", expectedUntil: ""},
+		{window: maintenance, time: "2016-03-10T11:59:59Z", expectedUntil: ""},
+		{window: maintenance, time: "2016-03-10T13:00:00Z", expectedUntil: "2016-03-10T14:00:00Z"},
+		{window: maintenance, time: "2016-03-10T14:00:00Z", expectedUntil: ""},
+	}
+
+	for count, test := range tests {
+		now, _ := time.Parse(time.RFC3339, test.time)
+		var expectedUntil time.Time
+		if len(test.expectedUntil) > 0 {
+			expectedUntil, _ = time.Parse(time.RFC3339, test.expectedUntil)
+		}
+		result := test.window.ActiveUntil(now)
+		if !result.Equal(expectedUntil) {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, expectedUntil, result)
+		}
+	}
+}
+
+func TestNewQuietWindowErrors(t *testing.T) {
+	configs := []QuietWindowConfig{
+		{Mode: "unknown", Schedule: "@daily", Duration: "1h"},
+		{Mode: QuietModeHold, Schedule: "@daily"},
+		{Mode: QuietModeHold, Schedule: "@daily", Duration: "1h", Timezone: "Nowhere/Unknown"},
+		{Mode: QuietModeHold},
+		{Mode: QuietModeHold, Start: "2016-03-10T12:00:00Z"},
+	}
+
+	for count, config := range configs {
+		if _, err := NewQuietWindow("test", config); err == nil {
+			t.Errorf("Test[%d] Failed: Expected an error for config %+v", count, config)
+		}
+	}
+}
+
+func TestQuietGate(t *testing.T) {
+	var mutex sync.Mutex
+	sent := []Event{}
+	gate, err := NewQuietGate(nil, func(event Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		sent = append(sent, event)
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the gate: %v", err)
+	}
+	now := time.Now()
+	gate.Windows = []*QuietWindow{
+		{Name: "hold", Mode: QuietModeHold, Start: now.Add(-time.Minute), End: now.Add(50 * time.Millisecond)},
+	}
+	gate.NamespaceWindow = func(namespace string) (time.Time, string) {
+		if namespace == "maintenance" {
+			return now.Add(time.Hour), QuietModeDrop
+		}
+		return time.Time{}, ""
+	}
+
+	gate.Handle(&testEvent{namespace: "a", name: "a-1", status: "Complete", success: true})
+	gate.Handle(&testEvent{namespace: "a", name: "a-2", status: "Failed", failure: true})
+	gate.Handle(&testEvent{namespace: "b", name: "b-1", status: "Complete", success: true})
+	gate.Handle(&testEvent{namespace: "maintenance", name: "m-1", status: "Complete", success: true})
+
+	mutex.Lock()
+	// the failure should not be held
+	if len(sent) != 1 || sent[0].Name() != "a-2" {
+		t.Fatalf("Expected the failure to be sent immediately, but got %v", sent)
+	}
+	mutex.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(sent) != 2 {
+		t.Fatalf("Expected the held events to be sent as a digest at the end of the window, but got %v", sent)
+	}
+	digest, isDigest := sent[1].(*DigestEvent)
+	if !isDigest {
+		t.Fatalf("Expected a digest, but got %T", sent[1])
+	}
+	// the event of the "maintenance" namespace should have been dropped
+	if len(digest.Items()) != 2 || digest.Items()[0].Name != "a-1" || digest.Items()[1].Name != "b-1" {
+		t.Errorf("Unexpected digest %+v", digest.Items())
+	}
+}
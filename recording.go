@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/golang/glog"
+)
+
+// RecordedEvent is a line of a recording: a watch event received by a builds watcher
+type RecordedEvent struct {
+	Time    time.Time       `json:"time"`
+	Watcher string          `json:"watcher"`
+	Type    watch.EventType `json:"type"`
+	// Object is the v1 JSON representation of the build
+	Object json.RawMessage `json:"object"`
+}
+
+// EventRecorder appends the watch events received by the builds watchers to a file, as JSON lines
+// The recording can be replayed later - without a cluster - to reproduce the notifications.
+type EventRecorder struct {
+	Path  string
+	file  *os.File
+	mutex sync.Mutex
+}
+
+func NewEventRecorder(path string) (*EventRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &EventRecorder{
+		Path: path,
+		file: file,
+	}, nil
+}
+
+// Record appends the given event, received by the given watcher
+func (recorder *EventRecorder) Record(watcherName string, event watch.Event) error {
+	object, err := kapi.Scheme.EncodeToVersion(event.Object, "v1")
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(RecordedEvent{
+		Time:    time.Now(),
+		Watcher: watcherName,
+		Type:    event.Type,
+		Object:  object,
+	})
+	if err != nil {
+		return err
+	}
+
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	_, err = recorder.file.Write(append(line, '\n'))
+	return err
+}
+
+func (recorder *EventRecorder) Close() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return recorder.file.Close()
+}
+
+// Replay feeds the events of a recording to the builds watchers, instead of watching the API:
+// each watcher replays the events recorded by the watcher with the same name.
+type Replay struct {
+	Events []RecordedEvent
+	// Speed is the replay speed: 1 replays the events at the pace they were recorded,
+	// 10 replays them 10 times faster, and 0 replays them as fast as possible
+	Speed float64
+
+	start     time.Time
+	startOnce sync.Once
+}
+
+// NewReplay reads the recording at the given path
+func NewReplay(path string, speed float64) (*Replay, error) {
+	if speed < 0 {
+		return nil, fmt.Errorf("invalid replay speed %v: it can't be negative", speed)
+	}
+	events, err := ReadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replay{
+		Events: events,
+		Speed:  speed,
+	}, nil
+}
+
+// ReadRecording reads the events of the recording at the given path - checking that they can be decoded
+func ReadRecording(path string) ([]RecordedEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []RecordedEvent{}
+	scanner := bufio.NewScanner(file)
+	// a build can be larger than the default max line size
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event RecordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("invalid event at line %d of %s: %v", lineNumber, path, err)
+		}
+		if _, err := event.WatchEvent(); err != nil {
+			return nil, fmt.Errorf("invalid event at line %d of %s: %v", lineNumber, path, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return events, nil
+}
+
+// WatchEvent decodes the recorded event
+func (event RecordedEvent) WatchEvent() (watch.Event, error) {
+	obj, err := kapi.Scheme.Decode(event.Object)
+	if err != nil {
+		return watch.Event{}, err
+	}
+	if _, isBuild := obj.(*buildapi.Build); !isBuild {
+		return watch.Event{}, fmt.Errorf("the object is a %T, not a Build", obj)
+	}
+	return watch.Event{
+		Type:   event.Type,
+		Object: obj,
+	}, nil
+}
+
+// Watchers returns the names of the watchers which recorded the events
+func (replay *Replay) Watchers() []string {
+	names := make(map[string]bool)
+	for _, event := range replay.Events {
+		names[event.Watcher] = true
+	}
+	return sortedKeys(names)
+}
+
+// Run calls the callback for each event recorded by the given watcher, at the replay speed
+// The pace is relative to the first event of the recording, and to the first watcher that started,
+// so that the events of different watchers are replayed in the same order as they were recorded.
+// It returns once all the events have been replayed, or the stop channel is closed.
+func (replay *Replay) Run(watcherName string, stop <-chan struct{}, callback func(watch.Event)) error {
+	replay.startOnce.Do(func() {
+		replay.start = time.Now()
+	})
+
+	count := 0
+	for _, recorded := range replay.Events {
+		if recorded.Watcher != watcherName {
+			continue
+		}
+		if replay.Speed > 0 {
+			offset := time.Duration(float64(recorded.Time.Sub(replay.Events[0].Time)) / replay.Speed)
+			select {
+			case <-time.After(replay.start.Add(offset).Sub(time.Now())):
+			case <-stop:
+				return nil
+			}
+		} else {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+		}
+
+		event, err := recorded.WatchEvent()
+		if err != nil {
+			return err
+		}
+		glog.V(3).Infof("Replaying event %v recorded at %v", event.Type, recorded.Time)
+		callback(event)
+		count++
+	}
+	glog.Infof("Replayed %d events for watcher %s", count, watcherName)
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/types"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newBuild := func(namespace, name string, phase buildapi.BuildPhase) *buildapi.Build {
+		return &buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(namespace + "/" + name)},
+			Status:     buildapi.BuildStatus{Phase: phase},
+		}
+	}
+	recorded := []struct {
+		watcher string
+		event   watch.Event
+	}{
+		{"all", watch.Event{Type: watch.Added, Object: newBuild("prod", "app-1", buildapi.BuildPhaseNew)}},
+		{"all", watch.Event{Type: watch.Modified, Object: newBuild("prod", "app-1", buildapi.BuildPhaseRunning)}},
+		{"ops", watch.Event{Type: watch.Modified, Object: newBuild("ops", "tool-1", buildapi.BuildPhaseFailed)}},
+		{"all", watch.Event{Type: watch.Modified, Object: newBuild("prod", "app-1", buildapi.BuildPhaseFailed)}},
+		{"all", watch.Event{Type: watch.Modified, Object: newBuild("prod", "app-1", buildapi.BuildPhaseFailed)}},
+		{"all", watch.Event{Type: watch.Modified, Object: newBuild("dev", "app-2", buildapi.BuildPhaseComplete)}},
+		{"all", watch.Event{Type: watch.Deleted, Object: newBuild("prod", "app-1", buildapi.BuildPhaseFailed)}},
+	}
+
+	recordingPath := filepath.Join(dir, "events.jsonl")
+	recorder, err := NewEventRecorder(recordingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recorded {
+		if err := recorder.Record(r.watcher, r.event); err != nil {
+			t.Fatalf("Failed to record an event: %v", err)
+		}
+	}
+	recorder.Close()
+
+	replay, err := NewReplay(recordingPath, 0)
+	if err != nil {
+		t.Fatalf("Failed to read the recording: %v", err)
+	}
+	if len(replay.Events) != len(recorded) {
+		t.Errorf("Expected %d recorded events but got %d", len(recorded), len(replay.Events))
+	}
+	if watchers := replay.Watchers(); !reflect.DeepEqual(watchers, []string{"all", "ops"}) {
+		t.Errorf("Expected the watchers '%v' but got '%v'", []string{"all", "ops"}, watchers)
+	}
+
+	outputPath := filepath.Join(dir, "notifications.jsonl")
+	config := &AppConfig{
+		BuildsWatchers: map[string]*BuildsWatcherConfig{
+			"all": {AllNamespaces: true, Notifiers: []string{"default"}},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"default": {Type: "json", Output: outputPath},
+		},
+	}
+	config.SetDefaults()
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error, 1))
+	runtime.Replay = replay
+	if err := runtime.Apply(config); err != nil {
+		t.Fatalf("Failed to apply the configuration: %v", err)
+	}
+	runtime.Start()
+	runtime.Drain()
+
+	output, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	notified := []string{}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		var line JsonLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("Invalid JSON line %s: %v", scanner.Text(), err)
+		}
+		notified = append(notified, line.Event.Namespace+"/"+line.Event.Name+" "+line.Event.Status+" "+line.Event.NodeName)
+	}
+
+	// only the events of watcher "all", without the duplicate notification of a phase
+	expectedNotified := []string{
+		"prod/app-1 Failed node-1.example.com",
+		"dev/app-2 Complete node-1.example.com",
+	}
+	if !reflect.DeepEqual(notified, expectedNotified) {
+		t.Errorf("Expected the notifications '%v' but got '%v'", expectedNotified, notified)
+	}
+}
+
+// replayNotifications replays the given events of watcher "all" with the given configuration,
+// and returns the JSON lines written by the "json" notifiers, by notifier
+func replayNotifications(t *testing.T, config *AppConfig, events []watch.Event) map[string][]JsonLine {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recordingPath := filepath.Join(dir, "events.jsonl")
+	recorder, err := NewEventRecorder(recordingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if err := recorder.Record("all", event); err != nil {
+			t.Fatalf("Failed to record an event: %v", err)
+		}
+	}
+	recorder.Close()
+	replay, err := NewReplay(recordingPath, 0)
+	if err != nil {
+		t.Fatalf("Failed to read the recording: %v", err)
+	}
+
+	for name, notifierConfig := range config.Notifiers {
+		notifierConfig.Type = "json"
+		notifierConfig.Output = filepath.Join(dir, name+".jsonl")
+	}
+	config.SetDefaults()
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error, 1))
+	runtime.Replay = replay
+	if err := runtime.Apply(config); err != nil {
+		t.Fatalf("Failed to apply the configuration: %v", err)
+	}
+	runtime.Start()
+	runtime.Drain()
+
+	notifications := make(map[string][]JsonLine)
+	for name, notifierConfig := range config.Notifiers {
+		output, err := os.Open(notifierConfig.Output)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			var line JsonLine
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatalf("Invalid JSON line %s: %v", scanner.Text(), err)
+			}
+			notifications[name] = append(notifications[name], line)
+		}
+		output.Close()
+	}
+	return notifications
+}
+
+func TestReplayTransitionsOfFilteredPhases(t *testing.T) {
+	newBuild := func(name string, phase buildapi.BuildPhase) *buildapi.Build {
+		return &buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace: "prod",
+				Name:      name,
+				UID:       types.UID("prod/" + name),
+				Labels:    map[string]string{buildapi.BuildConfigLabel: "app"},
+			},
+			Status: buildapi.BuildStatus{Phase: phase},
+		}
+	}
+	config := &AppConfig{
+		BuildsWatchers: map[string]*BuildsWatcherConfig{
+			// only the successes are notified
+			"all": {
+				AllNamespaces:      true,
+				Notifiers:          []string{"default"},
+				WatchForBuildPhase: map[buildapi.BuildPhase]bool{buildapi.BuildPhaseFailed: false},
+			},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"default": {},
+		},
+	}
+	notifications := replayNotifications(t, config, []watch.Event{
+		{Type: watch.Modified, Object: newBuild("app-1", buildapi.BuildPhaseComplete)},
+		{Type: watch.Modified, Object: newBuild("app-2", buildapi.BuildPhaseFailed)},
+		{Type: watch.Modified, Object: newBuild("app-3", buildapi.BuildPhaseComplete)},
+	})
+
+	notified := []string{}
+	for _, line := range notifications["default"] {
+		notified = append(notified, line.Event.Name+" "+line.Event.Transition)
+	}
+	// the filtered failure is still recorded, so the next success is a fix
+	expectedNotified := []string{"app-1 success", "app-3 fixed"}
+	if !reflect.DeepEqual(notified, expectedNotified) {
+		t.Errorf("Expected the notifications '%v' but got '%v'", expectedNotified, notified)
+	}
+}
+
+func TestReplayBatchesRoutedEvents(t *testing.T) {
+	newBuild := func(namespace, name string, phase buildapi.BuildPhase) *buildapi.Build {
+		return &buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: name, UID: types.UID(namespace + "/" + name)},
+			Status:     buildapi.BuildStatus{Phase: phase},
+		}
+	}
+	config := &AppConfig{
+		BuildsWatchers: map[string]*BuildsWatcherConfig{
+			"all": {AllNamespaces: true, BatchWindow: "1h"},
+		},
+		Routing: RoutingConfig{
+			Rules: []RoutingRuleConfig{
+				{Name: "prod", Expression: `namespace == "prod"`, Notifiers: []string{"prod"}},
+			},
+			DefaultNotifiers: []string{"default"},
+		},
+		Notifiers: map[string]*NotifierConfig{
+			"prod":    {},
+			"default": {},
+		},
+	}
+	notifications := replayNotifications(t, config, []watch.Event{
+		{Type: watch.Modified, Object: newBuild("prod", "app-1", buildapi.BuildPhaseComplete)},
+		{Type: watch.Modified, Object: newBuild("dev", "app-2", buildapi.BuildPhaseComplete)},
+		{Type: watch.Modified, Object: newBuild("prod", "app-3", buildapi.BuildPhaseFailed)},
+	})
+
+	// each notifier receives a digest of its own events - or the event itself
+	tests := []struct {
+		notifier              string
+		expectedNotifications []string
+	}{
+		{notifier: "prod", expectedNotifications: []string{"prod/2 events 1 succeeded, 1 failed"}},
+		{notifier: "default", expectedNotifications: []string{"dev/app-2 Complete"}},
+	}
+	for count, test := range tests {
+		notified := []string{}
+		for _, line := range notifications[test.notifier] {
+			notified = append(notified, line.Event.Namespace+"/"+line.Event.Name+" "+line.Event.Status)
+		}
+		if !reflect.DeepEqual(notified, test.expectedNotifications) {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedNotifications, notified)
+		}
+	}
+}
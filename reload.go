@@ -0,0 +1,470 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+	"gopkg.in/fsnotify.v1"
+)
+
+const (
+	// DrainTimeout is how long Drain waits for the pending deliveries of a Flowdock notifier
+	DrainTimeout = 30 * time.Second
+)
+
+// Runtime runs the notifiers, the watchers and the reports of the current configuration,
+// and reconciles them when a new configuration is applied:
+// the new ones are started, the removed ones are stopped, and the changed ones are restarted.
+// The leader election and the sharding are not reloaded: changing them requires a restart.
+type Runtime struct {
+	Dispatcher    *Dispatcher
+	SecretTokens  *SecretTokens
+	FlowNotifiers *FlowNotifiers
+	// Shards is optional: if set, the watchers and the reports only handle the namespaces owned by this replica
+	Shards *ShardCoordinator
+	// Leader is optional: if set, only the leader runs the watchers and the reports
+	Leader *LeaderElector
+	// ConfigMaps is optional: if set, the configuration of the ConfigMaps is merged into the base configuration
+	ConfigMaps *ConfigMapsSource
+	// Recorder is optional: if set, the watchers record the events they receive
+	Recorder *EventRecorder
+	// Replay is optional: if set, the watchers replay the recorded events instead of watching the API
+	Replay *Replay
+	// DryRun enables the dry-run mode of all the notifiers, whatever their configuration
+	DryRun bool
+
+	factory *clientcmd.Factory
+	errors  chan<- error
+
+	mutex     sync.Mutex
+	config    *AppConfig
+	router    *Router
+	started   bool
+	watchers  map[string]*runningTask
+	reporters map[string]*runningTask
+	// paused holds the watchers paused through the admin API, so that they stay paused when they are restarted
+	paused map[string]bool
+
+	// reloadMutex serializes the reloads, which read the global viper config
+	reloadMutex sync.Mutex
+}
+
+// runningTask is a watcher or a reporter, running in its own goroutine
+type runningTask struct {
+	stop func()
+	done chan struct{}
+	// watcher is only set for the watchers, so that a restarted watcher can resume its state
+	watcher *BuildsWatcher
+}
+
+// NewRuntime returns a new Runtime - the errors of the watchers and the reports are sent to the given channel
+func NewRuntime(factory *clientcmd.Factory, shards *ShardCoordinator, errors chan<- error) *Runtime {
+	dispatcher := NewDispatcher()
+	secretTokens := NewSecretTokens(*factory)
+	return &Runtime{
+		Dispatcher:    dispatcher,
+		SecretTokens:  secretTokens,
+		FlowNotifiers: NewFlowNotifiers(secretTokens, dispatcher, nil),
+		Shards:        shards,
+		factory:       factory,
+		errors:        errors,
+		watchers:      make(map[string]*runningTask),
+		reporters:     make(map[string]*runningTask),
+		paused:        make(map[string]bool),
+	}
+}
+
+// Apply validates the given configuration, and reconciles the running notifiers, watchers and reports with it
+// If the configuration is invalid, an error is returned and nothing is changed.
+func (runtime *Runtime) Apply(config *AppConfig) error {
+	runtime.mutex.Lock()
+	defer runtime.mutex.Unlock()
+
+	if runtime.DryRun {
+		// before the comparison with the previous configuration, which has been changed too
+		for _, notifierConfig := range config.Notifiers {
+			notifierConfig.DryRun = true
+		}
+	}
+
+	previous := runtime.config
+	if previous != nil && reflect.DeepEqual(previous, config) {
+		glog.V(1).Infof("The configuration has not changed")
+		return nil
+	}
+
+	if err := ValidateAppConfig(config); err != nil {
+		return err
+	}
+	if previous != nil {
+		if !reflect.DeepEqual(previous.LeaderElection, config.LeaderElection) || !reflect.DeepEqual(previous.Sharding, config.Sharding) || !reflect.DeepEqual(previous.ConfigMaps, config.ConfigMaps) || !reflect.DeepEqual(previous.Http, config.Http) {
+			glog.Warningf("Ignoring the changes to the leader election, the sharding, the configMaps and the http server: they require a restart")
+		}
+	}
+	router, err := NewRouter(config.Routing)
+	if err != nil {
+		return fmt.Errorf("invalid routing: %v", err)
+	}
+
+	// the notifiers are created last, as they may open files
+	notifiers := make(map[string]Notifier)
+	for notifierName, notifierConfig := range config.Notifiers {
+		if previous != nil && reflect.DeepEqual(previous.Notifiers[notifierName], notifierConfig) {
+			continue
+		}
+		notifier, err := runtime.newNotifier(notifierName, *notifierConfig)
+		if err != nil {
+			for _, created := range notifiers {
+				discardNotifier(created)
+			}
+			return fmt.Errorf("failed to create %s notifier %s: %v", notifierConfig.Type, notifierName, err)
+		}
+		notifiers[notifierName] = notifier
+	}
+
+	for _, notifierName := range sortedKeys(notifiers) {
+		notifier := notifiers[notifierName]
+		if err := runtime.Dispatcher.ReplaceNotifier(notifierName, notifier, *config.Notifiers[notifierName], handOverNotifier(notifierName, notifier)); err != nil {
+			// the buffer settings have already been validated, so this should not happen
+			glog.Errorf("Failed to register notifier %s: %v", notifierName, err)
+			continue
+		}
+		if previous != nil && previous.Notifiers[notifierName] != nil {
+			glog.Infof("Restarted notifier %s", notifierName)
+		} else {
+			glog.Infof("Started notifier %s", notifierName)
+		}
+	}
+	if previous != nil {
+		for notifierName := range previous.Notifiers {
+			if _, found := config.Notifiers[notifierName]; found {
+				continue
+			}
+			if notifier := runtime.Dispatcher.RemoveNotifier(notifierName); notifier != nil {
+				stopNotifier(notifierName, notifier)
+			}
+			glog.Infof("Stopped notifier %s", notifierName)
+		}
+	}
+	runtime.FlowNotifiers.Reload(config.Notifiers)
+
+	// the watchers bind the router when they start, so they all need to be restarted if the routing has changed
+	restartAll := previous == nil ||
+		!reflect.DeepEqual(previous.Routing, config.Routing) ||
+		!reflect.DeepEqual(previous.QuietWindows, config.QuietWindows)
+
+	runtime.config = config
+	runtime.router = router
+	if runtime.started {
+		runtime.reconcileWatchers(previous, restartAll)
+		runtime.reconcileReporters(previous, restartAll)
+	}
+	return nil
+}
+
+// ApplyBase applies the given base configuration - merged with the configuration of the ConfigMaps, if any
+func (runtime *Runtime) ApplyBase(config *AppConfig) error {
+	if runtime.ConfigMaps != nil {
+		return runtime.ConfigMaps.SetBase(config)
+	}
+	return runtime.Apply(config)
+}
+
+// Start starts the watchers and the reports of the current configuration
+// With leader election, only the leader starts them.
+func (runtime *Runtime) Start() {
+	runtime.mutex.Lock()
+	defer runtime.mutex.Unlock()
+
+	runtime.started = true
+	runtime.reconcileWatchers(nil, true)
+	runtime.reconcileReporters(nil, true)
+}
+
+// Drain waits for the watchers to return - which they only do on their own when they replay events -
+// and then stops the notifiers, once they have handled all the dispatched events, and the watch of the Secrets
+func (runtime *Runtime) Drain() {
+	runtime.mutex.Lock()
+	tasks := []*runningTask{}
+	for _, task := range runtime.watchers {
+		tasks = append(tasks, task)
+	}
+	runtime.mutex.Unlock()
+
+	for _, task := range tasks {
+		<-task.done
+	}
+	for _, notifierName := range sortedKeys(runtime.Dispatcher.Buffers()) {
+		notifier := runtime.Dispatcher.RemoveNotifier(notifierName)
+		if flowdockNotifier, isFlowdock := notifier.(*FlowdockNotifier); isFlowdock {
+			// give the queue a chance to send its pending deliveries
+			deadline := time.Now().Add(DrainTimeout)
+			for flowdockNotifier.Queue.Len() > 0 && time.Now().Before(deadline) {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+		if notifier != nil {
+			stopNotifier(notifierName, notifier)
+		}
+	}
+	runtime.SecretTokens.Stop()
+}
+
+// Reload reads the config file again, and applies it
+// An invalid configuration is rejected: the current one keeps running.
+func (runtime *Runtime) Reload() {
+	runtime.reloadMutex.Lock()
+	defer runtime.reloadMutex.Unlock()
+
+	glog.Infof("Reloading the configuration from %s", viper.ConfigFileUsed())
+	// viper may already have read the file, but it doesn't report the errors
+	if err := viper.ReadInConfig(); err != nil {
+		glog.Errorf("Rejected the new configuration, keeping the current one: %v", err)
+		return
+	}
+	config, err := ReadAppConfig()
+	if err != nil {
+		glog.Errorf("Rejected the new configuration, keeping the current one: %v", err)
+		return
+	}
+	if err := runtime.ApplyBase(config); err != nil {
+		glog.Errorf("Rejected the new configuration, keeping the current one: %v", err)
+		return
+	}
+	glog.Infof("Applied the configuration from %s", viper.ConfigFileUsed())
+}
+
+// WatchConfig reloads the configuration whenever the config file changes
+func (runtime *Runtime) WatchConfig() {
+	configFile := viper.ConfigFileUsed()
+	if len(configFile) == 0 {
+		glog.Warningf("No config file has been read: the configuration won't be reloaded")
+		return
+	}
+
+	viper.OnConfigChange(func(event fsnotify.Event) {
+		runtime.Reload()
+	})
+	viper.WatchConfig()
+	go watchConfigMapVolume(filepath.Dir(configFile), runtime.Reload)
+	glog.Infof("Watching %s for configuration changes", configFile)
+}
+
+// watchConfigMapVolume calls reload whenever the ConfigMap mounted in the given directory is updated
+// The kubelet updates a ConfigMap volume by swapping its ..data symlink, which doesn't generate
+// any event for the config file itself - so viper doesn't notice it.
+func watchConfigMapVolume(dir string, reload func()) {
+	dataDir := filepath.Join(dir, "..data")
+	if _, err := os.Lstat(dataDir); err != nil {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Warningf("Failed to watch the ConfigMap volume %s: %v", dir, err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		glog.Warningf("Failed to watch the ConfigMap volume %s: %v", dir, err)
+		return
+	}
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) == dataDir && event.Op&fsnotify.Create == fsnotify.Create {
+				reload()
+			}
+		case err := <-watcher.Errors:
+			glog.Warningf("Error while watching the ConfigMap volume %s: %v", dir, err)
+		}
+	}
+}
+
+// reconcileWatchers starts the new watchers, stops the removed ones, and restarts the changed ones
+// (or all of them, if restartAll is true) - including the ones whose notifiers have been added or removed,
+// as the watchers only keep the existing notifiers when they start
+func (runtime *Runtime) reconcileWatchers(previous *AppConfig, restartAll bool) {
+	for watcherName, task := range runtime.watchers {
+		if _, found := runtime.config.BuildsWatchers[watcherName]; found {
+			continue
+		}
+		task.stop()
+		<-task.done
+		delete(runtime.watchers, watcherName)
+	}
+
+	for _, watcherName := range sortedKeys(runtime.config.BuildsWatchers) {
+		watcherConfig := runtime.config.BuildsWatchers[watcherName]
+		task, running := runtime.watchers[watcherName]
+		if running && !restartAll && reflect.DeepEqual(previous.BuildsWatchers[watcherName], watcherConfig) &&
+			reflect.DeepEqual(existingNotifiers(previous, watcherConfig.Notifiers), existingNotifiers(runtime.config, watcherConfig.Notifiers)) {
+			continue
+		}
+
+		watcher := runtime.newWatcher(watcherName, *watcherConfig, runtime.config, runtime.router)
+		if running {
+			glog.Infof("Restarting watcher %s", watcherName)
+			task.stop()
+			<-task.done
+			watcher.Resume(task.watcher)
+		} else {
+			glog.Infof("Starting watcher %s", watcherName)
+		}
+		task = runtime.run(watcher.Stop, func() error {
+			return watcher.Watch(*runtime.factory, runtime.Dispatcher)
+		})
+		task.watcher = watcher
+		runtime.watchers[watcherName] = task
+	}
+}
+
+// reconcileReporters starts the new reports, stops the removed ones, and restarts the changed ones
+// (or all of them, if restartAll is true)
+func (runtime *Runtime) reconcileReporters(previous *AppConfig, restartAll bool) {
+	for reportName, task := range runtime.reporters {
+		if _, found := runtime.config.Reports[reportName]; found {
+			continue
+		}
+		task.stop()
+		<-task.done
+		delete(runtime.reporters, reportName)
+	}
+
+	for _, reportName := range sortedKeys(runtime.config.Reports) {
+		reportConfig := runtime.config.Reports[reportName]
+		task, running := runtime.reporters[reportName]
+		if running && !restartAll && reflect.DeepEqual(previous.Reports[reportName], reportConfig) &&
+			reflect.DeepEqual(existingNotifiers(previous, reportConfig.Notifiers), existingNotifiers(runtime.config, reportConfig.Notifiers)) {
+			continue
+		}
+		if running {
+			task.stop()
+			<-task.done
+		}
+
+		reporter, err := NewBuildsReporter(reportName, *reportConfig)
+		if err != nil {
+			// the reports have already been validated, so this should not happen
+			glog.Errorf("Failed to create report %s: %v", reportName, err)
+			continue
+		}
+		reporter.Shards = runtime.Shards
+		runtime.reporters[reportName] = runtime.run(reporter.Stop, func() error {
+			return reporter.Run(*runtime.factory, runtime.Dispatcher)
+		})
+	}
+}
+
+// run runs the given function in its own goroutine, sending its error (if any) to the errors channel
+func (runtime *Runtime) run(stop func(), run func() error) *runningTask {
+	task := &runningTask{
+		stop: stop,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(task.done)
+		if err := run(); err != nil {
+			runtime.errors <- err
+		}
+	}()
+	return task
+}
+
+func (runtime *Runtime) newWatcher(name string, config BuildsWatcherConfig, appConfig *AppConfig, router *Router) *BuildsWatcher {
+	watcher := NewBuildsWatcher(name, config)
+	watcher.Shards = runtime.Shards
+	watcher.GlobalQuietWindows = appConfig.QuietWindows
+	watcher.Router = router
+	watcher.FlowNotifiers = runtime.FlowNotifiers
+	watcher.Recorder = runtime.Recorder
+	watcher.Replay = runtime.Replay
+	watcher.Status.SetPaused(runtime.paused[name])
+	return watcher
+}
+
+func (runtime *Runtime) newNotifier(name string, config NotifierConfig) (Notifier, error) {
+	notifier, err := NewNotifier(name, config)
+	if err != nil {
+		return nil, err
+	}
+	if flowdockNotifier, isFlowdock := notifier.(*FlowdockNotifier); isFlowdock && len(config.TokenSecret.Name) > 0 {
+		flowdockNotifier.TokenSource, err = runtime.SecretTokens.TokenSource(config.TokenSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the token: %v", err)
+		}
+	}
+	return notifier, nil
+}
+
+// handOverNotifier returns a function that moves the pending deliveries of a stopped notifier to the given one
+// If the given notifier can't take them, they are handled as if the previous notifier was removed.
+func handOverNotifier(name string, next Notifier) func(previous Notifier) {
+	return func(previous Notifier) {
+		previousFlowdock, isFlowdock := previous.(*FlowdockNotifier)
+		nextFlowdock, nextIsFlowdock := next.(*FlowdockNotifier)
+		if isFlowdock && nextIsFlowdock {
+			previousFlowdock.Queue.HandOver(nextFlowdock.Queue)
+			return
+		}
+		stopNotifier(name, previous)
+	}
+}
+
+// stopNotifier releases what a stopped notifier still holds: the pending deliveries of a Flowdock notifier
+// are kept on disk if it has a queue directory - to be sent when a notifier with this directory starts - or lost.
+func stopNotifier(name string, notifier Notifier) {
+	flowdockNotifier, isFlowdock := notifier.(*FlowdockNotifier)
+	if !isFlowdock {
+		return
+	}
+	pending := flowdockNotifier.Queue.Stop()
+	if len(pending) == 0 {
+		return
+	}
+	if len(flowdockNotifier.Queue.Dir) > 0 {
+		glog.Infof("Notifier %s has been stopped with %d pending deliveries, kept in %s", name, len(pending), flowdockNotifier.Queue.Dir)
+		return
+	}
+	glog.Warningf("Notifier %s has been stopped with %d pending deliveries, which are lost", name, len(pending))
+}
+
+// discardNotifier releases the resources of a notifier that has never been started
+func discardNotifier(notifier Notifier) {
+	if jsonNotifier, isJson := notifier.(*JsonNotifier); isJson {
+		if file, ok := jsonNotifier.writer.(*rotatingFile); ok {
+			file.Close()
+		}
+	}
+}
+
+// existingNotifiers returns the given notifiers which are defined in the given configuration
+func existingNotifiers(config *AppConfig, notifierNames []string) []string {
+	existing := []string{}
+	for _, notifierName := range notifierNames {
+		if _, found := config.Notifiers[notifierName]; found {
+			existing = append(existing, notifierName)
+		}
+	}
+	return existing
+}
+
+// sortedKeys returns the sorted keys of the given map - whose keys must be strings
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+)
+
+func TestRuntimeApply(t *testing.T) {
+	newConfig := func(notifiers map[string]*NotifierConfig) *AppConfig {
+		config := &AppConfig{
+			BuildsWatchers: map[string]*BuildsWatcherConfig{
+				"all": {AllNamespaces: true},
+			},
+			Notifiers: notifiers,
+		}
+		config.SetDefaults()
+		return config
+	}
+
+	tests := []struct {
+		config            *AppConfig
+		expectedError     bool
+		expectedNotifiers []string
+		// expectedRestarted are the notifiers which should have been replaced by new ones
+		expectedRestarted []string
+	}{
+		{
+			config: newConfig(map[string]*NotifierConfig{
+				"default": {},
+				"ops":     {FromName: "Ops"},
+			}),
+			expectedNotifiers: []string{"default", "ops"},
+			expectedRestarted: []string{},
+		},
+		// should start the new notifiers, stop the removed ones, and restart the changed ones
+		{
+			config: newConfig(map[string]*NotifierConfig{
+				"default": {},
+				"ops":     {FromName: "Operations"},
+				"team":    {},
+			}),
+			expectedNotifiers: []string{"default", "ops", "team"},
+			expectedRestarted: []string{"ops"},
+		},
+		{
+			config: newConfig(map[string]*NotifierConfig{
+				"default": {},
+				"team":    {},
+			}),
+			expectedNotifiers: []string{"default", "team"},
+			expectedRestarted: []string{},
+		},
+		// should reject an invalid template, and keep the current notifiers
+		{
+			config: newConfig(map[string]*NotifierConfig{
+				"default": {SubjectTemplate: "{{.Name"},
+				"team":    {},
+			}),
+			expectedError:     true,
+			expectedNotifiers: []string{"default", "team"},
+			expectedRestarted: []string{},
+		},
+		// should reject a routing to an unknown notifier
+		{
+			config: func() *AppConfig {
+				config := newConfig(map[string]*NotifierConfig{
+					"default": {},
+					"team":    {FromName: "Team"},
+				})
+				config.Routing.DefaultNotifiers = []string{"unknown"}
+				return config
+			}(),
+			expectedError:     true,
+			expectedNotifiers: []string{"default", "team"},
+			expectedRestarted: []string{},
+		},
+		// should reject a config without notifiers
+		{
+			config:            newConfig(map[string]*NotifierConfig{}),
+			expectedError:     true,
+			expectedNotifiers: []string{"default", "team"},
+			expectedRestarted: []string{},
+		},
+	}
+
+	runtime := NewRuntime(&clientcmd.Factory{}, nil, make(chan error))
+	for count, test := range tests {
+		before := runtime.Dispatcher.Buffers()
+
+		err := runtime.Apply(test.config)
+		if test.expectedError != (err != nil) {
+			t.Errorf("Test[%d] Failed: Expected error '%v' but got '%v'", count, test.expectedError, err)
+		}
+
+		after := runtime.Dispatcher.Buffers()
+		if notifiers := sortedKeys(after); !reflect.DeepEqual(notifiers, test.expectedNotifiers) {
+			t.Errorf("Test[%d] Failed: Expected notifiers '%v' but got '%v'", count, test.expectedNotifiers, notifiers)
+		}
+		restarted := []string{}
+		for _, name := range sortedKeys(after) {
+			if previous, found := before[name]; found && previous.Notifier != after[name].Notifier {
+				restarted = append(restarted, name)
+			}
+		}
+		if !reflect.DeepEqual(restarted, test.expectedRestarted) {
+			t.Errorf("Test[%d] Failed: Expected restarted notifiers '%v' but got '%v'", count, test.expectedRestarted, restarted)
+		}
+	}
+}
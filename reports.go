@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	buildutil "github.com/openshift/origin/pkg/build/util"
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultReportSchedule        = "0 9 * * *"
+	DefaultReportPeriod          = "24h"
+	DefaultReportTopEntries      = 5
+	DefaultReportSubjectTemplate = "Builds report for {{.Namespace}}: {{.Status}}"
+	DefaultReportContentTemplate = `<h3>Builds report for {{.Namespace}}</h3>
+<p>From {{.ObjectStartTime}} to {{.ObjectEndTime}}: {{.BuildsCount}} builds, {{.SuccessCount}} succeeded, {{.FailureCount}} failed, {{.CancelledCount}} cancelled</p>
+<dl>
+	<dt>Success rate</dt>
+	<dd>{{printf "%.1f" .SuccessRate}}%</dd>
+	<dt>Mean duration</dt>
+	<dd>{{.MeanDuration}}</dd>
+	<dt>95th percentile duration</dt>
+	<dd>{{.P95Duration}}</dd>
+</dl>
+<h4>Longest pending times</h4>
+<table>
+	<tr><th>Build</th><th>Pending</th></tr>
+{{range .LongestPending}}
+	<tr><td><a href="{{.Url}}">{{.Name}}</a></td><td>{{.PendingTime}}</td></tr>
+{{end}}
+</table>
+<h4>Most frequently failing BuildConfigs</h4>
+<table>
+	<tr><th>BuildConfig</th><th>Failures</th><th>Builds</th></tr>
+{{range .MostFailing}}
+	<tr><td>{{.Name}}</td><td>{{.Failures}}</td><td>{{.Builds}}</td></tr>
+{{end}}
+</table>`
+)
+
+// BuildsReporter periodically sends a report on the builds of the last period, for each namespace
+// The report is computed from the builds listed through the API, so it doesn't depend
+// on the process having been running during the whole period.
+type BuildsReporter struct {
+	Name     string
+	Config   ReportConfig
+	Schedule *CronSchedule
+	Period   time.Duration
+	// Shards is optional: if set, only the namespaces owned by this replica are reported
+	Shards *ShardCoordinator
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewBuildsReporter(name string, config ReportConfig) (*BuildsReporter, error) {
+	schedule, err := ParseCronSchedule(config.Schedule, config.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule for report %s: %v", name, err)
+	}
+	period, err := time.ParseDuration(config.Period)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period %s for report %s: %v", config.Period, name, err)
+	}
+	return &BuildsReporter{
+		Name:     name,
+		Config:   config,
+		Schedule: schedule,
+		Period:   period,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Stop stops sending the reports: Run returns (nil) once the current report - if any - has been sent
+func (reporter *BuildsReporter) Stop() {
+	reporter.stopOnce.Do(func() {
+		close(reporter.stop)
+	})
+}
+
+// Run sends the reports to the dispatcher on schedule, until the reporter is stopped
+// Failing to compute a report is logged, and doesn't stop the next ones.
+func (reporter *BuildsReporter) Run(factory clientcmd.Factory, dispatcher *Dispatcher) error {
+	notifierNames := []string{}
+	for _, notifierName := range reporter.Config.Notifiers {
+		if dispatcher.HasNotifier(notifierName) {
+			notifierNames = append(notifierNames, notifierName)
+		}
+	}
+
+	if len(notifierNames) == 0 {
+		return fmt.Errorf("no notifiers for report %s !", reporter.Name)
+	}
+
+	openshiftPublicUrl := defaultOpenshiftPublicUrl(factory)
+	for {
+		next := reporter.Schedule.Next(time.Now())
+		if next.IsZero() {
+			return fmt.Errorf("the schedule %s of report %s never matches", reporter.Schedule.Spec, reporter.Name)
+		}
+		glog.V(2).Infof("Next %s report at %v", reporter.Name, next)
+		select {
+		case <-time.After(next.Sub(time.Now())):
+		case <-reporter.stop:
+			glog.Infof("Stopped report %s", reporter.Name)
+			return nil
+		}
+
+		if err := reporter.report(factory, dispatcher, notifierNames, openshiftPublicUrl, next); err != nil {
+			glog.Errorf("Failed to send the %s report: %v", reporter.Name, err)
+		}
+	}
+}
+
+func (reporter *BuildsReporter) report(factory clientcmd.Factory, dispatcher *Dispatcher, notifierNames []string, openshiftPublicUrl string, end time.Time) error {
+	oclient, _, err := factory.Clients()
+	if err != nil {
+		return err
+	}
+
+	namespace := reporter.Config.Namespace
+	if reporter.Config.AllNamespaces {
+		namespace = kapi.NamespaceAll
+	} else if len(namespace) == 0 {
+		namespace, _, err = factory.OpenShiftClientConfig.Namespace()
+		if err != nil {
+			return err
+		}
+	}
+
+	builds, err := oclient.Builds(namespace).List(labels.Everything(), fields.Everything())
+	if err != nil {
+		return err
+	}
+
+	buildsByNamespace := make(map[string][]buildapi.Build)
+	for _, build := range builds.Items {
+		buildsByNamespace[build.Namespace] = append(buildsByNamespace[build.Namespace], build)
+	}
+
+	start := end.Add(-reporter.Period)
+	for ns, nsBuilds := range buildsByNamespace {
+		if reporter.Shards != nil && !reporter.Shards.Owns(ns) {
+			continue
+		}
+		report := NewBuildsReport(ns, nsBuilds, start, end, reporter.Config.TopEntries, openshiftPublicUrl)
+		if report.BuildsCount == 0 {
+			glog.V(3).Infof("No builds in namespace %s since %v, skipping the %s report", ns, start, reporter.Name)
+			continue
+		}
+		glog.V(1).Infof("Sending the %s report for namespace %s: %s", reporter.Name, ns, report.Status())
+		dispatcher.Dispatch(notifierNames, report)
+	}
+	return nil
+}
+
+// BuildsReport is an Event with the statistics of the builds of a namespace over a period
+type BuildsReport struct {
+	BuildsCount    int
+	SuccessCount   int
+	FailureCount   int
+	CancelledCount int
+	// SuccessRate is the percentage of successful builds, among the finished (and not cancelled) builds
+	SuccessRate  float64
+	MeanDuration time.Duration
+	P95Duration  time.Duration
+	// LongestPending are the builds that waited the longest before starting
+	LongestPending []PendingBuild
+	// MostFailing are the BuildConfigs with the most failed builds
+	MostFailing []FailingBuildConfig
+
+	namespace string
+	start     unversioned.Time
+	end       unversioned.Time
+	url       string
+}
+
+// PendingBuild is a build and the time it spent before starting, in a report
+type PendingBuild struct {
+	Name        string
+	PendingTime time.Duration
+	Url         string
+}
+
+// FailingBuildConfig is a BuildConfig and its number of failed builds, in a report
+type FailingBuildConfig struct {
+	Name     string
+	Failures int
+	Builds   int
+}
+
+// NewBuildsReport computes the report for the builds created between start and end
+// top is the max number of entries in the "longest pending" and "most failing" lists
+func NewBuildsReport(namespace string, builds []buildapi.Build, start time.Time, end time.Time, top int, openshiftPublicUrl string) *BuildsReport {
+	report := &BuildsReport{
+		namespace: namespace,
+		start:     unversioned.NewTime(start),
+		end:       unversioned.NewTime(end),
+		url:       fmt.Sprintf("%s/console/project/%s/browse/builds", openshiftPublicUrl, namespace),
+	}
+
+	durations := []time.Duration{}
+	pending := []PendingBuild{}
+	buildConfigs := make(map[string]*FailingBuildConfig)
+	for _, build := range builds {
+		created := build.CreationTimestamp.Time
+		if created.Before(start) || !created.Before(end) {
+			continue
+		}
+		report.BuildsCount++
+
+		configName := buildutil.ConfigNameForBuild(&build)
+		if len(configName) > 0 {
+			if _, found := buildConfigs[configName]; !found {
+				buildConfigs[configName] = &FailingBuildConfig{Name: configName}
+			}
+			buildConfigs[configName].Builds++
+		}
+
+		switch build.Status.Phase {
+		case buildapi.BuildPhaseComplete:
+			report.SuccessCount++
+			durations = append(durations, build.Status.Duration)
+		case buildapi.BuildPhaseFailed, buildapi.BuildPhaseError:
+			report.FailureCount++
+			durations = append(durations, build.Status.Duration)
+			if len(configName) > 0 {
+				buildConfigs[configName].Failures++
+			}
+		case buildapi.BuildPhaseCancelled:
+			report.CancelledCount++
+		}
+
+		// builds that are still waiting are pending until the end of the period
+		startedAt := end
+		if build.Status.StartTimestamp != nil {
+			startedAt = build.Status.StartTimestamp.Time
+		} else if build.Status.Phase != buildapi.BuildPhaseNew && build.Status.Phase != buildapi.BuildPhasePending {
+			continue
+		}
+		pending = append(pending, PendingBuild{
+			Name:        build.Name,
+			PendingTime: startedAt.Sub(created),
+			Url: fmt.Sprintf("%s/console/project/%s/browse/builds/%s/%s",
+				openshiftPublicUrl, build.Namespace, configName, build.Name),
+		})
+	}
+
+	if finished := report.SuccessCount + report.FailureCount; finished > 0 {
+		report.SuccessRate = float64(report.SuccessCount) * 100 / float64(finished)
+	}
+
+	if len(durations) > 0 {
+		sort.Sort(durationsAsc(durations))
+		var total time.Duration
+		for _, duration := range durations {
+			total += duration
+		}
+		report.MeanDuration = total / time.Duration(len(durations))
+		// nearest-rank percentile
+		rank := int(math.Ceil(0.95*float64(len(durations)))) - 1
+		report.P95Duration = durations[rank]
+	}
+
+	sort.Sort(pendingBuildsDesc(pending))
+	if len(pending) > top {
+		pending = pending[:top]
+	}
+	report.LongestPending = pending
+
+	failing := []FailingBuildConfig{}
+	for _, buildConfig := range buildConfigs {
+		if buildConfig.Failures > 0 {
+			failing = append(failing, *buildConfig)
+		}
+	}
+	sort.Sort(failingBuildConfigsDesc(failing))
+	if len(failing) > top {
+		failing = failing[:top]
+	}
+	report.MostFailing = failing
+
+	return report
+}
+
+func (report *BuildsReport) Namespace() string {
+	return report.namespace
+}
+
+func (report *BuildsReport) Name() string {
+	return "builds report"
+}
+
+func (report *BuildsReport) ObjectType() string {
+	return "BuildsReport"
+}
+
+func (report *BuildsReport) ObjectStartTime() *unversioned.Time {
+	return &report.start
+}
+
+func (report *BuildsReport) ObjectEndTime() *unversioned.Time {
+	return &report.end
+}
+
+func (report *BuildsReport) ObjectDuration() time.Duration {
+	return report.end.Sub(report.start.Time)
+}
+
+func (report *BuildsReport) Input() string {
+	return ""
+}
+
+func (report *BuildsReport) Output() string {
+	return ""
+}
+
+func (report *BuildsReport) Status() string {
+	return fmt.Sprintf("%d builds, %.1f%% success", report.BuildsCount, report.SuccessRate)
+}
+
+func (report *BuildsReport) IsSuccess() bool {
+	return false
+}
+
+func (report *BuildsReport) IsFailure() bool {
+	return false
+}
+
+func (report *BuildsReport) Transition() string {
+	return ""
+}
+
+func (report *BuildsReport) Logs() string {
+	return ""
+}
+
+func (report *BuildsReport) Events() []string {
+	return []string{}
+}
+
+func (report *BuildsReport) NodeName() string {
+	return ""
+}
+
+func (report *BuildsReport) Url() string {
+	return report.url
+}
+
+type durationsAsc []time.Duration
+
+func (d durationsAsc) Len() int           { return len(d) }
+func (d durationsAsc) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durationsAsc) Less(i, j int) bool { return d[i] < d[j] }
+
+type pendingBuildsDesc []PendingBuild
+
+func (p pendingBuildsDesc) Len() int      { return len(p) }
+func (p pendingBuildsDesc) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p pendingBuildsDesc) Less(i, j int) bool {
+	if p[i].PendingTime == p[j].PendingTime {
+		return p[i].Name < p[j].Name
+	}
+	return p[i].PendingTime > p[j].PendingTime
+}
+
+type failingBuildConfigsDesc []FailingBuildConfig
+
+func (f failingBuildConfigsDesc) Len() int      { return len(f) }
+func (f failingBuildConfigsDesc) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f failingBuildConfigsDesc) Less(i, j int) bool {
+	if f[i].Failures == f[j].Failures {
+		return f[i].Name < f[j].Name
+	}
+	return f[i].Failures > f[j].Failures
+}
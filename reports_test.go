@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+func TestNewBuildsReport(t *testing.T) {
+	end := time.Date(2016, 3, 14, 9, 0, 0, 0, time.UTC)
+	start := end.Add(-24 * time.Hour)
+
+	build := func(name string, config string, phase buildapi.BuildPhase, createdAgo time.Duration, pending time.Duration, duration time.Duration) buildapi.Build {
+		created := end.Add(-createdAgo)
+		b := buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace:         "test",
+				Name:              name,
+				CreationTimestamp: unversioned.NewTime(created),
+				Labels: map[string]string{
+					buildapi.BuildConfigLabel: config,
+				},
+			},
+			Status: buildapi.BuildStatus{
+				Phase:    phase,
+				Duration: duration,
+			},
+		}
+		if phase != buildapi.BuildPhasePending {
+			started := unversioned.NewTime(created.Add(pending))
+			b.Status.StartTimestamp = &started
+		}
+		return b
+	}
+
+	builds := []buildapi.Build{
+		// out of the period
+		build("app-1", "app", buildapi.BuildPhaseFailed, 25*time.Hour, time.Hour, time.Minute),
+		build("app-2", "app", buildapi.BuildPhaseComplete, 10*time.Hour, 10*time.Second, 1*time.Minute),
+		build("app-3", "app", buildapi.BuildPhaseFailed, 9*time.Hour, 20*time.Second, 2*time.Minute),
+		build("app-4", "app", buildapi.BuildPhaseError, 8*time.Hour, 30*time.Second, 3*time.Minute),
+		build("api-1", "api", buildapi.BuildPhaseComplete, 7*time.Hour, 5*time.Second, 4*time.Minute),
+		build("api-2", "api", buildapi.BuildPhaseFailed, 6*time.Hour, 5*time.Second, 10*time.Minute),
+		build("api-3", "api", buildapi.BuildPhaseCancelled, 5*time.Hour, 5*time.Second, 0),
+		// still pending at the end of the period
+		build("api-4", "api", buildapi.BuildPhasePending, 2*time.Minute, 0, 0),
+	}
+
+	report := NewBuildsReport("test", builds, start, end, 2, "https://openshift.example.org")
+
+	if report.BuildsCount != 7 {
+		t.Errorf("Expected 7 builds but got %d", report.BuildsCount)
+	}
+	if report.SuccessCount != 2 || report.FailureCount != 3 || report.CancelledCount != 1 {
+		t.Errorf("Expected 2 successes, 3 failures and 1 cancelled but got %d, %d and %d", report.SuccessCount, report.FailureCount, report.CancelledCount)
+	}
+	if report.SuccessRate != 40 {
+		t.Errorf("Expected a success rate of 40 but got %v", report.SuccessRate)
+	}
+	if report.MeanDuration != 4*time.Minute {
+		t.Errorf("Expected a mean duration of 4m but got %v", report.MeanDuration)
+	}
+	if report.P95Duration != 10*time.Minute {
+		t.Errorf("Expected a p95 duration of 10m but got %v", report.P95Duration)
+	}
+
+	expectedPending := []PendingBuild{
+		{Name: "api-4", PendingTime: 2 * time.Minute, Url: "https://openshift.example.org/console/project/test/browse/builds/api/api-4"},
+		{Name: "app-4", PendingTime: 30 * time.Second, Url: "https://openshift.example.org/console/project/test/browse/builds/app/app-4"},
+	}
+	if !reflect.DeepEqual(report.LongestPending, expectedPending) {
+		t.Errorf("Expected longest pending %+v but got %+v", expectedPending, report.LongestPending)
+	}
+
+	expectedFailing := []FailingBuildConfig{
+		{Name: "app", Failures: 2, Builds: 3},
+		{Name: "api", Failures: 1, Builds: 4},
+	}
+	if !reflect.DeepEqual(report.MostFailing, expectedFailing) {
+		t.Errorf("Expected most failing %+v but got %+v", expectedFailing, report.MostFailing)
+	}
+
+	config := NotifierConfig{}
+	config.SetDefaults()
+	templates, err := NewMessageTemplates(config)
+	if err != nil {
+		t.Fatalf("Failed to create the templates: %v", err)
+	}
+	message, err := templates.Render(report)
+	if err != nil {
+		t.Fatalf("Failed to render the report: %v", err)
+	}
+	if expectedSubject := "Builds report for test: 7 builds, 40.0% success"; message.Subject != expectedSubject {
+		t.Errorf("Expected subject '%v' but got '%v'", expectedSubject, message.Subject)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// Router routes the events of the watchers to the notifiers, with rules:
+// the events are sent to the notifiers of all the matching rules,
+// and the events that don't match any rule are sent to the default notifiers.
+type Router struct {
+	Rules            []*RoutingRule
+	DefaultNotifiers []string
+}
+
+// RoutingRule sends the events matching its expression to its notifiers
+type RoutingRule struct {
+	Name       string
+	Expression *Expression
+	Notifiers  []string
+}
+
+func NewRouter(config RoutingConfig) (*Router, error) {
+	router := &Router{
+		DefaultNotifiers: config.DefaultNotifiers,
+	}
+	for i, ruleConfig := range config.Rules {
+		name := ruleConfig.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if len(ruleConfig.Notifiers) == 0 {
+			return nil, fmt.Errorf("routing rule %s has no notifiers", name)
+		}
+		expression, err := ParseExpression(ruleConfig.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %s: %v", name, err)
+		}
+		router.Rules = append(router.Rules, &RoutingRule{
+			Name:       name,
+			Expression: expression,
+			Notifiers:  ruleConfig.Notifiers,
+		})
+	}
+	return router, nil
+}
+
+// Route returns the names of the notifiers for the given event
+func (router *Router) Route(event Event) []string {
+	notifiers := []string{}
+	for _, rule := range router.Rules {
+		if !rule.Expression.Match(event) {
+			continue
+		}
+		glog.V(3).Infof("Routing rule %s matches %s %s/%s", rule.Name, event.ObjectType(), event.Namespace(), event.Name())
+		for _, notifier := range rule.Notifiers {
+			if !containsString(notifiers, notifier) {
+				notifiers = append(notifiers, notifier)
+			}
+		}
+	}
+	if len(notifiers) == 0 {
+		return router.DefaultNotifiers
+	}
+	return notifiers
+}
+
+// Notifiers returns the names of all the notifiers used by the router
+func (router *Router) Notifiers() []string {
+	notifiers := append([]string{}, router.DefaultNotifiers...)
+	for _, rule := range router.Rules {
+		for _, notifier := range rule.Notifiers {
+			if !containsString(notifiers, notifier) {
+				notifiers = append(notifiers, notifier)
+			}
+		}
+	}
+	return notifiers
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultTokenSecretKey = "token"
+)
+
+// SecretTokens reads the Flowdock tokens from Secrets
+// The Secrets of each namespace are watched - from the first time a token is read in this namespace -
+// so that the tokens are re-read when the Secrets change, without calling the API for every notification.
+type SecretTokens struct {
+	factory clientcmd.Factory
+	mutex   sync.Mutex
+	stores  map[string]cache.Store
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewSecretTokens(factory clientcmd.Factory) *SecretTokens {
+	return &SecretTokens{
+		factory: factory,
+		stores:  make(map[string]cache.Store),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Stop stops watching the Secrets - the tokens already read can still be used
+func (tokens *SecretTokens) Stop() {
+	tokens.stopOnce.Do(func() {
+		close(tokens.stop)
+	})
+}
+
+// TokenSource returns a TokenSource for FlowdockNotifier, reading the token from the referenced Secret
+func (tokens *SecretTokens) TokenSource(ref SecretKeyRef) (func(namespace string) (string, error), error) {
+	secretNamespace := ref.Namespace
+	if len(secretNamespace) == 0 && !ref.EventNamespace {
+		var err error
+		secretNamespace, _, err = tokens.factory.OpenShiftClientConfig.Namespace()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return func(eventNamespace string) (string, error) {
+		namespace := secretNamespace
+		if ref.EventNamespace {
+			namespace = eventNamespace
+		}
+		if len(namespace) == 0 {
+			return "", fmt.Errorf("no namespace to read the token from secret %s", ref.Name)
+		}
+		return tokens.Token(namespace, ref.Name, ref.Key)
+	}, nil
+}
+
+// Token returns the token stored in the given key of the given Secret
+func (tokens *SecretTokens) Token(namespace string, name string, key string) (string, error) {
+	store, err := tokens.store(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	obj, exists, err := store.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("secret %s/%s not found", namespace, name)
+	}
+	token, found := obj.(*kapi.Secret).Data[key]
+	if !found {
+		return "", fmt.Errorf("secret %s/%s has no %s key", namespace, name, key)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// store returns the cache of the Secrets of the given namespace - starting to watch them if needed
+func (tokens *SecretTokens) store(namespace string) (cache.Store, error) {
+	tokens.mutex.Lock()
+	defer tokens.mutex.Unlock()
+
+	if store, found := tokens.stores[namespace]; found {
+		return store, nil
+	}
+
+	_, kclient, err := tokens.factory.Clients()
+	if err != nil {
+		return nil, err
+	}
+
+	// the first list is done here, so that the lookups can be done right away
+	// and that the errors are reported to the caller
+	list, err := kclient.Secrets(namespace).List(labels.Everything(), fields.Everything())
+	if err != nil {
+		if kerrors.IsForbidden(err) {
+			return nil, fmt.Errorf("the notifier is not allowed to read the secrets of namespace %s - its ServiceAccount needs the rights to list and watch the secrets of this namespace: %v", namespace, err)
+		}
+		return nil, fmt.Errorf("failed to list the secrets of namespace %s: %v", namespace, err)
+	}
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	items := []interface{}{}
+	for i := range list.Items {
+		items = append(items, &list.Items[i])
+	}
+	if err := store.Replace(items, list.ResourceVersion); err != nil {
+		return nil, err
+	}
+
+	cache.NewReflector(&cache.ListWatch{
+		ListFunc: func() (runtime.Object, error) {
+			return kclient.Secrets(namespace).List(labels.Everything(), fields.Everything())
+		},
+		WatchFunc: func(resourceVersion string) (watch.Interface, error) {
+			return kclient.Secrets(namespace).Watch(labels.Everything(), fields.Everything(), resourceVersion)
+		},
+	}, &kapi.Secret{}, store, 0).RunUntil(tokens.stop)
+
+	glog.V(1).Infof("Watching the secrets of namespace %s for the Flowdock tokens", namespace)
+	tokens.stores[namespace] = store
+	return store, nil
+}
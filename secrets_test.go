@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+)
+
+func TestSecretTokensTokenSource(t *testing.T) {
+	tokens := NewSecretTokens(clientcmd.Factory{})
+	for _, namespace := range []string{"team-a", "team-b"} {
+		store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		store.Add(&kapi.Secret{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace: namespace,
+				Name:      "flowdock",
+			},
+			Data: map[string][]byte{
+				"token": []byte(namespace + "-token\n"),
+			},
+		})
+		tokens.stores[namespace] = store
+	}
+
+	tests := []struct {
+		ref            SecretKeyRef
+		eventNamespace string
+		expectedToken  string
+		expectedError  bool
+	}{
+		{
+			ref:            SecretKeyRef{Namespace: "team-a", Name: "flowdock", Key: "token"},
+			eventNamespace: "team-b",
+			expectedToken:  "team-a-token",
+		},
+		{
+			ref:            SecretKeyRef{Name: "flowdock", Key: "token", EventNamespace: true},
+			eventNamespace: "team-b",
+			expectedToken:  "team-b-token",
+		},
+		{
+			ref:            SecretKeyRef{Name: "flowdock", Key: "token", EventNamespace: true},
+			eventNamespace: "",
+			expectedError:  true,
+		},
+		{
+			ref:            SecretKeyRef{Namespace: "team-a", Name: "unknown", Key: "token"},
+			eventNamespace: "team-a",
+			expectedError:  true,
+		},
+		{
+			ref:            SecretKeyRef{Namespace: "team-a", Name: "flowdock", Key: "unknown"},
+			eventNamespace: "team-a",
+			expectedError:  true,
+		},
+	}
+
+	for count, test := range tests {
+		source, err := tokens.TokenSource(test.ref)
+		if err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		token, err := source(test.eventNamespace)
+		if test.expectedError {
+			if err == nil {
+				t.Errorf("Test[%d] Failed: Expected an error but got token '%v'", count, token)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		if token != test.expectedToken {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedToken, token)
+		}
+	}
+}
+
+func TestSecretTokensStop(t *testing.T) {
+	tokens := NewSecretTokens(clientcmd.Factory{})
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(&kapi.Secret{
+		ObjectMeta: kapi.ObjectMeta{Namespace: "team-a", Name: "flowdock"},
+		Data:       map[string][]byte{"token": []byte("team-a-token")},
+	})
+	tokens.stores["team-a"] = store
+
+	// stopping twice is fine
+	tokens.Stop()
+	tokens.Stop()
+	select {
+	case <-tokens.stop:
+	default:
+		t.Errorf("Expected the watches of the secrets to be stopped")
+	}
+
+	// the tokens already read can still be used
+	if token, err := tokens.Token("team-a", "flowdock", "token"); err != nil || token != "team-a-token" {
+		t.Errorf("Expected '%v' but got '%v' (error %v)", "team-a-token", token, err)
+	}
+}
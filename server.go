@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HttpServer serves the health endpoints and the Prometheus metrics of the application - and the admin API, if enabled
+type HttpServer struct {
+	Address string
+	Mux     *http.ServeMux
+}
+
+// NewHttpServer returns a new HttpServer, reporting the health of the given runtime
+func NewHttpServer(config HttpConfig, runtime *Runtime) (*HttpServer, error) {
+	watchTimeout, err := time.ParseDuration(config.WatchTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid watchTimeout %s: %v", config.WatchTimeout, err)
+	}
+	notifierFailureTimeout, err := time.ParseDuration(config.NotifierFailureTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifierFailureTimeout %s: %v", config.NotifierFailureTimeout, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthHandler(runtime, livenessProblems(watchTimeout)))
+	mux.Handle("/readyz", healthHandler(runtime, readinessProblems(notifierFailureTimeout)))
+	mux.Handle("/metrics", prometheus.Handler())
+	if len(config.AdminToken) > 0 {
+		mux.Handle("/admin/", adminHandler(runtime, config.AdminToken))
+	}
+	return &HttpServer{
+		Address: config.Address,
+		Mux:     mux,
+	}, nil
+}
+
+// Run serves the HTTP requests - it only returns if the server fails
+func (server *HttpServer) Run() error {
+	glog.Infof("Serving the health endpoints, the metrics and the admin API (if enabled) on %s", server.Address)
+	if err := http.ListenAndServe(server.Address, server.Mux); err != nil {
+		return fmt.Errorf("the HTTP server failed: %v", err)
+	}
+	return nil
+}
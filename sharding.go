@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	k8client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/golang/glog"
+)
+
+const (
+	ShardingRecordAnnotation = "flowdock-notifier/shards"
+
+	DefaultShardingName              = "flowdock-notifier-shards"
+	DefaultShardingHeartbeatInterval = "5s"
+	DefaultShardingMemberTTL         = "20s"
+	DefaultShardingHandoverDelay     = "10s"
+	DefaultShardingVirtualNodes      = 100
+)
+
+// ShardingRecord is the record stored in an annotation of the coordination object
+// It holds the heartbeats of the replicas, and the current and previous rings:
+// all the replicas switch from the previous ring to the current ring at the same time,
+// so that they always agree on the owner of a namespace.
+type ShardingRecord struct {
+	Heartbeats map[string]time.Time `json:"heartbeats"`
+	Current    ShardingRing         `json:"current"`
+	Previous   ShardingRing         `json:"previous"`
+}
+
+// ShardingRing is a set of members, effective from a given time
+type ShardingRing struct {
+	Members     []string  `json:"members"`
+	EffectiveAt time.Time `json:"effectiveAt"`
+}
+
+// ShardCoordinator splits the namespaces between the replicas, using a consistent hash.
+// Each replica watches everything, but notifies only for the namespaces it owns.
+// The replicas send heartbeats to an annotation on an Endpoints object,
+// and the ring is rebuilt when a replica joins or leaves.
+type ShardCoordinator struct {
+	Identity          string
+	Namespace         string
+	Name              string
+	HeartbeatInterval time.Duration
+	MemberTTL         time.Duration
+	HandoverDelay     time.Duration
+	VirtualNodes      int
+
+	factory clientcmd.Factory
+	mutex   sync.Mutex
+	record  ShardingRecord
+	rings   map[string]hashRing
+	leaving bool
+}
+
+func NewShardCoordinator(factory clientcmd.Factory, config ShardingConfig) (*ShardCoordinator, error) {
+	heartbeatInterval, err := time.ParseDuration(config.HeartbeatInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid heartbeatInterval %s: %v", config.HeartbeatInterval, err)
+	}
+	memberTTL, err := time.ParseDuration(config.MemberTTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid memberTTL %s: %v", config.MemberTTL, err)
+	}
+	handoverDelay, err := time.ParseDuration(config.HandoverDelay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handoverDelay %s: %v", config.HandoverDelay, err)
+	}
+	if memberTTL <= heartbeatInterval {
+		return nil, fmt.Errorf("memberTTL (%v) must be greater than heartbeatInterval (%v)", memberTTL, heartbeatInterval)
+	}
+
+	identity := config.Identity
+	if len(identity) == 0 {
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	namespace := config.Namespace
+	if len(namespace) == 0 {
+		namespace, _, err = factory.OpenShiftClientConfig.Namespace()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	coordinator := &ShardCoordinator{
+		Identity:          identity,
+		Namespace:         namespace,
+		Name:              config.Name,
+		HeartbeatInterval: heartbeatInterval,
+		MemberTTL:         memberTTL,
+		HandoverDelay:     handoverDelay,
+		VirtualNodes:      config.VirtualNodes,
+		factory:           factory,
+		rings:             make(map[string]hashRing),
+	}
+
+	// join the shards right away: until the first heartbeat, the ring is empty and we would own nothing
+	glog.Infof("Joining the shards %s/%s as %s...", coordinator.Namespace, coordinator.Name, coordinator.Identity)
+	if err := coordinator.heartbeat(); err != nil {
+		return nil, fmt.Errorf("failed to join the shards %s/%s: %v", coordinator.Namespace, coordinator.Name, err)
+	}
+	return coordinator, nil
+}
+
+// Run sends heartbeats, forever - the first one has been sent when the coordinator was created
+func (coordinator *ShardCoordinator) Run() {
+	for {
+		time.Sleep(coordinator.HeartbeatInterval)
+		if err := coordinator.heartbeat(); err != nil {
+			glog.Warningf("Failed to send a heartbeat to the shards %s/%s: %v", coordinator.Namespace, coordinator.Name, err)
+		}
+	}
+}
+
+// Leave removes this replica from the ring, and waits until the new ring is effective,
+// so that the other replicas take over its namespaces without losing any notification.
+func (coordinator *ShardCoordinator) Leave() {
+	coordinator.mutex.Lock()
+	coordinator.leaving = true
+	coordinator.mutex.Unlock()
+
+	glog.Infof("Leaving the shards %s/%s...", coordinator.Namespace, coordinator.Name)
+	deadline := time.Now().Add(coordinator.MemberTTL + coordinator.HandoverDelay)
+	for time.Now().Before(deadline) {
+		if err := coordinator.heartbeat(); err != nil {
+			glog.Warningf("Failed to leave the shards %s/%s: %v", coordinator.Namespace, coordinator.Name, err)
+			time.Sleep(coordinator.HeartbeatInterval)
+			continue
+		}
+		coordinator.mutex.Lock()
+		ring := coordinator.record.Current
+		coordinator.mutex.Unlock()
+		if !containsString(ring.Members, coordinator.Identity) {
+			if wait := ring.EffectiveAt.Sub(time.Now()); wait > 0 {
+				glog.Infof("Waiting %v for the other replicas to take over...", wait)
+				time.Sleep(wait)
+			}
+			return
+		}
+		time.Sleep(coordinator.HeartbeatInterval)
+	}
+}
+
+// Owns returns true if this replica should notify the events of the given namespace
+func (coordinator *ShardCoordinator) Owns(namespace string) bool {
+	return coordinator.Owner(namespace) == coordinator.Identity
+}
+
+// Owner returns the identity of the replica that owns the given namespace
+func (coordinator *ShardCoordinator) Owner(namespace string) string {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+
+	members := coordinator.record.effectiveRing(time.Now()).Members
+	key := fmt.Sprintf("%v", members)
+	ring, found := coordinator.rings[key]
+	if !found {
+		ring = newHashRing(members, coordinator.VirtualNodes)
+		// keep only the rings of the current record
+		if len(coordinator.rings) > 1 {
+			coordinator.rings = make(map[string]hashRing)
+		}
+		coordinator.rings[key] = ring
+	}
+	return ring.Owner(namespace)
+}
+
+// Members returns the members of the effective ring
+func (coordinator *ShardCoordinator) Members() []string {
+	coordinator.mutex.Lock()
+	defer coordinator.mutex.Unlock()
+	return coordinator.record.effectiveRing(time.Now()).Members
+}
+
+func (coordinator *ShardCoordinator) heartbeat() error {
+	_, kclient, err := coordinator.factory.Clients()
+	if err != nil {
+		return err
+	}
+
+	endpoints, err := kclient.Endpoints(coordinator.Namespace).Get(coordinator.Name)
+	if err != nil {
+		if !kerrors.IsNotFound(err) {
+			return err
+		}
+		endpoints = &kapi.Endpoints{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace: coordinator.Namespace,
+				Name:      coordinator.Name,
+			},
+		}
+	}
+
+	record := ShardingRecord{}
+	if data, found := endpoints.Annotations[ShardingRecordAnnotation]; found {
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			glog.Warningf("Ignoring invalid sharding record %s: %v", data, err)
+		}
+	}
+	if record.Heartbeats == nil {
+		record.Heartbeats = make(map[string]time.Time)
+	}
+
+	coordinator.mutex.Lock()
+	leaving := coordinator.leaving
+	coordinator.mutex.Unlock()
+
+	now := time.Now()
+	if leaving {
+		delete(record.Heartbeats, coordinator.Identity)
+	} else {
+		record.Heartbeats[coordinator.Identity] = now
+	}
+
+	members := []string{}
+	for member, lastHeartbeat := range record.Heartbeats {
+		if now.Sub(lastHeartbeat) > coordinator.MemberTTL {
+			glog.Warningf("Replica %s did not send any heartbeat since %v, removing it from the shards", member, lastHeartbeat)
+			delete(record.Heartbeats, member)
+			continue
+		}
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	if !equalStrings(members, record.Current.Members) {
+		if !now.Before(record.Current.EffectiveAt) {
+			record.Previous = record.Current
+		}
+		record.Current = ShardingRing{
+			Members:     members,
+			EffectiveAt: now.Add(coordinator.HandoverDelay),
+		}
+		glog.Infof("Rebalancing the shards between %d replicas %v, effective at %v", len(members), members, record.Current.EffectiveAt)
+	}
+
+	if err := coordinator.saveRecord(kclient, endpoints, record); err != nil {
+		return err
+	}
+
+	coordinator.mutex.Lock()
+	coordinator.record = record
+	coordinator.mutex.Unlock()
+	return nil
+}
+
+func (coordinator *ShardCoordinator) saveRecord(kclient *k8client.Client, endpoints *kapi.Endpoints, record ShardingRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if endpoints.Annotations == nil {
+		endpoints.Annotations = make(map[string]string)
+	}
+	endpoints.Annotations[ShardingRecordAnnotation] = string(data)
+
+	// create or update will fail if someone else created or updated the object in the meantime
+	if len(endpoints.ResourceVersion) == 0 {
+		_, err = kclient.Endpoints(coordinator.Namespace).Create(endpoints)
+	} else {
+		_, err = kclient.Endpoints(coordinator.Namespace).Update(endpoints)
+	}
+	return err
+}
+
+// effectiveRing returns the ring effective at the given time
+// Each replica compares EffectiveAt with its own clock: the clocks of the replicas must be synchronized,
+// or the events of the namespaces which move during a handover are lost or notified twice
+// for as long as the skew between the clocks.
+func (record ShardingRecord) effectiveRing(now time.Time) ShardingRing {
+	// if there was no previous ring, nobody owned anything: no need to wait for a handover
+	if now.Before(record.Current.EffectiveAt) && len(record.Previous.Members) > 0 {
+		return record.Previous
+	}
+	return record.Current
+}
+
+// hashRing is a consistent hash ring, with a number of virtual nodes per member
+type hashRing []hashRingNode
+
+type hashRingNode struct {
+	hash   uint32
+	member string
+}
+
+func newHashRing(members []string, virtualNodes int) hashRing {
+	ring := hashRing{}
+	for _, member := range members {
+		for i := 0; i < virtualNodes; i++ {
+			ring = append(ring, hashRingNode{hash: hashString(fmt.Sprintf("%s#%d", member, i)), member: member})
+		}
+	}
+	sort.Sort(ring)
+	return ring
+}
+
+// Owner returns the member that owns the given key
+func (ring hashRing) Owner(key string) string {
+	if len(ring) == 0 {
+		return ""
+	}
+	hash := hashString(key)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= hash })
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].member
+}
+
+func (ring hashRing) Len() int           { return len(ring) }
+func (ring hashRing) Less(i, j int) bool { return ring[i].hash < ring[j].hash }
+func (ring hashRing) Swap(i, j int)      { ring[i], ring[j] = ring[j], ring[i] }
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHashRingOwner(t *testing.T) {
+	namespaces := []string{}
+	for i := 0; i < 1000; i++ {
+		namespaces = append(namespaces, fmt.Sprintf("project-%d", i))
+	}
+
+	ring := newHashRing([]string{"a", "b", "c"}, DefaultShardingVirtualNodes)
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for _, namespace := range namespaces {
+		owner := ring.Owner(namespace)
+		owners[namespace] = owner
+		counts[owner]++
+	}
+	for _, member := range []string{"a", "b", "c"} {
+		if counts[member] < 200 {
+			t.Errorf("Expected a balanced ring, but %s owns only %d namespaces out of %d", member, counts[member], len(namespaces))
+		}
+	}
+
+	// removing a member should only move the namespaces it owned
+	ring = newHashRing([]string{"a", "c"}, DefaultShardingVirtualNodes)
+	for _, namespace := range namespaces {
+		owner := ring.Owner(namespace)
+		if owners[namespace] != "b" && owners[namespace] != owner {
+			t.Errorf("Namespace %s moved from %s to %s", namespace, owners[namespace], owner)
+		}
+	}
+
+	if owner := newHashRing([]string{}, DefaultShardingVirtualNodes).Owner("project"); owner != "" {
+		t.Errorf("Expected no owner for an empty ring, but got %s", owner)
+	}
+}
+
+func TestShardingRecordEffectiveRing(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		record          ShardingRecord
+		expectedMembers []string
+	}{
+		// should use the previous ring until the current ring is effective
+		{
+			record: ShardingRecord{
+				Current:  ShardingRing{Members: []string{"a", "b"}, EffectiveAt: now.Add(time.Minute)},
+				Previous: ShardingRing{Members: []string{"a"}},
+			},
+			expectedMembers: []string{"a"},
+		},
+		// should use the current ring once it is effective
+		{
+			record: ShardingRecord{
+				Current:  ShardingRing{Members: []string{"a", "b"}, EffectiveAt: now.Add(-time.Minute)},
+				Previous: ShardingRing{Members: []string{"a"}},
+			},
+			expectedMembers: []string{"a", "b"},
+		},
+		// should use the current ring right away if there was no previous ring
+		{
+			record: ShardingRecord{
+				Current: ShardingRing{Members: []string{"a"}, EffectiveAt: now.Add(time.Minute)},
+			},
+			expectedMembers: []string{"a"},
+		},
+	}
+
+	for count, test := range tests {
+		result := test.record.effectiveRing(now).Members
+		if fmt.Sprintf("%v", result) != fmt.Sprintf("%v", test.expectedMembers) {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedMembers, result)
+		}
+	}
+}
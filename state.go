@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kerrors "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/meta"
+	"k8s.io/kubernetes/pkg/runtime"
+
+	"github.com/golang/glog"
+)
+
+const (
+	DefaultStateFlushInterval = 5 * time.Second
+	// DefaultStateMaxObjects keeps the stored state well under the 1 MiB limit of a ConfigMap
+	DefaultStateMaxObjects = 5000
+)
+
+// StateStore persists a piece of state (as JSON)
+type StateStore interface {
+	// Load reads the state into the given value, and returns false if there is no stored state yet
+	Load(v interface{}) (bool, error)
+	// Save writes the given value
+	Save(v interface{}) error
+}
+
+// NewStateStore returns a StateStore for the given location,
+// which is either a local file path, or "configmap:NAMESPACE/NAME"
+// The key is used to store multiple states in the same ConfigMap.
+func NewStateStore(factory clientcmd.Factory, location string, key string) (StateStore, error) {
+	if strings.HasPrefix(location, "configmap:") {
+		parts := strings.SplitN(strings.TrimPrefix(location, "configmap:"), "/", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("invalid ConfigMap state location %s - expected configmap:NAMESPACE/NAME", location)
+		}
+		return &ConfigMapStateStore{
+			factory:   factory,
+			Namespace: parts[0],
+			Name:      parts[1],
+			// the names of the watchers contributed by ConfigMaps are qualified by their namespace,
+			// but the keys of a ConfigMap can't contain a "/"
+			Key: strings.Replace(key, "/", ".", -1),
+		}, nil
+	}
+
+	return &FileStateStore{
+		Path: location,
+	}, nil
+}
+
+// FileStateStore persists the state in a local file
+type FileStateStore struct {
+	Path string
+}
+
+func (store *FileStateStore) Load(v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(store.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (store *FileStateStore) Save(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.Path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temp file first, so that we never load a partially written state
+	tmpPath := store.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, store.Path)
+}
+
+// ConfigMapStateStore persists the state in a key of a ConfigMap
+type ConfigMapStateStore struct {
+	Namespace string
+	Name      string
+	Key       string
+	factory   clientcmd.Factory
+}
+
+func (store *ConfigMapStateStore) Load(v interface{}) (bool, error) {
+	_, kclient, err := store.factory.Clients()
+	if err != nil {
+		return false, err
+	}
+
+	configMap, err := getConfigMap(kclient, store.Namespace, store.Name)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	data, found := configMap.Data[store.Key]
+	if !found {
+		return false, nil
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (store *ConfigMapStateStore) Save(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, kclient, err := store.factory.Clients()
+	if err != nil {
+		return err
+	}
+
+	configMap, err := getConfigMap(kclient, store.Namespace, store.Name)
+	if err != nil {
+		if !kerrors.IsNotFound(err) {
+			return err
+		}
+		configMap = NewConfigMap(store.Namespace, store.Name)
+		configMap.Data[store.Key] = string(data)
+		return createConfigMap(kclient, configMap)
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[store.Key] = string(data)
+	return updateConfigMap(kclient, configMap)
+}
+
+// WatchState is the state of a watch: the last processed resourceVersion,
+// and the resourceVersion of each known object
+// It is used to resume a watch after a restart or a reconnection,
+// and to synthesize the events that have been missed in between.
+type WatchState struct {
+	ResourceVersion string
+	Objects         map[string]string
+	// PrunedResourceVersion is the highest resourceVersion of the objects pruned from Objects
+	PrunedResourceVersion string
+
+	// known is true if the state reflects a previous watch
+	known bool
+	dirty bool
+	store StateStore
+	mutex sync.Mutex
+	// maxObjects is the max number of objects in Objects - 0 for no limit
+	maxObjects int
+}
+
+// NewWatchState returns a new WatchState, loaded from the given store (which is optional)
+func NewWatchState(store StateStore) (*WatchState, error) {
+	state := &WatchState{
+		Objects:    make(map[string]string),
+		store:      store,
+		maxObjects: DefaultStateMaxObjects,
+	}
+
+	if store != nil {
+		found, err := store.Load(state)
+		if err != nil {
+			return nil, err
+		}
+		if state.Objects == nil {
+			state.Objects = make(map[string]string)
+		}
+		state.known = found
+	}
+
+	return state, nil
+}
+
+// Known returns true if the state reflects a previous watch
+func (state *WatchState) Known() bool {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.known
+}
+
+// LastResourceVersion returns the resourceVersion of the last processed event
+func (state *WatchState) LastResourceVersion() string {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.ResourceVersion
+}
+
+// Invalidate forgets the last resourceVersion, so that the next watch will start from a fresh list
+func (state *WatchState) Invalidate() {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.ResourceVersion = ""
+	state.dirty = true
+}
+
+// Diff compares the given (fresh) list of objects with the known state,
+// and returns the events that have been missed: objects that have been added or modified.
+// It then resets the state to the given list.
+func (state *WatchState) Diff(list runtime.Object, listResourceVersion string) ([]WatchStateChange, error) {
+	items, err := runtime.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	changes := []WatchStateChange{}
+	objects := make(map[string]string)
+	for _, item := range items {
+		key, rv, err := objectKeyAndResourceVersion(item)
+		if err != nil {
+			return nil, err
+		}
+		objects[key] = rv
+
+		if !state.known {
+			continue
+		}
+		knownRV, found := state.Objects[key]
+		switch {
+		case !found && state.isPruned(rv):
+			// an old object, which has been pruned from the state: it has not changed since
+		case !found:
+			changes = append(changes, WatchStateChange{Object: item, Added: true})
+		case knownRV != rv:
+			changes = append(changes, WatchStateChange{Object: item})
+		}
+	}
+
+	state.Objects = objects
+	state.ResourceVersion = listResourceVersion
+	state.known = true
+	state.dirty = true
+	state.prune()
+	return changes, nil
+}
+
+// WatchStateChange is a change detected by a diff between a fresh list and the known state
+type WatchStateChange struct {
+	Object runtime.Object
+	Added  bool
+}
+
+// Update records an object that has been processed
+func (state *WatchState) Update(obj runtime.Object, deleted bool) {
+	key, rv, err := objectKeyAndResourceVersion(obj)
+	if err != nil {
+		glog.Warningf("Failed to record the state of %T: %v", obj, err)
+		return
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if deleted {
+		delete(state.Objects, key)
+	} else {
+		state.Objects[key] = rv
+	}
+	state.ResourceVersion = rv
+	state.known = true
+	state.dirty = true
+	state.prune()
+}
+
+// prune drops the objects with the oldest resourceVersions when there are more than maxObjects,
+// so that the state can still be stored in a ConfigMap on a cluster with a lot of builds.
+// The highest pruned resourceVersion is kept, so that Diff doesn't report the older objects as added.
+// The resourceVersions which are not numbers can't be ordered: these objects are never pruned.
+// It must be called with the mutex held.
+func (state *WatchState) prune() {
+	if state.maxObjects <= 0 || len(state.Objects) <= state.maxObjects {
+		return
+	}
+
+	objects := versionedObjects{}
+	for key, rv := range state.Objects {
+		if version, err := strconv.ParseUint(rv, 10, 64); err == nil {
+			objects = append(objects, versionedObject{key: key, version: version})
+		}
+	}
+	sort.Sort(objects)
+
+	// prune a tenth more than needed, so that we don't prune again on every update
+	count := len(state.Objects) - state.maxObjects*9/10
+	pruned, _ := strconv.ParseUint(state.PrunedResourceVersion, 10, 64)
+	for i := 0; i < count && i < len(objects); i++ {
+		delete(state.Objects, objects[i].key)
+		if objects[i].version > pruned {
+			pruned = objects[i].version
+		}
+	}
+	state.PrunedResourceVersion = strconv.FormatUint(pruned, 10)
+}
+
+// isPruned returns true if an object with the given resourceVersion may have been pruned from the state
+func (state *WatchState) isPruned(rv string) bool {
+	if len(state.PrunedResourceVersion) == 0 {
+		return false
+	}
+	version, err := strconv.ParseUint(rv, 10, 64)
+	if err != nil {
+		return false
+	}
+	pruned, err := strconv.ParseUint(state.PrunedResourceVersion, 10, 64)
+	return err == nil && version <= pruned
+}
+
+type versionedObject struct {
+	key     string
+	version uint64
+}
+
+type versionedObjects []versionedObject
+
+func (o versionedObjects) Len() int           { return len(o) }
+func (o versionedObjects) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o versionedObjects) Less(i, j int) bool { return o[i].version < o[j].version }
+
+// Flush saves the state to the store, if it has changed
+func (state *WatchState) Flush() error {
+	if state.store == nil {
+		return nil
+	}
+
+	state.mutex.Lock()
+	if !state.dirty {
+		state.mutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(state)
+	state.dirty = false
+	state.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := state.store.Save(json.RawMessage(data)); err != nil {
+		// saved again on the next flush - with the changes made in the meantime
+		state.mutex.Lock()
+		state.dirty = true
+		state.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// flushPeriodically saves the state to the store (if any) at the given interval, until the stop channel is closed
+func (state *WatchState) flushPeriodically(interval time.Duration, stop <-chan struct{}) {
+	if state.store == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := state.Flush(); err != nil {
+				glog.Warningf("Failed to save the watch state: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func objectKeyAndResourceVersion(obj runtime.Object) (string, string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%s/%s", accessor.Namespace(), accessor.Name()), accessor.ResourceVersion(), nil
+}
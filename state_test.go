@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+)
+
+func TestWatchStateDiff(t *testing.T) {
+	build := func(name, rv string) buildapi.Build {
+		return buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace:       "test",
+				Name:            name,
+				ResourceVersion: rv,
+			},
+		}
+	}
+
+	tests := []struct {
+		known           bool
+		knownObjects    map[string]string
+		list            *buildapi.BuildList
+		expectedChanges map[string]bool
+	}{
+		// should not synthesize any event on the first start
+		{
+			known: false,
+			list: &buildapi.BuildList{
+				Items: []buildapi.Build{build("a", "1")},
+			},
+			expectedChanges: map[string]bool{},
+		},
+		// should synthesize events for the added and modified objects only
+		{
+			known: true,
+			knownObjects: map[string]string{
+				"test/a": "1",
+				"test/b": "2",
+				"test/c": "3",
+			},
+			list: &buildapi.BuildList{
+				Items: []buildapi.Build{build("a", "1"), build("b", "5"), build("d", "6")},
+			},
+			expectedChanges: map[string]bool{
+				"b": false,
+				"d": true,
+			},
+		},
+	}
+
+	for count, test := range tests {
+		state := &WatchState{
+			Objects: test.knownObjects,
+			known:   test.known,
+		}
+		changes, err := state.Diff(test.list, "10")
+		if err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		if len(changes) != len(test.expectedChanges) {
+			t.Errorf("Test[%d] Failed: Expected %d changes but got %d", count, len(test.expectedChanges), len(changes))
+			continue
+		}
+		for _, change := range changes {
+			name := change.Object.(*buildapi.Build).Name
+			if added, found := test.expectedChanges[name]; !found || added != change.Added {
+				t.Errorf("Test[%d] Failed: Unexpected change %+v for %s", count, change, name)
+			}
+		}
+		if state.LastResourceVersion() != "10" {
+			t.Errorf("Test[%d] Failed: Expected resourceVersion '10' but got '%s'", count, state.LastResourceVersion())
+		}
+		if len(state.Objects) != len(test.list.Items) {
+			t.Errorf("Test[%d] Failed: Expected %d known objects but got %d", count, len(test.list.Items), len(state.Objects))
+		}
+	}
+}
+
+// countingStateStore counts the saves of a state, for the tests
+type countingStateStore struct {
+	mutex sync.Mutex
+	saves int
+	// err is returned by the saves, if set
+	err error
+}
+
+func (store *countingStateStore) Load(v interface{}) (bool, error) {
+	return false, nil
+}
+
+func (store *countingStateStore) Save(v interface{}) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.saves++
+	return store.err
+}
+
+func (store *countingStateStore) SetError(err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.err = err
+}
+
+func (store *countingStateStore) Saves() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.saves
+}
+
+// waitForSaves waits until the store has been saved the given number of times
+func waitForSaves(t *testing.T, store *countingStateStore, saves int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Saves() < saves {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d saves but got %d", saves, store.Saves())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchStateFlushPeriodically(t *testing.T) {
+	store := &countingStateStore{}
+	state, err := NewWatchState(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		state.flushPeriodically(time.Millisecond, stop)
+		close(stopped)
+	}()
+	state.Update(&buildapi.Build{ObjectMeta: kapi.ObjectMeta{Namespace: "test", Name: "a", ResourceVersion: "1"}}, false)
+	waitForSaves(t, store, 1)
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the periodic flush to stop")
+	}
+	state.Update(&buildapi.Build{ObjectMeta: kapi.ObjectMeta{Namespace: "test", Name: "b", ResourceVersion: "2"}}, false)
+	time.Sleep(10 * time.Millisecond)
+	if store.Saves() != 1 {
+		t.Errorf("Expected no more saves once stopped but got %d", store.Saves())
+	}
+}
+
+func TestWatchStateFlushRetry(t *testing.T) {
+	store := &countingStateStore{}
+	state, err := NewWatchState(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Update(&buildapi.Build{ObjectMeta: kapi.ObjectMeta{Namespace: "test", Name: "a", ResourceVersion: "1"}}, false)
+
+	store.SetError(errors.New("configmap update failed"))
+	if err := state.Flush(); err == nil {
+		t.Errorf("Expected the error of the store")
+	}
+	store.SetError(nil)
+	if err := state.Flush(); err != nil {
+		t.Errorf("Expected the state to be saved but got %v", err)
+	}
+	if store.Saves() != 2 {
+		t.Errorf("Expected the failed save to be retried but got %d saves", store.Saves())
+	}
+	if err := state.Flush(); err != nil || store.Saves() != 2 {
+		t.Errorf("Expected nothing more to save but got %d saves (%v)", store.Saves(), err)
+	}
+}
+
+func TestWatchStatePrune(t *testing.T) {
+	build := func(name, rv string) *buildapi.Build {
+		return &buildapi.Build{ObjectMeta: kapi.ObjectMeta{Namespace: "test", Name: name, ResourceVersion: rv}}
+	}
+	state, err := NewWatchState(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.maxObjects = 10
+
+	for i := 1; i <= 20; i++ {
+		state.Update(build(fmt.Sprintf("build-%d", i), fmt.Sprintf("%d", i)), false)
+	}
+	if len(state.Objects) > 10 {
+		t.Errorf("Expected at most 10 objects but got %d", len(state.Objects))
+	}
+	if _, found := state.Objects["test/build-20"]; !found {
+		t.Errorf("Expected the most recent object to be kept but got %v", state.Objects)
+	}
+
+	// the pruned objects are not reported as added - unless they have changed
+	list := &buildapi.BuildList{}
+	for i := 1; i <= 20; i++ {
+		list.Items = append(list.Items, *build(fmt.Sprintf("build-%d", i), fmt.Sprintf("%d", i)))
+	}
+	list.Items[0].ResourceVersion = "22"
+	list.Items = append(list.Items, *build("build-21", "21"))
+	changes, err := state.Diff(list, "22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, change := range changes {
+		names = append(names, change.Object.(*buildapi.Build).Name)
+	}
+	if len(names) != 2 || names[0] != "build-1" || names[1] != "build-21" {
+		t.Errorf("Expected only the changed and new objects but got %v", names)
+	}
+	if len(state.Objects) > 10 {
+		t.Errorf("Expected at most 10 objects after the diff but got %d", len(state.Objects))
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	buildutil "github.com/openshift/origin/pkg/build/util"
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	"k8s.io/kubernetes/pkg/fields"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// NotifyModeAll notifies every accepted event
+	NotifyModeAll = "all"
+	// NotifyModeStateChange notifies only the "broken", "still-failing" and "fixed" transitions
+	NotifyModeStateChange = "state-change"
+
+	DefaultStillFailingEvery = 5
+)
+
+const (
+	TransitionBroken       = "broken"
+	TransitionStillFailing = "still-failing"
+	TransitionFixed        = "fixed"
+	TransitionSuccess      = "success"
+	TransitionFailure      = "failure"
+)
+
+// BuildResultTracker tracks the result of the previous build of each BuildConfig,
+// to compute the transition of a new build result - like Jenkins does:
+// "broken" for the first failure after a success, "still-failing" every N consecutive failures (the Nth, the 2Nth...),
+// and "fixed" for the first success after failures.
+type BuildResultTracker struct {
+	StillFailingEvery int
+
+	factory clientcmd.Factory
+	mutex   sync.Mutex
+	results map[string]*buildConfigResult
+}
+
+type buildConfigResult struct {
+	success             bool
+	consecutiveFailures int
+	// lastBuild is the name of the last tracked build, so that repeated events are ignored
+	lastBuild string
+}
+
+func NewBuildResultTracker(factory clientcmd.Factory, stillFailingEvery int) *BuildResultTracker {
+	return &BuildResultTracker{
+		StillFailingEvery: stillFailingEvery,
+		factory:           factory,
+		results:           make(map[string]*buildConfigResult),
+	}
+}
+
+// IsStateChange returns true if the given transition should be notified in "state-change" mode
+func IsStateChange(transition string) bool {
+	switch transition {
+	case TransitionBroken, TransitionStillFailing, TransitionFixed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Record records the result of the given build, and returns its transition
+// or an empty string if the build is not finished (or has been cancelled)
+func (tracker *BuildResultTracker) Record(build *buildapi.Build) string {
+	var success bool
+	switch build.Status.Phase {
+	case buildapi.BuildPhaseComplete:
+		success = true
+	case buildapi.BuildPhaseFailed, buildapi.BuildPhaseError:
+		success = false
+	default:
+		return ""
+	}
+
+	configName := buildutil.ConfigNameForBuild(build)
+	if len(configName) == 0 {
+		if success {
+			return TransitionSuccess
+		}
+		return TransitionFailure
+	}
+	key := fmt.Sprintf("%s/%s", build.Namespace, configName)
+
+	tracker.mutex.Lock()
+	previous, found := tracker.results[key]
+	tracker.mutex.Unlock()
+	if !found {
+		previous = tracker.lookupPreviousResult(build, configName)
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if previous != nil && previous.lastBuild == build.Name {
+		// we already tracked this build
+		return transitionFor(previous)
+	}
+
+	current := &buildConfigResult{
+		success:   success,
+		lastBuild: build.Name,
+	}
+	if !success {
+		current.consecutiveFailures = 1
+		if previous != nil && !previous.success {
+			current.consecutiveFailures = previous.consecutiveFailures + 1
+		}
+	}
+	tracker.results[key] = current
+
+	switch {
+	case success && previous != nil && !previous.success:
+		return TransitionFixed
+	case success:
+		return TransitionSuccess
+	case previous == nil || previous.success:
+		return TransitionBroken
+	case tracker.StillFailingEvery > 0 && current.consecutiveFailures%tracker.StillFailingEvery == 0:
+		return TransitionStillFailing
+	default:
+		return TransitionFailure
+	}
+}
+
+func transitionFor(result *buildConfigResult) string {
+	if result.success {
+		return TransitionSuccess
+	}
+	return TransitionFailure
+}
+
+// lookupPreviousResult retrieves the result of the finished build of the same BuildConfig
+// that precedes the given build, or returns nil if there is none (or if it can't be retrieved)
+// This is used after a restart, when we don't know the previous result yet.
+func (tracker *BuildResultTracker) lookupPreviousResult(build *buildapi.Build, configName string) *buildConfigResult {
+	if tracker.factory.OpenShiftClientConfig == nil {
+		return nil
+	}
+
+	oclient, _, err := tracker.factory.Clients()
+	if err != nil {
+		glog.Warningf("Can't get openshift client: %v", err)
+		return nil
+	}
+
+	builds, err := oclient.Builds(build.Namespace).List(buildutil.BuildConfigSelector(configName), fields.Everything())
+	if err != nil {
+		glog.Warningf("Failed to list the builds of %s/%s: %v", build.Namespace, configName, err)
+		return nil
+	}
+
+	// keep only the builds created before this one, from the most recent to the oldest
+	previousBuilds := []buildapi.Build{}
+	for _, b := range builds.Items {
+		if b.Name != build.Name && b.CreationTimestamp.Before(build.CreationTimestamp) {
+			previousBuilds = append(previousBuilds, b)
+		}
+	}
+	sort.Sort(buildsByCreationTimestampDesc(previousBuilds))
+
+	var result *buildConfigResult
+	for _, b := range previousBuilds {
+		switch b.Status.Phase {
+		case buildapi.BuildPhaseComplete:
+			if result == nil {
+				return &buildConfigResult{success: true, lastBuild: b.Name}
+			}
+			return result
+		case buildapi.BuildPhaseFailed, buildapi.BuildPhaseError:
+			if result == nil {
+				result = &buildConfigResult{lastBuild: b.Name}
+			}
+			result.consecutiveFailures++
+		}
+	}
+	return result
+}
+
+type buildsByCreationTimestampDesc []buildapi.Build
+
+func (b buildsByCreationTimestampDesc) Len() int      { return len(b) }
+func (b buildsByCreationTimestampDesc) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b buildsByCreationTimestampDesc) Less(i, j int) bool {
+	return b[j].CreationTimestamp.Before(b[i].CreationTimestamp)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+)
+
+func TestBuildResultTrackerRecord(t *testing.T) {
+	tracker := NewBuildResultTracker(clientcmd.Factory{}, 2)
+
+	build := func(name string, phase buildapi.BuildPhase) *buildapi.Build {
+		return &buildapi.Build{
+			ObjectMeta: kapi.ObjectMeta{
+				Namespace: "test",
+				Name:      name,
+				Labels: map[string]string{
+					buildapi.BuildConfigLabel: "app",
+				},
+			},
+			Status: buildapi.BuildStatus{
+				Phase: phase,
+			},
+		}
+	}
+
+	tests := []struct {
+		build              *buildapi.Build
+		expectedTransition string
+	}{
+		// should ignore unfinished builds
+		{build: build("app-1", buildapi.BuildPhaseRunning), expectedTransition: ""},
+		{build: build("app-1", buildapi.BuildPhaseComplete), expectedTransition: TransitionSuccess},
+		// should ignore repeated events for the same build
+		{build: build("app-1", buildapi.BuildPhaseComplete), expectedTransition: TransitionSuccess},
+		{build: build("app-2", buildapi.BuildPhaseFailed), expectedTransition: TransitionBroken},
+		{build: build("app-3", buildapi.BuildPhaseError), expectedTransition: TransitionStillFailing},
+		{build: build("app-4", buildapi.BuildPhaseFailed), expectedTransition: TransitionFailure},
+		{build: build("app-5", buildapi.BuildPhaseFailed), expectedTransition: TransitionStillFailing},
+		// should ignore cancelled builds
+		{build: build("app-6", buildapi.BuildPhaseCancelled), expectedTransition: ""},
+		{build: build("app-7", buildapi.BuildPhaseComplete), expectedTransition: TransitionFixed},
+		{build: build("app-8", buildapi.BuildPhaseComplete), expectedTransition: TransitionSuccess},
+	}
+
+	for count, test := range tests {
+		result := tracker.Record(test.build)
+		if result != test.expectedTransition {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedTransition, result)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// ConfigError is a problem of the configuration, at a path such as "notifiers.ops.subjectTemplate"
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (err ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Path, err.Message)
+}
+
+// ConfigErrors are all the problems found in a configuration
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "%d problem(s) in the configuration:", len(errs))
+	for _, err := range errs {
+		fmt.Fprintf(buffer, "\n  - %s", err.Error())
+	}
+	return buffer.String()
+}
+
+// buildPhases are the phases that can be used in watchForBuildPhase
+var buildPhases = []string{
+	string(buildapi.BuildPhaseNew),
+	string(buildapi.BuildPhasePending),
+	string(buildapi.BuildPhaseRunning),
+	string(buildapi.BuildPhaseComplete),
+	string(buildapi.BuildPhaseFailed),
+	string(buildapi.BuildPhaseError),
+	string(buildapi.BuildPhaseCancelled),
+}
+
+// ValidateAppConfig checks the given configuration - with its defaults set - without side effects:
+// it doesn't call the API nor touch the disk. All the problems are reported, with their config path.
+// The templates are checked by rendering sample events.
+func ValidateAppConfig(config *AppConfig) error {
+	validator := &configValidator{config: config}
+	validator.validate()
+	if len(validator.errors) > 0 {
+		return validator.errors
+	}
+	return nil
+}
+
+// CheckConfigKeys reports the keys of the given settings - as read by viper - which are not part of the configuration,
+// such as typos in the name of an option
+func CheckConfigKeys(settings map[string]interface{}) error {
+	errs := ConfigErrors{}
+	checkConfigKeys("", settings, reflect.TypeOf(AppConfig{}), &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func checkConfigKeys(configPath string, value interface{}, t reflect.Type, errs *ConfigErrors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for _, key := range settingsKeys(value) {
+			field, found := fieldByKey(t, key)
+			if !found {
+				fieldNames := []string{}
+				for i := 0; i < t.NumField(); i++ {
+					fieldNames = append(fieldNames, lowerFirst(t.Field(i).Name))
+				}
+				*errs = append(*errs, ConfigError{joinConfigPath(configPath, key), "unknown option" + didYouMean(key, fieldNames)})
+				continue
+			}
+			checkConfigKeys(joinConfigPath(configPath, lowerFirst(field.Name)), settingsValue(value, key), field.Type, errs)
+		}
+	case reflect.Map:
+		for _, key := range settingsKeys(value) {
+			checkConfigKeys(joinConfigPath(configPath, key), settingsValue(value, key), t.Elem(), errs)
+		}
+	case reflect.Slice:
+		if items, ok := value.([]interface{}); ok {
+			for i, item := range items {
+				checkConfigKeys(fmt.Sprintf("%s[%d]", configPath, i), item, t.Elem(), errs)
+			}
+		}
+	}
+}
+
+// settingsKeys returns the sorted keys of a map read by viper - which may be keyed by strings or interfaces
+func settingsKeys(value interface{}) []string {
+	keys := []string{}
+	switch m := value.(type) {
+	case map[string]interface{}:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[interface{}]interface{}:
+		for key := range m {
+			keys = append(keys, fmt.Sprintf("%v", key))
+		}
+	}
+	return sortStrings(keys)
+}
+
+func settingsValue(value interface{}, key string) interface{} {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m[key]
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			if fmt.Sprintf("%v", k) == key {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// fieldByKey returns the field of the struct matching the key, case-insensitively - as viper does
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.EqualFold(t.Field(i).Name, key) {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+type configValidator struct {
+	config *AppConfig
+	errors ConfigErrors
+}
+
+func (validator *configValidator) add(configPath string, format string, args ...interface{}) {
+	validator.errors = append(validator.errors, ConfigError{configPath, fmt.Sprintf(format, args...)})
+}
+
+func (validator *configValidator) check(configPath string, err error) {
+	if err != nil {
+		validator.add(configPath, "%v", err)
+	}
+}
+
+func (validator *configValidator) validate() {
+	config := validator.config
+
+	// with ConfigMaps, the watchers may all be defined in the ConfigMaps
+	if !config.HasWatchers() && !config.ConfigMaps.Enabled {
+		validator.add("buildsWatchers", "no watchers have been defined")
+	}
+	if !config.HasNotifiers() {
+		validator.add("notifiers", "no notifiers have been defined")
+	}
+
+	for i, rule := range config.Routing.Rules {
+		rulePath := fmt.Sprintf("routing.rules[%d]", i)
+		if len(rule.Notifiers) == 0 {
+			validator.add(rulePath+".notifiers", "a routing rule needs at least 1 notifier")
+		}
+		_, err := ParseExpression(rule.Expression)
+		validator.check(rulePath+".expression", err)
+		validator.notifierNames(rulePath+".notifiers", rule.Notifiers)
+	}
+	validator.notifierNames("routing.defaultNotifiers", config.Routing.DefaultNotifiers)
+	validator.quietWindows("quietWindows", config.QuietWindows)
+	if config.Http.Enabled {
+		validator.duration("http.watchTimeout", config.Http.WatchTimeout)
+		validator.duration("http.notifierFailureTimeout", config.Http.NotifierFailureTimeout)
+	}
+
+	for _, name := range sortedKeys(config.Notifiers) {
+		validator.notifier(joinConfigPath("notifiers", name), config.Notifiers[name])
+	}
+	for _, name := range sortedKeys(config.BuildsWatchers) {
+		validator.watcher(joinConfigPath("buildsWatchers", name), config.BuildsWatchers[name])
+	}
+	for _, name := range sortedKeys(config.Reports) {
+		validator.report(joinConfigPath("reports", name), config.Reports[name])
+	}
+}
+
+func (validator *configValidator) notifier(configPath string, config *NotifierConfig) {
+	switch config.Type {
+	case FlowdockNotifierType:
+		validator.duration(configPath+".retryInitialBackoff", config.RetryInitialBackoff)
+		validator.duration(configPath+".retryMaxBackoff", config.RetryMaxBackoff)
+		validator.duration(configPath+".retryMaxAge", config.RetryMaxAge)
+	case JsonNotifierType:
+	default:
+		validator.add(configPath+".type", "unknown notifier type %s%s", config.Type, didYouMean(config.Type, []string{FlowdockNotifierType, JsonNotifierType}))
+	}
+
+	switch OverflowPolicy(config.OverflowPolicy) {
+	case OverflowBlock, OverflowDropOldest, OverflowDropNewest:
+	default:
+		validator.add(configPath+".overflowPolicy", "unknown overflow policy %s%s", config.OverflowPolicy,
+			didYouMean(config.OverflowPolicy, []string{string(OverflowBlock), string(OverflowDropOldest), string(OverflowDropNewest)}))
+	}
+	if len(config.BatchWindow) > 0 {
+		validator.duration(configPath+".batchWindow", config.BatchWindow)
+	}
+	validator.quietWindows(configPath+".quietWindows", config.QuietWindows)
+
+	buildEvent := NewSampleBuildEvent()
+	validator.template(configPath, "subjectTemplate", config.SubjectTemplate, buildEvent)
+	validator.template(configPath, "contentTemplate", config.ContentTemplate, buildEvent)
+	for i, tag := range config.Tags {
+		validator.template(configPath, fmt.Sprintf("tags[%d]", i), tag, buildEvent)
+	}
+	digest := NewSampleDigestEvent()
+	validator.template(configPath, "digestSubjectTemplate", config.DigestSubjectTemplate, digest)
+	validator.template(configPath, "digestContentTemplate", config.DigestContentTemplate, digest)
+	report := NewSampleBuildsReport()
+	validator.template(configPath, "reportSubjectTemplate", config.ReportSubjectTemplate, report)
+	validator.template(configPath, "reportContentTemplate", config.ReportContentTemplate, report)
+}
+
+func (validator *configValidator) watcher(configPath string, config *BuildsWatcherConfig) {
+	validator.notifierNames(configPath+".notifiers", config.Notifiers)
+	if _, found := validator.config.Notifiers[config.AnnotationsNotifier]; config.Annotations && !found {
+		validator.add(configPath+".annotationsNotifier", "unknown notifier %s%s", config.AnnotationsNotifier, didYouMean(config.AnnotationsNotifier, sortedKeys(validator.config.Notifiers)))
+	}
+
+	for _, phase := range sortedKeys(config.WatchForBuildPhase) {
+		if !containsString(buildPhases, phase) {
+			validator.add(joinConfigPath(configPath+".watchForBuildPhase", phase), "unknown build phase %s%s", phase, didYouMean(phase, buildPhases))
+		}
+	}
+
+	switch config.NotifyMode {
+	case NotifyModeAll, NotifyModeStateChange:
+	default:
+		validator.add(configPath+".notifyMode", "unknown notify mode %s%s", config.NotifyMode, didYouMean(config.NotifyMode, []string{NotifyModeAll, NotifyModeStateChange}))
+	}
+
+	if _, err := labels.Parse(config.LabelSelector); err != nil {
+		validator.add(configPath+".labelSelector", "invalid label selector %s: %v", config.LabelSelector, err)
+	}
+	if len(config.FieldSelector) > 0 {
+		if _, err := fields.ParseSelector(config.FieldSelector); err != nil {
+			validator.add(configPath+".fieldSelector", "invalid field selector %s: %v", config.FieldSelector, err)
+		}
+	}
+	for key, patterns := range map[string][]string{"includeBuildConfigs": config.IncludeBuildConfigs, "excludeBuildConfigs": config.ExcludeBuildConfigs} {
+		for i, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				validator.add(fmt.Sprintf("%s.%s[%d]", configPath, key, i), "invalid pattern %s: %v", pattern, err)
+			}
+		}
+	}
+	for key, exprs := range map[string][]string{"includeNamespaces": config.IncludeNamespaces, "excludeNamespaces": config.ExcludeNamespaces} {
+		for i, expr := range exprs {
+			_, err := compileAnchoredRegexps([]string{expr})
+			validator.check(fmt.Sprintf("%s.%s[%d]", configPath, key, i), err)
+		}
+	}
+
+	validator.duration(configPath+".dedupTTL", config.DedupTTL)
+	if len(config.BatchWindow) > 0 {
+		validator.duration(configPath+".batchWindow", config.BatchWindow)
+	}
+	validator.quietWindows(configPath+".quietWindows", config.QuietWindows)
+}
+
+func (validator *configValidator) report(configPath string, config *ReportConfig) {
+	validator.notifierNames(configPath+".notifiers", config.Notifiers)
+	_, err := ParseCronSchedule(config.Schedule, config.Timezone)
+	validator.check(configPath+".schedule", err)
+	validator.duration(configPath+".period", config.Period)
+}
+
+// notifierNames checks that the given notifiers are defined
+func (validator *configValidator) notifierNames(configPath string, names []string) {
+	for i, name := range names {
+		if _, found := validator.config.Notifiers[name]; found {
+			continue
+		}
+		validator.add(fmt.Sprintf("%s[%d]", configPath, i), "unknown notifier %s%s", name, didYouMean(name, sortedKeys(validator.config.Notifiers)))
+	}
+}
+
+func (validator *configValidator) quietWindows(configPath string, windows []QuietWindowConfig) {
+	for i, window := range windows {
+		name := window.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", i)
+		}
+		_, err := NewQuietWindow(name, window)
+		validator.check(fmt.Sprintf("%s[%d]", configPath, i), err)
+	}
+}
+
+func (validator *configValidator) duration(configPath string, value string) {
+	if _, err := time.ParseDuration(value); err != nil {
+		validator.add(configPath, "invalid duration %s: %v", value, err)
+	}
+}
+
+// template checks that the template compiles, and renders the given sample event
+func (validator *configValidator) template(configPath string, key string, source string, sample Event) {
+	tmpl, err := template.New(key).Parse(source)
+	if err != nil {
+		validator.add(configPath+"."+key, "invalid template: %v", err)
+		return
+	}
+	if _, err := executeTemplate(tmpl, sample); err != nil {
+		validator.add(configPath+"."+key, "failed to render a sample %s: %v", sample.ObjectType(), err)
+	}
+}
+
+// NewSampleBuild returns a failed build, with sample values
+func NewSampleBuild() *buildapi.Build {
+	start := unversioned.NewTime(time.Date(2016, time.January, 1, 12, 0, 0, 0, time.UTC))
+	end := unversioned.NewTime(start.Add(3 * time.Minute))
+	return &buildapi.Build{
+		ObjectMeta: kapi.ObjectMeta{
+			Namespace:         "sample-project",
+			Name:              "sample-app-1",
+			CreationTimestamp: start,
+			Labels: map[string]string{
+				buildapi.BuildConfigLabel: "sample-app",
+				"app":                     "sample-app",
+			},
+			Annotations: map[string]string{},
+		},
+		Spec: buildapi.BuildSpec{
+			Source: buildapi.BuildSource{
+				Git: &buildapi.GitBuildSource{
+					URI: "https://github.com/sample/sample-app.git",
+					Ref: "master",
+				},
+			},
+			Revision: &buildapi.SourceRevision{
+				Git: &buildapi.GitSourceRevision{
+					Commit:  "0123456789abcdef0123456789abcdef01234567",
+					Author:  buildapi.SourceControlUser{Name: "Sample Author", Email: "author@example.com"},
+					Message: "Sample commit",
+				},
+			},
+		},
+		Status: buildapi.BuildStatus{
+			Phase:                      buildapi.BuildPhaseFailed,
+			Message:                    "Sample failure",
+			StartTimestamp:             &start,
+			CompletionTimestamp:        &end,
+			Duration:                   end.Sub(start.Time),
+			OutputDockerImageReference: "172.30.0.1:5000/sample-project/sample-app:latest",
+		},
+	}
+}
+
+// NewSampleBuildEvent returns the event of the sample build, which doesn't call the API
+func NewSampleBuildEvent() Event {
+	return NewOfflineBuildEvent(NewSampleBuild(), TransitionBroken)
+}
+
+// NewSampleDigestEvent returns a digest of sample build events
+func NewSampleDigestEvent() Event {
+	event := NewSampleBuildEvent()
+	end := event.ObjectEndTime().Time
+	return NewDigestEvent([]Event{event, event}, end.Add(-5*time.Minute), end)
+}
+
+// NewSampleBuildsReport returns a report on sample builds
+func NewSampleBuildsReport() Event {
+	build := *NewSampleBuild()
+	complete := build
+	complete.Name = "sample-app-2"
+	complete.Status.Phase = buildapi.BuildPhaseComplete
+	end := build.CreationTimestamp.Add(time.Hour)
+	return NewBuildsReport(build.Namespace, []buildapi.Build{build, complete}, end.Add(-24*time.Hour), end, DefaultReportTopEntries, "https://openshift.example.com")
+}
+
+// didYouMean returns a suggestion of the candidate closest to the given value - if it is close enough - or an empty string
+func didYouMean(value string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		distance := editDistance(strings.ToLower(value), strings.ToLower(candidate))
+		if distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance is the Levenshtein distance between 2 strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func joinConfigPath(configPath string, key string) string {
+	if len(configPath) == 0 {
+		return key
+	}
+	return configPath + "." + key
+}
+
+func lowerFirst(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+func sortStrings(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
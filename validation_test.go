@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	buildapi "github.com/openshift/origin/pkg/build/api"
+
+	"github.com/spf13/viper"
+)
+
+func TestValidateAppConfig(t *testing.T) {
+	tests := []struct {
+		config        *AppConfig
+		expectedPaths []string
+	}{
+		{
+			config: &AppConfig{
+				BuildsWatchers: map[string]*BuildsWatcherConfig{
+					"all": {AllNamespaces: true, Notifiers: []string{"default"}},
+				},
+				Notifiers: map[string]*NotifierConfig{
+					"default": {Tags: []string{"{{.Namespace}}", "{{.Build.Spec.Revision.Git.Author.Name}}"}},
+				},
+				Reports: map[string]*ReportConfig{
+					"daily": {AllNamespaces: true},
+				},
+			},
+			expectedPaths: []string{},
+		},
+		// should report all the problems
+		{
+			config: &AppConfig{
+				BuildsWatchers: map[string]*BuildsWatcherConfig{
+					"all": {
+						AllNamespaces: true,
+						Notifiers:     []string{"default", "defualt"},
+						WatchForBuildPhase: map[buildapi.BuildPhase]bool{
+							"Faild": true,
+						},
+						NotifyMode: "changes",
+					},
+				},
+				Notifiers: map[string]*NotifierConfig{
+					"default": {
+						SubjectTemplate: "{{.Name",
+						Tags:            []string{"{{.Namespace}}", "{{.Unknown}}"},
+						BatchWindow:     "5 minutes",
+					},
+					"ops": {Type: "flowdoc"},
+				},
+				Routing: RoutingConfig{
+					Rules: []RoutingRuleConfig{
+						{Expression: `namespace ==`, Notifiers: []string{"opps"}},
+					},
+				},
+			},
+			expectedPaths: []string{
+				"routing.rules[0].expression",
+				"routing.rules[0].notifiers[0]",
+				"notifiers.default.batchWindow",
+				"notifiers.default.subjectTemplate",
+				"notifiers.default.tags[1]",
+				"notifiers.ops.type",
+				"buildsWatchers.all.notifiers[1]",
+				"buildsWatchers.all.watchForBuildPhase.Faild",
+				"buildsWatchers.all.notifyMode",
+			},
+		},
+		{
+			config:        &AppConfig{},
+			expectedPaths: []string{"buildsWatchers", "notifiers", "routing.defaultNotifiers[0]"},
+		},
+	}
+
+	for count, test := range tests {
+		test.config.SetDefaults()
+		err := ValidateAppConfig(test.config)
+		paths := []string{}
+		if err != nil {
+			for _, configErr := range err.(ConfigErrors) {
+				paths = append(paths, configErr.Path)
+			}
+		}
+		if !reflect.DeepEqual(paths, test.expectedPaths) {
+			t.Errorf("Test[%d] Failed: Expected paths '%v' but got '%v' (%v)", count, test.expectedPaths, paths, err)
+		}
+	}
+}
+
+func TestCheckConfigKeys(t *testing.T) {
+	tests := []struct {
+		config        string
+		expectedError string
+	}{
+		{
+			config: `
+buildsWatchers:
+  all:
+    allNamespaces: true
+    watchForBuildPhase:
+      Failed: true
+    quietWindows:
+    - schedule: "0 22 * * *"
+      duration: 10h
+notifiers:
+  default:
+    tokenSecret:
+      name: flowdock
+`,
+		},
+		{
+			config: `
+buildsWatchers:
+  all:
+    allNamespace: true
+    quietWindows:
+    - schedule: "0 22 * * *"
+      durtion: 10h
+notifier:
+  default: {}
+`,
+			expectedError: `3 problem(s) in the configuration:
+  - buildsWatchers.all.allNamespace: unknown option (did you mean allNamespaces?)
+  - buildsWatchers.all.quietWindows[0].durtion: unknown option (did you mean duration?)
+  - notifier: unknown option (did you mean notifiers?)`,
+		},
+	}
+
+	for count, test := range tests {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		if err := v.ReadConfig(bytes.NewBufferString(test.config)); err != nil {
+			t.Errorf("Test[%d] Failed: %v", count, err)
+			continue
+		}
+		errorMessage := ""
+		if err := CheckConfigKeys(v.AllSettings()); err != nil {
+			errorMessage = err.Error()
+		}
+		if errorMessage != test.expectedError {
+			t.Errorf("Test[%d] Failed: Expected '%v' but got '%v'", count, test.expectedError, errorMessage)
+		}
+	}
+}
@@ -2,78 +2,438 @@ package main
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
+	buildutil "github.com/openshift/origin/pkg/build/util"
 	"github.com/openshift/origin/pkg/cmd/util/clientcmd"
 
 	"k8s.io/kubernetes/pkg/kubectl/resource"
+	"k8s.io/kubernetes/pkg/labels"
 	"k8s.io/kubernetes/pkg/watch"
 
 	"github.com/golang/glog"
 )
 
+const (
+	// the delay before a new attempt to watch, when the API refuses the watch - doubled on each failure
+	watchRetryInitialDelay = time.Second
+	watchRetryMaxDelay     = time.Minute
+)
+
 type Watcher interface {
-	Watch(clientcmd.Factory, map[string]FlowdockNotifier) error
+	Watch(clientcmd.Factory, *Dispatcher) error
 }
 
 type BuildsWatcher struct {
 	Name   string
 	Config BuildsWatcherConfig
+	// Shards is optional: if set, only the events of the namespaces owned by this replica are notified
+	Shards *ShardCoordinator
+	// GlobalQuietWindows are applied in addition to the quiet windows of the watcher
+	GlobalQuietWindows []QuietWindowConfig
+	// Filter is optional: if set, only the builds it accepts are notified
+	Filter *BuildFilter
+	// Router routes the events if the watcher has no static list of notifiers
+	Router *Router
+	// FlowNotifiers creates the notifiers of the flows defined by annotations
+	FlowNotifiers *FlowNotifiers
+	// Recorder is optional: if set, the events received from the API are recorded
+	Recorder *EventRecorder
+	// Replay is optional: if set, the recorded events are replayed instead of watching the API
+	// The events are rendered without calling the API, and the state of the watcher is kept in memory only.
+	Replay *Replay
+
+	// Status is the state of the watch, reported by the health endpoints
+	Status *WatcherStatus
+
+	// the state of the watch, kept in memory so that it can be resumed by a new watcher
+	state    *WatchState
+	tracker  *PhaseTracker
+	outcomes *PhaseTracker
+	results  *BuildResultTracker
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 func NewBuildsWatcher(name string, config BuildsWatcherConfig) *BuildsWatcher {
 	return &BuildsWatcher{
 		Name:   name,
 		Config: config,
+		Status: NewWatcherStatus(),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Stop stops the watch: Watch returns (nil) once the current event has been handled
+func (watcher *BuildsWatcher) Stop() {
+	watcher.stopOnce.Do(func() {
+		close(watcher.stop)
+	})
+}
+
+// Resume re-uses the in-memory state of a previous - stopped - watcher, if it watched the same builds
+// so that a restarted watcher doesn't notify again what has already been notified.
+func (watcher *BuildsWatcher) Resume(previous *BuildsWatcher) {
+	current, last := watcher.Config, previous.Config
+	if current.Namespace != last.Namespace || current.AllNamespaces != last.AllNamespaces || current.LabelSelector != last.LabelSelector {
+		return
+	}
+	if current.StatePath == last.StatePath {
+		watcher.state = previous.state
+	}
+	watcher.Status = previous.Status
+	if current.DedupStatePath == last.DedupStatePath && current.DedupTTL == last.DedupTTL && current.DedupMaxEntries == last.DedupMaxEntries {
+		watcher.tracker = previous.tracker
+	}
+	watcher.outcomes = previous.outcomes
+	if current.StillFailingEvery == last.StillFailingEvery {
+		watcher.results = previous.results
 	}
 }
 
-func (watcher *BuildsWatcher) Watch(factory clientcmd.Factory, notifiers map[string]FlowdockNotifier) error {
-	channels := []chan Event{}
+// Validate checks the config of the watcher, without calling the API
+func (watcher *BuildsWatcher) Validate() error {
+	switch watcher.Config.NotifyMode {
+	case NotifyModeAll, NotifyModeStateChange:
+	default:
+		return fmt.Errorf("unknown notify mode %s for watcher %s", watcher.Config.NotifyMode, watcher.Name)
+	}
+	if _, err := labels.Parse(watcher.Config.LabelSelector); err != nil {
+		return fmt.Errorf("invalid labelSelector %s for watcher %s: %v", watcher.Config.LabelSelector, watcher.Name, err)
+	}
+	if _, err := NewBuildFilter(watcher.Config); err != nil {
+		return fmt.Errorf("invalid filters for watcher %s: %v", watcher.Name, err)
+	}
+	if _, err := time.ParseDuration(watcher.Config.DedupTTL); err != nil {
+		return fmt.Errorf("invalid dedupTTL %s for watcher %s: %v", watcher.Config.DedupTTL, watcher.Name, err)
+	}
+	if len(watcher.Config.BatchWindow) > 0 {
+		if _, err := NewBatcher(watcher.Config.BatchWindow, watcher.Config.BatchBypassFailures, nil); err != nil {
+			return fmt.Errorf("invalid batching for watcher %s: %v", watcher.Name, err)
+		}
+	}
+	if _, err := NewQuietGate(append(append([]QuietWindowConfig{}, watcher.GlobalQuietWindows...), watcher.Config.QuietWindows...), nil); err != nil {
+		return fmt.Errorf("invalid quiet windows for watcher %s: %v", watcher.Name, err)
+	}
+	return nil
+}
+
+func (watcher *BuildsWatcher) Watch(factory clientcmd.Factory, dispatcher *Dispatcher) error {
+	if err := watcher.Validate(); err != nil {
+		return err
+	}
+
+	notifierNames := []string{}
 	for _, notifierName := range watcher.Config.Notifiers {
-		if notifier, found := notifiers[notifierName]; found {
-			channels = append(channels, notifier.Channel)
+		if dispatcher.HasNotifier(notifierName) {
+			notifierNames = append(notifierNames, notifierName)
 		}
 	}
+	route := func(event Event) []string {
+		return notifierNames
+	}
+
+	if len(watcher.Config.Notifiers) == 0 && watcher.Router != nil {
+		route = watcher.Router.Route
+		notifierNames = watcher.Router.Notifiers()
+	}
 
-	if len(channels) == 0 {
+	if len(notifierNames) == 0 {
 		return fmt.Errorf("no notifiers for watcher %s !", watcher.Name)
 	}
 
-	callback := func(event watch.Event) {
+	var annotations *AnnotationsCache
+	if watcher.Config.Annotations && watcher.Replay != nil {
+		glog.Warningf("The annotations of the namespaces and the BuildConfigs are ignored by watcher %s when replaying events", watcher.Name)
+	} else if watcher.Config.Annotations {
+		if watcher.FlowNotifiers == nil {
+			return fmt.Errorf("no flow notifiers for watcher %s !", watcher.Name)
+		}
+		var err error
+		annotations, err = NewAnnotationsCache(factory, watcher.Config.Namespace, watcher.Config.AllNamespaces, watcher.stop)
+		if err != nil {
+			return fmt.Errorf("failed to watch the annotations for watcher %s: %v", watcher.Name, err)
+		}
+		staticRoute := route
+		route = func(event Event) []string {
+			// the events are routed before being batched or held, so they are the build events
+			buildEvent, isBuildEvent := event.(*BuildEvent)
+			if !isBuildEvent {
+				return staticRoute(event)
+			}
+			settings := annotations.Settings(buildEvent.Build)
+			if len(settings.Flows) == 0 {
+				return staticRoute(event)
+			}
+			flowNotifierNames := []string{}
+			for _, flow := range settings.Flows {
+				name, err := watcher.FlowNotifiers.Notifier(watcher.Config.AnnotationsNotifier, buildEvent.Namespace(), settings.Secret, flow)
+				if err != nil {
+					glog.Errorf("Failed to create the notifier for flow %s of namespace %s: %v", flow, buildEvent.Namespace(), err)
+					continue
+				}
+				flowNotifierNames = append(flowNotifierNames, name)
+			}
+			return flowNotifierNames
+		}
+	}
+
+	filter, err := NewBuildFilter(watcher.Config)
+	if err != nil {
+		return fmt.Errorf("invalid filters for watcher %s: %v", watcher.Name, err)
+	}
+	watcher.Filter = filter
+
+	tracker := watcher.tracker
+	if tracker == nil {
+		var dedupStore StateStore
+		if len(watcher.Config.DedupStatePath) > 0 && watcher.Replay == nil {
+			dedupStore, err = NewStateStore(factory, watcher.Config.DedupStatePath, watcher.Name+"-dedup")
+			if err != nil {
+				return err
+			}
+		}
+		tracker, err = NewPhaseTracker(watcher.Config.DedupMaxEntries, watcher.Config.DedupTTL, dedupStore)
+		if err != nil {
+			return fmt.Errorf("failed to load the notified phases of watcher %s: %v", watcher.Name, err)
+		}
+		watcher.tracker = tracker
+	}
+
+	// outcomes counts each finished build once in the metrics, whatever the filters of the watcher
+	outcomes := watcher.outcomes
+	if outcomes == nil {
+		outcomes, err = NewPhaseTracker(watcher.Config.DedupMaxEntries, watcher.Config.DedupTTL, nil)
+		if err != nil {
+			return err
+		}
+		watcher.outcomes = outcomes
+	}
+
+	results := watcher.results
+	if results == nil {
+		resultsFactory := factory
+		if watcher.Replay != nil {
+			// without a client config, the previous results are not looked up
+			resultsFactory = clientcmd.Factory{}
+		}
+		results = NewBuildResultTracker(resultsFactory, watcher.Config.StillFailingEvery)
+		watcher.results = results
+	}
+
+	// the events are routed first, and then batched and held separately for each notifier:
+	// a digest has no labels nor annotations to be routed with
+	quietWindows := append(append([]QuietWindowConfig{}, watcher.GlobalQuietWindows...), watcher.Config.QuietWindows...)
+	pipelined := len(watcher.Config.BatchWindow) > 0 || len(quietWindows) > 0 || watcher.Config.QuietNamespaceAnnotations
+	var namespaceWindow func(namespace string) (time.Time, string)
+	if watcher.Config.QuietNamespaceAnnotations && watcher.Replay == nil {
+		namespaceWindow = NewNamespaceQuietWindows(factory).Window
+	}
+	var (
+		pipelinesMutex sync.Mutex
+		pipelines      = make(map[string]*notifierPipeline)
+	)
+	dispatch := func(event Event) {
+		notifierNames := route(event)
+		if !pipelined {
+			dispatcher.Dispatch(notifierNames, event)
+			return
+		}
+		for _, notifierName := range notifierNames {
+			pipelinesMutex.Lock()
+			pipeline, found := pipelines[notifierName]
+			if !found {
+				// the batch window and the quiet windows have already been validated
+				pipeline, _ = newNotifierPipeline(notifierName, watcher.Config.BatchWindow, watcher.Config.BatchBypassFailures, quietWindows, namespaceWindow, dispatcher)
+				pipelines[notifierName] = pipeline
+			}
+			pipelinesMutex.Unlock()
+			pipeline.handle(event)
+		}
+	}
+	flushBatches := func() {
+		pipelinesMutex.Lock()
+		defer pipelinesMutex.Unlock()
+		for _, pipeline := range pipelines {
+			if pipeline.batcher != nil {
+				pipeline.batcher.Flush()
+			}
+		}
+	}
+
+	// newEvent returns the build event to filter, and the event to dispatch
+	newEvent := func(event watch.Event) (*BuildEvent, Event) {
 		buildEvent := NewBuildEvent(factory, event)
-		if watcher.shouldAcceptEvent(buildEvent) {
-			glog.V(3).Infof("Accepting build event %+v", buildEvent)
-			for _, channel := range channels {
-				channel <- buildEvent
+		return buildEvent, buildEvent
+	}
+	if watcher.Replay != nil {
+		newEvent = func(event watch.Event) (*BuildEvent, Event) {
+			offlineEvent := newOfflineBuildEvent(event)
+			return offlineEvent.BuildEvent, offlineEvent
+		}
+	}
+
+	reject := func(reason string) {
+		eventsRejected.WithLabelValues(watcher.Name, reason).Inc()
+	}
+	callback := func(event watch.Event) {
+		watcher.Status.Event()
+		eventsReceived.WithLabelValues(watcher.Name, string(event.Type)).Inc()
+		buildEvent, dispatched := newEvent(event)
+		if event.Type == watch.Deleted {
+			tracker.Forget(string(buildEvent.Build.UID))
+			outcomes.Forget(string(buildEvent.Build.UID))
+		} else if buildutil.IsBuildComplete(buildEvent.Build) && outcomes.ShouldNotify(string(buildEvent.Build.UID), buildEvent.Status()) {
+			buildOutcomes.WithLabelValues(watcher.Name, buildEvent.Namespace(), buildEvent.Status()).Inc()
+			// the results are recorded whatever the filters, so that the filtered failures count in the streaks
+			buildEvent.transition = results.Record(buildEvent.Build)
+		}
+		if watcher.Status.Paused() {
+			glog.V(3).Infof("NOT accepting build event %+v: watcher %s is paused", buildEvent, watcher.Name)
+			reject("paused")
+			return
+		}
+		if reason := watcher.rejectReason(buildEvent); len(reason) > 0 {
+			glog.V(3).Infof("NOT accepting build event %+v: rejected by %s", buildEvent, reason)
+			reject(reason)
+			return
+		}
+		if annotations != nil {
+			settings := annotations.Settings(buildEvent.Build)
+			if settings.Muted {
+				glog.V(3).Infof("NOT accepting build event %+v: muted by annotation", buildEvent)
+				reject("muted")
+				return
 			}
-		} else {
-			glog.V(3).Infof("NOT accepting build event %+v", buildEvent)
+			if watcher.Config.AnnotationsOptIn && len(settings.Flows) == 0 {
+				glog.V(3).Infof("NOT accepting build event %+v: no %s annotation", buildEvent, FlowsAnnotation)
+				reject("opt-in")
+				return
+			}
+		}
+		if !tracker.ShouldNotify(string(buildEvent.Build.UID), buildEvent.Status()) {
+			glog.V(3).Infof("NOT accepting build event %+v: phase %s has already been notified", buildEvent, buildEvent.Status())
+			reject("duplicate")
+			return
+		}
+		if watcher.Config.NotifyMode == NotifyModeStateChange && !IsStateChange(buildEvent.transition) {
+			glog.V(3).Infof("NOT accepting build event %+v: %s is not a state change", buildEvent, buildEvent.transition)
+			reject("state-change")
+			return
+		}
+		// checked last, so that the notified phases and the build results are tracked for all the namespaces:
+		// after a rebalance, the new owner of a namespace knows what has already been notified
+		if watcher.Shards != nil && !watcher.Shards.Owns(buildEvent.Namespace()) {
+			glog.V(3).Infof("NOT accepting build event %+v: namespace %s is owned by %s", buildEvent, buildEvent.Namespace(), watcher.Shards.Owner(buildEvent.Namespace()))
+			reject("shard")
+			return
+		}
+		glog.V(3).Infof("Accepting build event %+v", buildEvent)
+		eventsAccepted.WithLabelValues(watcher.Name).Inc()
+		dispatch(dispatched)
+	}
+	if watcher.Recorder != nil {
+		handle := callback
+		callback = func(event watch.Event) {
+			if err := watcher.Recorder.Record(watcher.Name, event); err != nil {
+				glog.Warningf("Failed to record an event of watcher %s in %s: %v", watcher.Name, watcher.Recorder.Path, err)
+			}
+			handle(event)
+		}
+	}
+
+	glog.Infof("Watching builds - and notifying %d flows", len(notifierNames))
+
+	state := watcher.state
+	if state == nil {
+		var store StateStore
+		if len(watcher.Config.StatePath) > 0 && watcher.Replay == nil {
+			store, err = NewStateStore(factory, watcher.Config.StatePath, watcher.Name)
+			if err != nil {
+				return err
+			}
+		}
+		state, err = NewWatchState(store)
+		if err != nil {
+			return fmt.Errorf("failed to load the state of watcher %s: %v", watcher.Name, err)
 		}
+		watcher.state = state
 	}
+	// the state and the notified phases are saved periodically while watching, and once more when the watch stops
+	flushing := make(chan struct{})
+	defer close(flushing)
+	go state.flushPeriodically(DefaultStateFlushInterval, flushing)
+	go tracker.flushPeriodically(DefaultStateFlushInterval, flushing)
 
-	glog.Infof("Watching builds - and notifying %d flows", len(channels))
+	if watcher.Replay != nil {
+		watcher.Status.Connected()
+		if err := watcher.Replay.Run(watcher.Name, watcher.stop, callback); err != nil {
+			return fmt.Errorf("failed to replay the events of watcher %s: %v", watcher.Name, err)
+		}
+		// the replay is over: don't wait for the end of the batch windows
+		flushBatches()
+		glog.Infof("Stopped watcher %s", watcher.Name)
+		return nil
+	}
+
+	if err := watchResource(factory, watcher.Config.Namespace, watcher.Config.AllNamespaces, "build", watcher.Config.LabelSelector, state, watcher.Status, watcher.stop, callback); err != nil {
+		watcher.Status.Disconnected(err)
+		return err
+	}
 
-	return watchResource(factory, watcher.Config.Namespace, watcher.Config.AllNamespaces, "build", callback)
+	// stopped: save the state now, so that a new watcher with the same stores starts from there
+	if err := state.Flush(); err != nil {
+		glog.Warningf("Failed to save the watch state of watcher %s: %v", watcher.Name, err)
+	}
+	if err := tracker.Flush(); err != nil {
+		glog.Warningf("Failed to save the notified phases of watcher %s: %v", watcher.Name, err)
+	}
+	glog.Infof("Stopped watcher %s", watcher.Name)
+	return nil
 }
 
 func (watcher *BuildsWatcher) shouldAcceptEvent(buildEvent *BuildEvent) bool {
+	return len(watcher.rejectReason(buildEvent)) == 0
+}
+
+// rejectReason returns why the event is rejected by the config or the filters of the watcher,
+// or an empty string if it is accepted
+func (watcher *BuildsWatcher) rejectReason(buildEvent *BuildEvent) string {
 
 	switch buildEvent.Event.Type {
 	case watch.Deleted, watch.Error:
-		return false
+		return "event-type"
 	}
 
 	if shouldWatchForPhase, found := watcher.Config.WatchForBuildPhase[buildEvent.Build.Status.Phase]; found {
 		if !shouldWatchForPhase {
-			return false
+			return "phase"
 		}
 	}
 
-	return true
+	if watcher.Filter != nil && !watcher.Filter.Accept(buildEvent.Build) {
+		return "filter"
+	}
+
+	return ""
 }
 
-func watchResource(factory clientcmd.Factory, namespace string, allNamespaces bool, resourceType string, callback func(watch.Event)) error {
+// watchResource watches the given resource type, and calls the callback for each event.
+// It resumes from the last processed resourceVersion of the given state (if any),
+// and when it can't - on the first start, or when the resourceVersion is too old -
+// it diffs a fresh list against the known state to synthesize the missed events.
+// The status records the (re-)connections and the errors. It returns nil once the stop channel is closed.
+func watchResource(factory clientcmd.Factory, namespace string, allNamespaces bool, resourceType string, labelSelector string, state *WatchState, status *WatcherStatus, stop <-chan struct{}, callback func(watch.Event)) error {
+	retryDelay := watchRetryInitialDelay
 	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
 		var err error
 		mapper, typer := factory.Object()
 		clientMapper := factory.ClientMapperForCommand()
@@ -88,6 +448,7 @@ func watchResource(factory clientcmd.Factory, namespace string, allNamespaces bo
 		builder := resource.NewBuilder(mapper, typer, clientMapper).
 			DefaultNamespace().NamespaceParam(namespace).AllNamespaces(allNamespaces).
 			ResourceTypeOrNameArgs(true, resourceType).
+			SelectorParam(labelSelector).
 			SingleResourceType().
 			Latest()
 		r := builder.Do()
@@ -106,34 +467,120 @@ func watchResource(factory clientcmd.Factory, namespace string, allNamespaces bo
 		info := infos[0]
 		mapping := info.ResourceMapping()
 
-		obj, err := r.Object()
-		if err != nil {
-			return err
-		}
-		rv, err := mapping.MetadataAccessor.ResourceVersion(obj)
-		if err != nil {
-			return err
+		rv := state.LastResourceVersion()
+		if len(rv) > 0 {
+			glog.V(2).Infof("Resuming watch on %s resource type from resourceVersion %s", resourceType, rv)
+		} else {
+			obj, err := r.Object()
+			if err != nil {
+				return err
+			}
+			rv, err = mapping.MetadataAccessor.ResourceVersion(obj)
+			if err != nil {
+				return err
+			}
+
+			changes, err := state.Diff(obj, rv)
+			if err != nil {
+				return err
+			}
+			if len(changes) > 0 {
+				glog.Infof("Synthesizing %d missed events on %s resource type", len(changes), resourceType)
+			}
+			for _, change := range changes {
+				eventType := watch.Modified
+				if change.Added {
+					eventType = watch.Added
+				}
+				callback(watch.Event{
+					Type:   eventType,
+					Object: change.Object,
+				})
+			}
 		}
 
 		w, err := r.Watch(rv)
 		if err != nil {
-			return err
+			// most likely our resourceVersion is too old (410 Gone): start again from a fresh list
+			glog.Warningf("Failed to watch %s resource type from resourceVersion %s, restarting from a fresh list in %v: %v", resourceType, rv, retryDelay, err)
+			status.Disconnected(err)
+			state.Invalidate()
+			select {
+			case <-time.After(retryDelay):
+			case <-stop:
+				return nil
+			}
+			if retryDelay *= 2; retryDelay > watchRetryMaxDelay {
+				retryDelay = watchRetryMaxDelay
+			}
+			continue
 		}
+		retryDelay = watchRetryInitialDelay
+		status.Connected()
 
 		if allNamespaces {
 			glog.V(2).Infof("Starting watch loop on %s resource type for all namespaces", resourceType)
 		} else {
 			glog.V(2).Infof("Starting watch loop on %s resource type for namespace %s", resourceType, namespace)
 		}
+	events:
 		for {
-			event, open := <-w.ResultChan()
+			var event watch.Event
+			var open bool
+			select {
+			case event, open = <-w.ResultChan():
+			case <-stop:
+				w.Stop()
+				return nil
+			}
 			if !open {
 				glog.Warningf("Watch channel has been closed!")
-				break
+				status.Disconnected(nil)
+				break events
 			}
 			glog.V(3).Infof("Got event %v for %T", event.Type, event.Object)
+			if event.Type == watch.Error {
+				// most likely our resourceVersion is too old: start again from a fresh list
+				glog.Warningf("Got an error event while watching %s resource type, restarting from a fresh list: %v", resourceType, event.Object)
+				status.Disconnected(fmt.Errorf("error event: %v", event.Object))
+				state.Invalidate()
+				w.Stop()
+				break events
+			}
 			callback(event)
+			state.Update(event.Object, event.Type == watch.Deleted)
 		}
 		glog.V(2).Infof("End of watch loop on %s resource type for namespace %s", resourceType, namespace)
 	}
 }
+
+// notifierPipeline batches and holds the events of a watcher for a single notifier
+type notifierPipeline struct {
+	batcher *Batcher
+	handle  func(Event)
+}
+
+func newNotifierPipeline(notifierName string, batchWindow string, batchBypassFailures bool, quietWindows []QuietWindowConfig, namespaceWindow func(string) (time.Time, string), dispatcher *Dispatcher) (*notifierPipeline, error) {
+	pipeline := &notifierPipeline{
+		handle: func(event Event) {
+			dispatcher.Dispatch([]string{notifierName}, event)
+		},
+	}
+	if len(batchWindow) > 0 {
+		batcher, err := NewBatcher(batchWindow, batchBypassFailures, pipeline.handle)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.batcher = batcher
+		pipeline.handle = batcher.Add
+	}
+	if len(quietWindows) > 0 || namespaceWindow != nil {
+		quiet, err := NewQuietGate(quietWindows, pipeline.handle)
+		if err != nil {
+			return nil, err
+		}
+		quiet.NamespaceWindow = namespaceWindow
+		pipeline.handle = quiet.Handle
+	}
+	return pipeline, nil
+}